@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/apikeys"
+	"github.com/moonwalker/moonbase/internal/cms"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+type ctxKey int
+
+const (
+	ctxKeyAccess ctxKey = iota
+	ctxKeyAPIKey
+)
+
+const oidcTeamPrefix = "oidc:"
+
+// access is what the user may do in a repository, a nil access allows everything
+// as repositories without roles are open to everyone with push access
+type access struct {
+	roles  *cms.Roles
+	member []string
+	key    *apikeys.Key
+}
+
+// readRoles reads the roles config of the repository, nil when the repository has none
+func readRoles(ctx context.Context, owner, repo, ref string) (*cms.Roles, error) {
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	path := filepath.Join(cms.SettingsFolder, cms.RolesConfig)
+	data, _, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+	if err != nil {
+		if errors.Is(err, gh.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	roles, err := cms.ParseRoles(data)
+	if err != nil {
+		return nil, err
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	roles.DefaultLocale = locales.Default()
+	return roles, nil
+}
+
+// getAccess reads the roles config of the repository and resolves the roles of the user
+func getAccess(r *http.Request, owner, repo, ref string) (*access, error) {
+	ctx := r.Context()
+
+	roles, err := readRoles(ctx, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// api keys are limited to the roles their creator had
+	if key := apiKeyFromContext(ctx); key != nil {
+		if roles == nil {
+			return &access{key: key}, nil
+		}
+		return &access{roles: roles, member: key.Roles, key: key}, nil
+	}
+
+	if roles == nil {
+		// users acting with the service credential only get what roles grant them
+		if gh.Delegated(ctx) {
+			return &access{roles: &cms.Roles{}}, nil
+		}
+		return nil, nil
+	}
+
+	teams := make([]string, 0)
+	if gh.Delegated(ctx) {
+		// groups of the oidc provider are teams prefixed with oidc:
+		for _, g := range gh.SessionFromContext(ctx).Groups {
+			teams = append(teams, oidcTeamPrefix+g)
+		}
+	} else {
+		for _, m := range roles.Members {
+			if len(m.Teams) > 0 {
+				teams, err = gh.GetUserTeams(ctx, gh.UserTokenFromContext(ctx))
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+
+	return &access{roles: roles, member: roles.MemberRoles(gh.UserFromContext(ctx), teams)}, nil
+}
+
+func (a *access) allowed(action, collection string, locales ...string) bool {
+	if a == nil {
+		return true
+	}
+	if a.key != nil && !a.key.Allows(action, collection) {
+		return false
+	}
+	if a.roles == nil {
+		return true
+	}
+	if len(locales) == 0 {
+		return a.roles.Allowed(a.member, action, collection, "")
+	}
+	for _, l := range locales {
+		if !a.roles.Allowed(a.member, action, collection, l) {
+			return false
+		}
+	}
+	return true
+}
+
+func accessFromContext(ctx context.Context) *access {
+	a, _ := ctx.Value(ctxKeyAccess).(*access)
+	return a
+}
+
+// checkAccess returns a forbidden error when the access of the request does not grant the action
+func checkAccess(ctx context.Context, action, collection string, locales ...string) (*errorData, error) {
+	if accessFromContext(ctx).allowed(action, collection, locales...) {
+		return nil, nil
+	}
+
+	m := fmt.Sprintf("%s not allowed", action)
+	if len(collection) > 0 {
+		m = fmt.Sprintf("%s not allowed on %s", action, collection)
+	}
+	if len(locales) > 0 {
+		m = fmt.Sprintf("%s %v", m, locales)
+	}
+	return errAuthForbidden().Details(m), fmt.Errorf("%s: %s", gh.UserFromContext(ctx), m)
+}
+
+// authorize loads the access of the user to the repository of the route and
+// rejects the request unless it grants the action on the collection of the route
+func authorize(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			owner := chi.URLParam(r, "owner")
+			repo := chi.URLParam(r, "repo")
+			ref := chi.URLParam(r, "ref")
+			collection := chi.URLParam(r, "collection")
+
+			r, e, err := repoAccess(r, action)
+			if e != nil {
+				e.Log(r, err).Json(w)
+				return
+			}
+
+			// api keys carry their own permissions on top of roles
+			if key := apiKeyFromContext(r.Context()); key != nil {
+				if !key.Bound(owner, repo, ref) {
+					errAuthForbidden().Details("api key not valid for "+refKey(owner, repo, ref)).Log(r, fmt.Errorf("api key %s", key.ID)).Json(w)
+					return
+				}
+				if key.PublishedOnly() && !deliveryRoute(r) {
+					errAuthForbidden().Details("route not available to "+key.Scope+" keys").Log(r, fmt.Errorf("api key %s", key.ID)).Json(w)
+					return
+				}
+			}
+
+			a, err := getAccess(r, owner, repo, ref)
+			if err != nil {
+				errAuthRoles().Log(r, err).Json(w)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKeyAccess, a)
+			if e, err := checkAccess(ctx, action, collection); e != nil {
+				e.Log(r, err).Json(w)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// deliveryRoutes are the only routes of keys limited to published content,
+// each of them leaves out what is not published
+var deliveryRoutes = map[string]bool{
+	"GET /cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}":     true,
+	"GET /cms/{owner}/{repo}/{ref}/reference/{collection}/{id}/{locale}": true,
+	"GET /cms/{owner}/{repo}/{ref}/query/{collection}":                   true,
+	"GET /cms/{owner}/{repo}/{ref}/search":                               true,
+}
+
+func deliveryRoute(r *http.Request) bool {
+	return deliveryRoutes[r.Method+" "+chi.RouteContext(r.Context()).RoutePattern()]
+}
+
+// repoAccess switches a request to the installation token when running as a github app,
+// once the repository permission of the user allows the action
+func repoAccess(r *http.Request, action string) (*http.Request, *errorData, error) {
+	if !gh.AppEnabled() {
+		return r, nil, nil
+	}
+
+	ctx := r.Context()
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+
+	token, resp, err := gh.InstallationToken(ctx, owner, repo)
+	if err != nil {
+		e := errAuthApp()
+		if resp != nil && resp.StatusCode != http.StatusNotFound {
+			e.Github(err)
+		}
+		return r, e, err
+	}
+
+	// users without a github account have no repository permission, roles decide
+	if gh.Delegated(ctx) {
+		return r.WithContext(gh.WithRepoToken(ctx, token)), nil, nil
+	}
+
+	// api keys act with the permission of the user who created them
+	login := gh.UserFromContext(ctx)
+	if key := apiKeyFromContext(ctx); key != nil {
+		login = key.CreatedBy
+	}
+
+	permission, resp, err := gh.GetPermission(ctx, token, owner, repo, login)
+	if err != nil {
+		e := errAuthApp()
+		if resp != nil {
+			e.Github(err)
+		}
+		return r, e, err
+	}
+
+	allowed := gh.CanWrite(permission)
+	if action == cms.ActionRead {
+		allowed = gh.CanRead(permission)
+	}
+	if !allowed {
+		m := fmt.Sprintf("%s has %s permission on %s/%s", login, permission, owner, repo)
+		return r, errAuthForbidden().Details(m), errors.New(m)
+	}
+
+	return r.WithContext(gh.WithRepoToken(ctx, token)), nil, nil
+}
+
+// githubUsersOnly rejects users without a github account on routes not covered by roles
+func githubUsersOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gh.Delegated(r.Context()) {
+			errAuthForbidden().Details("github account required").Log(r, fmt.Errorf("%s has no github account", gh.UserFromContext(r.Context()))).Json(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRepoAccess is repoAccess for routes which are not bound to a ref
+func withRepoAccess(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, e, err := repoAccess(r, action)
+			if e != nil {
+				e.Log(r, err).Json(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/moonwalker/moonbase/internal/apikeys"
+	"github.com/moonwalker/moonbase/internal/cms"
+)
+
+func TestKeyAccess(t *testing.T) {
+	roles := &cms.Roles{Members: []*cms.Member{{Role: cms.RoleAuthor, Users: []string{"alice"}}}}
+	management := &apikeys.Key{Scope: apikeys.ScopeManagement, Collections: []string{"posts"}}
+
+	tests := []struct {
+		name       string
+		access     *access
+		action     string
+		collection string
+		allowed    bool
+	}{
+		{"scope without roles", &access{key: management}, cms.ActionDelete, "posts", true},
+		{"collection without roles", &access{key: management}, cms.ActionDelete, "pages", false},
+		{"creator role", &access{key: management, roles: roles, member: []string{cms.RoleAuthor}}, cms.ActionUpdate, "posts", true},
+		{"beyond creator role", &access{key: management, roles: roles, member: []string{cms.RoleAuthor}}, cms.ActionDelete, "posts", false},
+		{"beyond key scope", &access{key: management, roles: roles, member: []string{cms.RoleAdmin}}, cms.ActionSettings, "", false},
+		{"creator without roles", &access{key: management, roles: roles}, cms.ActionRead, "posts", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := tt.access.allowed(tt.action, tt.collection); allowed != tt.allowed {
+				t.Errorf("expected allowed %t, got %t", tt.allowed, allowed)
+			}
+		})
+	}
+}
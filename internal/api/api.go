@@ -2,7 +2,8 @@ package api
 
 import (
 	"github.com/go-chi/chi/v5"
-	gh "github.com/moonwalker/moonbase/pkg/github"
+
+	"github.com/moonwalker/moonbase/internal/cms"
 )
 
 // @title Moonbase
@@ -35,45 +36,103 @@ func Routes() chi.Router {
 	r.Get("/login/github/authenticate", authenticateHandler)
 	r.Get("/login/github/authenticate/{code}", authenticateHandler)
 
+	// oidc login, for users without a github account
+	r.Get("/login/oidc", oidcAuth)
+	r.Get("/login/oidc/callback", oidcCallback)
+	r.Get("/login/oidc/authenticate", oidcAuthenticate)
+	r.Get("/login/oidc/authenticate/{code}", oidcAuthenticate)
+
+	// renew the access token of a session
+	r.Post("/login/refresh", postRefresh)
+
+	// github push webhook
+	r.Post("/hooks/github", githubHook)
+
 	// api routes which needs authenticated user token
 	r.Group(func(r chi.Router) {
-		r.Use(gh.WithUser)
+		r.Use(withAuth)
+		r.Use(withRateLimit)
 		// low level github apis
 		r.Group(func(r chi.Router) {
-			r.Get("/repos", getRepos)
-			r.Get("/repos/{owner}/{repo}/branches", getBranches)
-			r.Get("/repos/{owner}/{repo}/tree/{ref}", getTree)
-			r.Get("/repos/{owner}/{repo}/tree/{ref}/*", getTree)
-			r.Get("/repos/{owner}/{repo}/blob/{ref}/*", getBlob)
-			r.Post("/repos/{owner}/{repo}/blob/{ref}/*", postBlob)
-			r.Delete("/repos/{owner}/{repo}/blob/{ref}/*", delBlob)
+			r.Use(usersOnly)
+			// sessions
+			r.Post("/logout", postLogout)
+			r.Post("/logout/all", postLogoutAll)
+			r.Get("/sessions", getSessions)
+			r.Delete("/sessions/{id}", delSession)
+			r.With(adminsOnly).Get("/admin/sessions", getAdminSessions)
+			r.With(adminsOnly).Delete("/admin/sessions/{id}", delAdminSession)
+			r.With(adminsOnly).Delete("/admin/users/{login}/sessions", delAdminUserSessions)
+			r.With(adminsOnly).Get("/admin/audit", getAudit)
+			r.With(adminsOnly).Get("/admin/ratelimits", getRateLimits)
+			// repos
+			r.With(githubUsersOnly).Get("/repos", getRepos)
+			r.With(githubUsersOnly, withRepoAccess(cms.ActionRead)).Get("/repos/{owner}/{repo}/branches", getBranches)
+			r.With(authorize(cms.ActionRead)).Get("/repos/{owner}/{repo}/tree/{ref}", getTree)
+			r.With(authorize(cms.ActionRead)).Get("/repos/{owner}/{repo}/tree/{ref}/*", getTree)
+			r.With(authorize(cms.ActionRead)).Get("/repos/{owner}/{repo}/blob/{ref}/*", getBlob)
+			r.With(authorize(cms.ActionSettings)).Post("/repos/{owner}/{repo}/blob/{ref}/*", postBlob)
+			r.With(authorize(cms.ActionSettings)).Delete("/repos/{owner}/{repo}/blob/{ref}/*", delBlob)
 		})
 		// higher level cms apis
 		r.Group(func(r chi.Router) {
 			// dashboard
-			r.Get("/cms/{owner}/{repo}/{ref}", getInfo)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}", getInfo)
 			// collections
-			r.Get("/cms/{owner}/{repo}/{ref}/collections", getCollections)
-			r.Post("/cms/{owner}/{repo}/{ref}/collections", postCollection)
-			r.Delete("/cms/{owner}/{repo}/{ref}/collections/{collection}", delCollection)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/collections", getCollections)
+			r.With(authorize(cms.ActionSchema)).Post("/cms/{owner}/{repo}/{ref}/collections", postCollection)
+			r.With(authorize(cms.ActionSchema)).Delete("/cms/{owner}/{repo}/{ref}/collections/{collection}", delCollection)
 			// entries
-			r.Get("/cms/{owner}/{repo}/{ref}/collections/{collection}", getEntries)
-			r.Get("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}", getEntry)
-			r.Post("/cms/{owner}/{repo}/{ref}/collections/{collection}", postEntry)
-			r.Put("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}", putEntry)
-			r.Delete("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}", delEntry)
-
-			r.Post("/cms/{owner}/{repo}/{ref}/_images", postImage)
-
-			r.Get("/cms/{owner}/{repo}/{ref}/settings", getSettings)
-			r.Get("/cms/{owner}/{repo}/{ref}/settings/{setting}", getSetting)
-			r.Post("/cms/{owner}/{repo}/{ref}/settings/{setting}", postSetting)
-			r.Delete("/cms/{owner}/{repo}/{ref}/settings/{setting}", delSetting)
-
-			r.Get("/cms/{owner}/{repo}/{ref}/collectiongroups", getCollectionGroups)
-			r.Get("/cms/{owner}/{repo}/{ref}/collectiongroups/{group}", getCollectionGroup)
-
-			r.Get("/cms/{owner}/{repo}/{ref}/reference/{collection}/{id}/{locale}", getReference)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/collections/{collection}", getEntries)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}", getEntry)
+			r.With(authorize(cms.ActionCreate)).Post("/cms/{owner}/{repo}/{ref}/collections/{collection}", postEntry)
+			r.With(authorize(cms.ActionUpdate)).Put("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}", putEntry)
+			r.With(authorize(cms.ActionDelete)).Delete("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}", delEntry)
+			r.With(authorize(cms.ActionPublish)).Post("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}/publish", publishEntry)
+			r.With(authorize(cms.ActionPublish)).Post("/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}/unpublish", unpublishEntry)
+
+			r.With(authorize(cms.ActionCreate)).Post("/cms/{owner}/{repo}/{ref}/_images", postImage)
+
+			r.With(authorize(cms.ActionRead)).Post("/cms/{owner}/{repo}/{ref}/transactions", postTransaction)
+
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/settings", getSettings)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/settings/{setting}", getSetting)
+			r.With(authorize(cms.ActionSettings)).Post("/cms/{owner}/{repo}/{ref}/settings/{setting}", postSetting)
+			r.With(authorize(cms.ActionSettings)).Delete("/cms/{owner}/{repo}/{ref}/settings/{setting}", delSetting)
+
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/locales", listLocales)
+			r.With(authorize(cms.ActionSettings)).Post("/cms/{owner}/{repo}/{ref}/locales", postLocale)
+			r.With(authorize(cms.ActionSettings)).Put("/cms/{owner}/{repo}/{ref}/locales/{locale}", putLocale)
+			r.With(authorize(cms.ActionSettings)).Delete("/cms/{owner}/{repo}/{ref}/locales/{locale}", delLocale)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/translations/{collection}", getTranslationReport)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/translations/_export", exportTranslations)
+			r.With(authorize(cms.ActionRead)).Post("/cms/{owner}/{repo}/{ref}/translations/_import", importTranslations)
+
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/collectiongroups", getCollectionGroups)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/collectiongroups/{group}", getCollectionGroup)
+
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/reference/{collection}/{id}/{locale}", getReference)
+
+			// trash
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/trash", getTrash)
+			r.With(authorize(cms.ActionDelete)).Delete("/cms/{owner}/{repo}/{ref}/trash", purgeTrash)
+			r.With(authorize(cms.ActionCreate)).Post("/cms/{owner}/{repo}/{ref}/trash/{id}/restore", restoreTrash)
+			r.With(authorize(cms.ActionDelete)).Delete("/cms/{owner}/{repo}/{ref}/trash/{id}", purgeTrashItem)
+
+			// webhooks
+			r.With(authorize(cms.ActionSettings)).Get("/cms/{owner}/{repo}/{ref}/webhooks/deliveries", getDeliveries)
+			r.With(authorize(cms.ActionSettings)).Post("/cms/{owner}/{repo}/{ref}/webhooks/deliveries/{id}/redeliver", redeliverWebhook)
+
+			// api keys
+			r.With(authorize(cms.ActionSettings)).Get("/cms/{owner}/{repo}/{ref}/apikeys", getAPIKeys)
+			r.With(authorize(cms.ActionSettings)).Post("/cms/{owner}/{repo}/{ref}/apikeys", postAPIKey)
+			r.With(authorize(cms.ActionSettings)).Delete("/cms/{owner}/{repo}/{ref}/apikeys/{id}", delAPIKey)
+
+			// content index
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/index", getIndexStatus)
+			r.With(authorize(cms.ActionSettings)).Post("/cms/{owner}/{repo}/{ref}/index", rebuildIndex)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/query/{collection}", queryEntries)
+			r.With(authorize(cms.ActionRead)).Get("/cms/{owner}/{repo}/{ref}/search", searchEntries)
 
 		})
 	})
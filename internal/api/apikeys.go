@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/apikeys"
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/store"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+const apiKeyHeader = "X-Api-Key"
+
+type apiKeyPayload struct {
+	Name        string     `json:"name"`
+	Scope       string     `json:"scope"`
+	Refs        []string   `json:"refs,omitempty"`
+	Collections []string   `json:"collections,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+type apiKeyCreated struct {
+	*apikeys.Key
+	Secret string `json:"secret"`
+}
+
+// withAuth authenticates the request either with an api key or with the token of a github login
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plain := r.Header.Get(apiKeyHeader)
+		if bearer := r.Header.Get("Authorization"); len(plain) == 0 && len(bearer) > 7 && strings.HasPrefix(bearer[7:], apikeys.Prefix) {
+			plain = bearer[7:]
+		}
+		if len(plain) == 0 {
+			authenticated := false
+			gh.WithUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authenticated = true
+				next.ServeHTTP(w, r)
+			})).ServeHTTP(w, r)
+			if !authenticated {
+				auditLog(r, &audit.Record{Action: audit.ActionAuthFailure, Outcome: audit.OutcomeFailure, Path: r.URL.Path, Details: "invalid token"})
+			}
+			return
+		}
+
+		key, accessToken, err := apikeys.Default.Verify(plain)
+		if err != nil {
+			errAuthBadToken().Log(r, err).Json(w)
+			return
+		}
+
+		ctx := gh.WithAccessToken(r.Context(), accessToken, "apikey:"+key.Name)
+		ctx = context.WithValue(ctx, ctxKeyAPIKey, key)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// usersOnly rejects api keys on routes which are not bound to a repository
+func usersOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := apiKeyFromContext(r.Context()); key != nil {
+			errAuthForbidden().Details("not allowed with api keys").Log(r, fmt.Errorf("api key %s", key.ID)).Json(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func apiKeyFromContext(ctx context.Context) *apikeys.Key {
+	key, _ := ctx.Value(ctxKeyAPIKey).(*apikeys.Key)
+	return key
+}
+
+// publishedOnly reports whether the request may only see published content
+func publishedOnly(ctx context.Context) bool {
+	key := apiKeyFromContext(ctx)
+	return key != nil && key.PublishedOnly()
+}
+
+// @Summary		List api keys
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Success		200	{object}	[]apikeys.Key
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/apikeys	[get]
+// @Security	bearerToken
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+
+	keys, err := apikeys.Default.List(owner, repo)
+	if err != nil {
+		errAPIKeysList().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, keys)
+}
+
+// @Summary		Create api key
+// @Description	the secret of the key is only returned once, the key acts with the github access and the roles of its creator, settings permission is required on every ref of the key
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string			true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string			true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string			true	"git ref (branch, tag, sha)"
+// @Param		payload			body	apiKeyPayload	true	"api key payload, refs default to the ref of the request"
+// @Success		201	{object}	apiKeyCreated
+// @Failure		400	{object}	errorData
+// @Failure		403	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/apikeys	[post]
+// @Security	bearerToken
+func postAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	payload := &apiKeyPayload{}
+	err := json.NewDecoder(r.Body).Decode(payload)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+	if len(payload.Refs) == 0 {
+		payload.Refs = []string{ref}
+	}
+	if payload.ExpiresAt != nil && payload.ExpiresAt.Before(time.Now()) {
+		m := "expiry in the past"
+		errAPIKeysCreate().Details(m).Log(r, errors.New(m)).Json(w)
+		return
+	}
+
+	// the settings permission was checked on the ref of the request, the other refs need it too
+	for _, other := range payload.Refs {
+		if other == ref {
+			continue
+		}
+		a, err := getAccess(r, owner, repo, other)
+		if err != nil {
+			errAuthRoles().Github(err).Log(r, err).Json(w)
+			return
+		}
+		if !a.allowed(cms.ActionSettings, "") {
+			m := fmt.Sprintf("%s not allowed on %s", cms.ActionSettings, other)
+			errAuthForbidden().Details(m).Log(r, fmt.Errorf("%s: %s", gh.UserFromContext(ctx), m)).Json(w)
+			return
+		}
+	}
+
+	// keys never get more than the roles of their creator
+	var roles []string
+	if a := accessFromContext(ctx); a != nil {
+		roles = a.member
+	}
+
+	key := &apikeys.Key{
+		Name:        payload.Name,
+		Scope:       payload.Scope,
+		Owner:       owner,
+		Repo:        repo,
+		Refs:        payload.Refs,
+		Collections: payload.Collections,
+		Roles:       roles,
+		CreatedBy:   gh.UserFromContext(ctx),
+		ExpiresAt:   payload.ExpiresAt,
+	}
+	secret, err := apikeys.Default.Create(key, accessToken)
+	if err != nil {
+		errAPIKeysCreate().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionAPIKeyCreate, Path: key.ID, Details: key.Scope})
+	jsonResponse(w, http.StatusCreated, &apiKeyCreated{key, secret})
+}
+
+// @Summary		Revoke api key
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		id				path	string	true	"api key id"
+// @Success		200	{object}	apikeys.Key
+// @Failure		404	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/apikeys/{id}	[delete]
+// @Security	bearerToken
+func delAPIKey(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	id := chi.URLParam(r, "id")
+
+	key, err := apikeys.Default.Revoke(owner, repo, id)
+	if err != nil {
+		e := errAPIKeysRevoke()
+		if errors.Is(err, store.ErrNotFound) {
+			e.Status(http.StatusNotFound)
+		}
+		e.Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionAPIKeyRevoke, Path: key.ID})
+	jsonResponse(w, http.StatusOK, key)
+}
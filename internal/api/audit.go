@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/audit"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+// auditLog records an action of the request, request id, ip, user and the
+// repository of the route are filled in unless the record has them
+func auditLog(r *http.Request, rec *audit.Record) {
+	ctx := r.Context()
+	rec.RequestID = middleware.GetReqID(ctx)
+	rec.IP = r.RemoteAddr
+	if len(rec.User) == 0 {
+		rec.User = gh.UserFromContext(ctx)
+	}
+	if len(rec.Owner) == 0 {
+		rec.Owner = chi.URLParam(r, "owner")
+		rec.Repo = chi.URLParam(r, "repo")
+		rec.Ref = chi.URLParam(r, "ref")
+	}
+	audit.Log(rec)
+}
+
+// auditCommit records a change committed by the request
+func auditCommit(r *http.Request, action, path string, commit *github.Commit) {
+	auditLog(r, &audit.Record{Action: action, Path: path, Commit: commit.GetSHA()})
+}
+
+// auditError records rejected authentication and denied access
+func auditError(r *http.Request, e *errorData) {
+	rec := &audit.Record{Path: r.URL.Path, Details: strings.Join(append([]string{e.Message}, e.Detailed...), ": ")}
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		rec.Action, rec.Outcome = audit.ActionAuthFailure, audit.OutcomeFailure
+	case http.StatusForbidden:
+		rec.Action, rec.Outcome = audit.ActionDenied, audit.OutcomeDenied
+	default:
+		return
+	}
+	auditLog(r, rec)
+}
+
+// @Summary		Query audit log
+// @Description	records newest first, action matches by prefix (entry matches entry.create) and so does path
+// @Tags		admin
+// @Accept		json
+// @Produce		json
+// @Param		user			query	string	false	"user login"
+// @Param		action			query	string	false	"action"
+// @Param		outcome			query	string	false	"success, failure or denied"
+// @Param		owner			query	string	false	"repository owner"
+// @Param		repo			query	string	false	"repository name"
+// @Param		ref				query	string	false	"git ref"
+// @Param		path			query	string	false	"target path prefix"
+// @Param		from			query	string	false	"RFC 3339 time, inclusive"
+// @Param		to				query	string	false	"RFC 3339 time, exclusive"
+// @Param		limit			query	int		false	"maximum number of records"
+// @Success		200	{object}	[]audit.Record
+// @Failure		400	{object}	errorData
+// @Router		/admin/audit	[get]
+// @Security	bearerToken
+func getAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := &audit.Filter{
+		User:    q.Get("user"),
+		Action:  q.Get("action"),
+		Outcome: q.Get("outcome"),
+		Owner:   q.Get("owner"),
+		Repo:    q.Get("repo"),
+		Ref:     q.Get("ref"),
+		Path:    q.Get("path"),
+	}
+
+	var err error
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); len(v) > 0 {
+			*t, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				errAuditQuery().Details(name).Log(r, err).Json(w)
+				return
+			}
+		}
+	}
+	if v := q.Get("limit"); len(v) > 0 {
+		f.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			errAuditQuery().Details("limit").Log(r, err).Json(w)
+			return
+		}
+	}
+
+	records, err := audit.Query(f)
+	if err != nil {
+		e := errAuditRead()
+		if errors.Is(err, audit.ErrNotQueryable) {
+			e.Status(http.StatusNotImplemented)
+		}
+		e.Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, records)
+}
@@ -5,8 +5,11 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/go-github/v48/github"
 	"github.com/rs/xid"
 
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/internal/sessions"
 	gh "github.com/moonwalker/moonbase/pkg/github"
 )
 
@@ -75,23 +78,64 @@ func authenticateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	et, err := gh.EncryptAccessToken(token)
+	session := &sessions.Session{
+		Login:     ghUser.GetLogin(),
+		Name:      ghUser.GetName(),
+		Email:     ghUser.GetEmail(),
+		Image:     ghUser.GetAvatarURL(),
+		UserAgent: r.UserAgent(),
+		IP:        r.RemoteAddr,
+	}
+	refreshToken, err := sessions.Default.Create(session, token)
+	if err != nil {
+		errAuthSession().Log(r, err).Json(w)
+		return
+	}
+
+	usr, err := loginUser(githubUser(ghUser), token, session.ID, refreshToken)
 	if err != nil {
 		errAuthEncToken().Log(r, err).Json(w)
 		return
 	}
 
+	auditLog(r, &audit.Record{Action: audit.ActionLogin, User: session.Login, Details: session.Provider})
+
+	jsonResponse(w, http.StatusOK, usr)
+}
+
+// loginUser returns the user with a new access token of the session
+func loginUser(usr *gh.User, token string, sessionID string, refreshToken string) (*gh.User, error) {
+	et, err := gh.EncryptAccessToken(token, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	usr.Token = et
+	usr.RefreshToken = refreshToken
+	usr.ExpiresIn = int(gh.AccessTokenExpires.Seconds())
+	return usr, nil
+}
+
+func githubUser(ghUser *github.User) *gh.User {
 	usr := &gh.User{
 		Login: ghUser.Login,
 		Email: ghUser.Email,
 		Image: ghUser.AvatarURL,
-		Token: et,
 	}
 
 	if usr.Email == nil {
-		e := fmt.Sprintf("%d+%s@users.noreply.github.com", *ghUser.ID, *ghUser.Login)
+		e := fmt.Sprintf("%d+%s@users.noreply.github.com", ghUser.GetID(), ghUser.GetLogin())
 		usr.Email = &e
 	}
 
-	jsonResponse(w, http.StatusOK, usr)
+	return usr
+}
+
+// sessionUser is the user of a session without a github account
+func sessionUser(s *sessions.Session) *gh.User {
+	usr := &gh.User{Login: &s.Login, Email: &s.Email}
+	if len(s.Image) > 0 {
+		usr.Image = &s.Image
+	}
+	return usr
 }
@@ -17,22 +17,29 @@ import (
 	"golang.org/x/text/language"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/go-github/v48/github"
 	"github.com/gosimple/slug"
 
 	"github.com/moonwalker/moonbase/pkg/content"
 
-	"github.com/moonwalker/moonbase/internal/cache"
+	"github.com/moonwalker/moonbase/internal/audit"
 	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/events"
 	gh "github.com/moonwalker/moonbase/pkg/github"
 )
 
+const (
+	statusDraft     = "draft"
+	statusChanged   = "changed"
+	statusPublished = "published"
+)
+
+// payloads carry no login, audit fields are set from the authenticated user
 type collectionPayload struct {
-	Login string `json:"login"`
-	Name  string `json:"name"`
+	Name string `json:"name"`
 }
 
 type entryPayload struct {
-	Login      string `json:"login"`
 	Name       string `json:"name"`
 	Contents   string `json:"contents"`
 	SaveSchema bool   `json:"save_schema"`
@@ -45,7 +52,6 @@ type commitEntry struct {
 }
 
 type ComponentsTree map[string]string
-type ComponentsTreeSha string
 
 type localizedEntry struct {
 	Name    string                     `json:"name"`
@@ -68,6 +74,11 @@ type collectionGroup struct {
 type entryResponse struct {
 }
 
+type entryConflict struct {
+	*errorData
+	Current *localizedEntry `json:"current,omitempty"`
+}
+
 type queryParams struct {
 	Page    int64  `url:"page"`
 	Limit   int64  `url:"limit"`
@@ -95,9 +106,9 @@ type listResponse struct {
 	Order      *orderBy               `json:"order,omitempty"`
 }
 
-var (
-	shaCache = cache.NewGeneric[ComponentsTreeSha](30 * time.Minute)
-)
+func refKey(owner, repo, ref string) string {
+	return strings.ToLower(owner + "/" + repo + "@" + ref)
+}
 
 func commitMessage(collection, method, name string) string {
 	return fmt.Sprintf("feat(%s): %s %s", collection, method, name)
@@ -118,6 +129,188 @@ func getSchema(ctx context.Context, accessToken string, owner string, repo strin
 	return cms.NewSchema(data)
 }
 
+// entry
+
+func getContentSchema(ctx context.Context, accessToken string, owner string, repo string, ref string, workdir string, collection string) (*content.Schema, *github.Response, error) {
+	schemaPath := filepath.Join(workdir, collection, content.JsonSchemaName)
+	sc, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, schemaPath)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	cs := &content.Schema{}
+	err = json.Unmarshal(sc, &cs)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return cs, resp, nil
+}
+
+// getCurrentEntry loads the stored state of an entry together with its version tag
+func getCurrentEntry(ctx context.Context, accessToken string, owner string, repo string, ref string, workdir string, collection string, id string, cs *content.Schema) (*localizedEntry, string, *github.Response, error) {
+	path := filepath.Join(workdir, collection, id)
+	rc, resp, err := gh.GetAllLocaleContents(ctx, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return nil, "", resp, err
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		return nil, "", resp, err
+	}
+
+	mc, err := cms.MergeLocalisedContent(rc, *cs, locales)
+	if err != nil {
+		return nil, "", resp, err
+	}
+
+	tag, resp, err := entryTag(ctx, accessToken, owner, repo, ref, workdir, collection, id)
+	if err != nil {
+		return nil, "", resp, err
+	}
+
+	return &localizedEntry{Name: mc.ID, Type: "blob", Content: mc, Schema: *cs}, tag, resp, nil
+}
+
+// entryTag returns the version tag of an entry, it changes with any locale file of the entry,
+// with the collection schema and with the locales config, as all of them make up the entry returned to clients
+func entryTag(ctx context.Context, accessToken string, owner string, repo string, ref string, workdir string, collection string, id string) (string, *github.Response, error) {
+	rc, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, filepath.Join(workdir, collection, id))
+	if err != nil {
+		return "", resp, err
+	}
+
+	files := make([]*github.RepositoryContent, 0)
+	for _, c := range rc {
+		if c.GetType() == "file" && filepath.Ext(c.GetName()) == ".json" {
+			files = append(files, c)
+		}
+	}
+
+	schemaPath := filepath.Join(workdir, collection, content.JsonSchemaName)
+	sha, resp, err := gh.GetPathSHA(ctx, accessToken, owner, repo, ref, schemaPath)
+	if err != nil && !errors.Is(err, gh.ErrNotFound) {
+		return "", resp, err
+	}
+	if err == nil {
+		files = append(files, &github.RepositoryContent{Path: &schemaPath, SHA: &sha})
+	}
+
+	localesPath := filepath.Join(cms.SettingsFolder, cms.LocalesConfig)
+	localesSHA, resp, err := gh.GetPathSHA(ctx, accessToken, owner, repo, ref, localesPath)
+	if err != nil && !errors.Is(err, gh.ErrNotFound) {
+		return "", resp, err
+	}
+	if err == nil {
+		files = append(files, &github.RepositoryContent{Path: &localesPath, SHA: &localesSHA})
+	}
+
+	return cms.EntryTag(files), resp, nil
+}
+
+// entryWrite is a create or update of an entry resolved against its stored state
+type entryWrite struct {
+	Items   []gh.BlobEntry
+	Current *localizedEntry
+	Tag     string
+}
+
+// prepareEntryWrite fills the audit fields of the content and returns the blobs to commit,
+// stale writes are rejected with a conflict carrying the current state of the entry. The entry
+// is read at the base commit, which the blobs have to be committed with.
+func prepareEntryWrite(ctx context.Context, accessToken string, owner string, repo string, base string, cmsConfig *cms.Config, cs *content.Schema, locales cms.Locales, collection string, entry string, etag string, contentData *content.MergedContentData) (*entryWrite, *errorData, error) {
+	// names of the content end up in paths of the repository
+	for _, name := range []string{collection, contentData.ID} {
+		if err := cms.ValidName(name); err != nil {
+			return nil, errCmsName().Details(err.Error()), err
+		}
+	}
+
+	ew := &entryWrite{}
+	login := gh.UserFromContext(ctx)
+
+	// entries of collections without a schema are still checked for conflicts
+	readSchema := cs
+	if readSchema == nil {
+		readSchema = &content.Schema{}
+	}
+	current, tag, resp, err := getCurrentEntry(ctx, accessToken, owner, repo, base, cmsConfig.WorkDir, collection, contentData.ID, readSchema)
+	if err != nil && !errors.Is(err, gh.ErrNotFound) {
+		e := errReposGetBlob()
+		if resp != nil {
+			e.Github(err)
+		}
+		return nil, e, err
+	}
+	ew.Current, ew.Tag = current, tag
+
+	// translators and other locale bound roles may only touch the locales they were granted
+	var currentContent *content.MergedContentData
+	if ew.Current != nil {
+		currentContent = ew.Current.Content
+	}
+	action := cms.ActionUpdate
+	if len(entry) == 0 {
+		action = cms.ActionCreate
+	}
+	if e, err := checkAccess(ctx, action, collection, cms.ChangedLocales(currentContent, *contentData)...); e != nil {
+		return nil, e, err
+	}
+
+	// audit fields sent by the client are never trusted
+	now := time.Now().UTC()
+	contentData.PublishedAt, contentData.PublishedBy = nil, ""
+	if len(entry) == 0 {
+		if ew.Current != nil {
+			return ew, errCmsEntryConflict(), fmt.Errorf("entry already exists: %s", contentData.ID)
+		}
+		contentData.CreatedAt = &now
+		contentData.CreatedBy = login
+		contentData.UpdatedAt, contentData.UpdatedBy = nil, ""
+		contentData.Version = 1
+		contentData.Status = statusDraft
+	} else {
+		contentData.CreatedAt = &now
+		contentData.CreatedBy = login
+		if ew.Current != nil {
+			// the client has to be up to date either by etag or by version
+			if !etagMatches(etag, ew.Tag) || (len(etag) == 0 && contentData.Version != ew.Current.Content.Version) {
+				return ew, errCmsEntryConflict(), fmt.Errorf("entry changed: %s", contentData.ID)
+			}
+			contentData.CreatedAt = ew.Current.Content.CreatedAt
+			contentData.CreatedBy = ew.Current.Content.CreatedBy
+			contentData.PublishedAt = ew.Current.Content.PublishedAt
+			contentData.PublishedBy = ew.Current.Content.PublishedBy
+			contentData.Version = ew.Current.Content.Version
+		}
+		contentData.UpdatedAt = &now
+		contentData.UpdatedBy = login
+		contentData.Version = contentData.Version + 1
+		contentData.Status = statusChanged
+	}
+
+	if cs != nil {
+		cms.TrackTranslations(currentContent, contentData, *cs, locales)
+	}
+
+	items, err := cms.SeparateLocalisedContent(*contentData, locales, cmsConfig.WorkDir, collection)
+	if err != nil {
+		return nil, errCmsSeparateLocalizedContent(), err
+	}
+	ew.Items = items
+
+	return ew, nil, nil
+}
+
+func entryConflictResponse(w http.ResponseWriter, r *http.Request, e *errorData, current *localizedEntry, tag string, err error) {
+	e.Log(r, err)
+	if len(tag) > 0 {
+		w.Header().Set("ETag", quoteETag(tag))
+	}
+	jsonResponse(w, e.StatusCode, &entryConflict{e, current})
+}
+
 // info
 
 // @Summary		Get info
@@ -139,9 +332,9 @@ func getInfo(w http.ResponseWriter, r *http.Request) {
 	repo := chi.URLParam(r, "repo")
 	ref := chi.URLParam(r, "ref")
 
-	rc, resp, err := gh.GetCommits(ctx, accessToken, owner, repo, ref)
+	rc, _, err := gh.GetCommits(ctx, accessToken, owner, repo, ref)
 	if err != nil {
-		errCmsGetCommits().Status(resp.StatusCode).Log(r, err).Json(w)
+		errCmsGetCommits().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -180,15 +373,19 @@ func getCollections(w http.ResponseWriter, r *http.Request) {
 
 	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
 
-	repoContents, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir)
+	if pathNotModified(w, r, accessToken, owner, repo, ref, cmsConfig.WorkDir) {
+		return
+	}
+
+	repoContents, _, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir)
 	if err != nil {
-		errReposGetTree().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
 	treeItems := make([]*treeItem, 0)
 	for _, rc := range repoContents {
-		if *rc.Type == "dir" {
+		if *rc.Type == "dir" && !cms.IsReservedFolder(*rc.Name) {
 			treeItems = append(treeItems, &treeItem{
 				Name: rc.Name,
 				Type: rc.Type,
@@ -231,14 +428,16 @@ func postCollection(w http.ResponseWriter, r *http.Request) {
 	collectionName := slug.Make(collection.Name)
 	path := filepath.Join(cmsConfig.WorkDir, collectionName, content.JsonSchemaName)
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	emptyContent := fmt.Sprintf(`{"id":"%s","name":"%s","displayField":"","fields":[],"createdAt":"%s","createdBy":"%s","updatedAt":"%s","updatedBy":"%s","version":0}`, collection.Name, cases.Title(language.Und, cases.NoLower).String(collection.Name), now, collection.Login, now, collection.Login)
+	emptyContent := fmt.Sprintf(`{"id":"%s","name":"%s","displayField":"","fields":[],"createdAt":"%s","createdBy":"%s","updatedAt":"%s","updatedBy":"%s","version":0}`, collection.Name, cases.Title(language.Und, cases.NoLower).String(collection.Name), now, gh.UserFromContext(ctx), now, gh.UserFromContext(ctx))
 
-	resp, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &emptyContent, commitMessage("content", "create", collectionName))
+	commit, _, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &emptyContent, commitMessage("content", "create", collectionName))
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
+	emit(r, newEvent(events.CollectionCreate, owner, repo, ref, collectionName, ""), commit)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -249,7 +448,7 @@ func postCollection(w http.ResponseWriter, r *http.Request) {
 // @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
 // @Param		ref				path	string	true	"git ref (branch, tag, sha)"
 // @Param		collection		path	string	true	"collection"
-// @Success		200
+// @Success		200	{object}	trashItem
 // @Failure		500	{object}	errorData
 // @Router		/cms/{owner}/{repo}/{ref}/collections/{collection} [delete]
 // @Security	bearerToken
@@ -272,13 +471,15 @@ func delCollection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := gh.DeleteFolder(ctx, accessToken, owner, repo, ref, path, commitMessage("content", "delete", collectionName))
+	item, commit, _, err := moveToTrash(ctx, accessToken, owner, repo, ref, cms.TrashKindCollection, collectionName, "", path)
 	if err != nil {
-		errCmsDeleteFolder().Status(resp.StatusCode).Log(r, err).Json(w)
+		errCmsDeleteFolder().Github(err).Log(r, err).Json(w)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	emit(r, newEvent(events.CollectionDelete, owner, repo, ref, collectionName, ""), commit)
+
+	jsonResponse(w, http.StatusOK, newTrashItem(item, cmsConfig.TrashRetention()))
 }
 
 // entries
@@ -307,9 +508,13 @@ func getEntries(w http.ResponseWriter, r *http.Request) {
 	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
 	path := filepath.Join(cmsConfig.WorkDir, collection)
 
-	repoContents, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, path)
+	if pathNotModified(w, r, accessToken, owner, repo, ref, path) {
+		return
+	}
+
+	repoContents, _, err := gh.GetTree(ctx, accessToken, owner, repo, ref, path)
 	if err != nil {
-		errReposGetTree().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -336,6 +541,7 @@ func getEntries(w http.ResponseWriter, r *http.Request) {
 // @Param		collection		path	string			true	"collection"
 // @Param		payload			body	entryPayload	true	"entry payload"
 // @Success		200
+// @Failure		409	{object}	entryConflict
 // @Failure		500	{object}	errorData
 // @Router		/cms/{owner}/{repo}/{ref}/collections/{collection}	[post]
 // @Security	bearerToken
@@ -352,7 +558,9 @@ func postEntry(w http.ResponseWriter, r *http.Request) {
 // @Param		collection		path	string			true	"collection"
 // @Param		entry			path	string			true	"entry"
 // @Param		payload			body	entryPayload	true	"entry payload"
+// @Param		If-Match		header	string			false	"etag of the entry version the update is based on"
 // @Success		200
+// @Failure		409	{object}	entryConflict
 // @Failure		500	{object}	errorData
 // @Router		/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}	[put]
 // @Security	bearerToken
@@ -406,37 +614,64 @@ func createOrUpdateEntry(w http.ResponseWriter, r *http.Request) {
 		errCmsReadContent().Log(r, err).Json(w)
 		return
 	}
-	now := time.Now().UTC()
 	if len(entry) == 0 {
 		contentData.ID = entryData.Name
-		contentData.CreatedAt = &now
-		contentData.CreatedBy = entryData.Login
-		contentData.Version = 1
-		contentData.Status = "draft"
-	} else {
-		contentData.UpdatedAt = &now
-		contentData.UpdatedBy = entryData.Login
-		contentData.Version = contentData.Version + 1
-		contentData.Status = "changed"
+	} else if len(contentData.ID) == 0 {
+		contentData.ID = entry
+	}
+
+	cs, _, err := getContentSchema(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection)
+	if err != nil && !errors.Is(err, gh.ErrNotFound) {
+		errCmsParseSchema().Log(r, err).Json(w)
+		return
 	}
 
-	locales, statusCode, err := getLocales(ctx, accessToken, owner, repo, ref)
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
 	if err != nil {
-		errReposGetTree().Status(statusCode).Log(r, err).Json(w)
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
-	items, err := cms.SeparateLocalisedContent(contentData, locales, cmsConfig.WorkDir, collection)
+	// the entry is read at the commit the branch points to now, so the commit
+	// can't overwrite changes landing while the write is prepared
+	base, _, err := gh.GetPathSHA(ctx, accessToken, owner, repo, ref, "")
 	if err != nil {
-		errCmsSeparateLocalizedContent().Log(r, err).Json(w)
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
-	resp, err := gh.CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage(collection, "create/update", entryData.Name))
+	ew, e, err := prepareEntryWrite(ctx, accessToken, owner, repo, base, cmsConfig, cs, locales, collection, entry, r.Header.Get("If-Match"), &contentData)
+	if e != nil {
+		if ew != nil {
+			entryConflictResponse(w, r, e, ew.Current, ew.Tag, err)
+			return
+		}
+		e.Log(r, err).Json(w)
+		return
+	}
+
+	commit, _, err := gh.CommitBlobsAt(ctx, accessToken, owner, repo, ref, base, ew.Items, commitMessage(collection, "create/update", entryData.Name))
+	if errors.Is(err, gh.ErrConflict) {
+		readSchema := cs
+		if readSchema == nil {
+			readSchema = &content.Schema{}
+		}
+		current, tag, _, _ := getCurrentEntry(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection, contentData.ID, readSchema)
+		entryConflictResponse(w, r, errCmsEntryConflict(), current, tag, err)
+		return
+	}
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
+
+	typ := events.EntryUpdate
+	if ew.Current == nil {
+		typ = events.EntryCreate
+	}
+	ev := newEvent(typ, owner, repo, ref, collection, contentData.ID)
+	ev.Locales = locales.Codes()
+	emit(r, ev, commit)
 	// if ext == ".md" || ext == ".mdx" {
 	// 	contentData, err = cms.JsonToMarkdown(contentData)
 	// 	if err != nil {
@@ -448,7 +683,7 @@ func createOrUpdateEntry(w http.ResponseWriter, r *http.Request) {
 	// TODO: Uncomment to save to GH
 	// resp, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &contentData, commitMessage)
 	// if err != nil {
-	// 	errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+	// 	errReposCommitBlob().Github(err).Log(r, err).Json(w)
 	// 	return
 	// }
 
@@ -462,7 +697,7 @@ func createOrUpdateEntry(w http.ResponseWriter, r *http.Request) {
 	// 	schemaCommitMessage := fmt.Sprintf("feat(%s): create/update %s", collection, content.JsonSchemaName)
 	// 	resp, err = gh.CommitBlob(ctx, accessToken, owner, repo, ref, schemaPath, &schema, schemaCommitMessage)
 	// 	if err != nil {
-	// 		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+	// 		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 	// 		return
 	// 	}
 	// }
@@ -494,16 +729,28 @@ func getEntry(w http.ResponseWriter, r *http.Request) {
 	entry := chi.URLParam(r, "entry")
 
 	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	// delivery keys have to find out whether the entry is published before they learn it is unchanged
+	if entry != "_new" && !publishedOnly(ctx) && entryNotModified(w, r, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection, entry) {
+		return
+	}
+
 	schemaPath := filepath.Join(cmsConfig.WorkDir, collection, content.JsonSchemaName)
-	sc, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, schemaPath)
+	sc, _, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, schemaPath)
 	if err != nil {
-		errReposGetBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 	cs := &content.Schema{}
 	err = json.Unmarshal(sc, &cs)
 	if err != nil {
-		errCmsParseSchema().Status(resp.StatusCode).Log(r, err).Json(w)
+		errCmsParseSchema().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -512,23 +759,22 @@ func getEntry(w http.ResponseWriter, r *http.Request) {
 	if entry != "_new" {
 		// Get files in directory
 		path := filepath.Join(cmsConfig.WorkDir, collection, entry)
-		rc, resp, err := gh.GetAllLocaleContents(ctx, accessToken, owner, repo, ref, path)
+		rc, _, err := gh.GetAllLocaleContents(ctx, accessToken, owner, repo, ref, path)
 		if err != nil {
-			errReposGetBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+			if item := findTrashed(ctx, accessToken, owner, repo, ref, collection, entry); item != nil {
+				errCmsTrashed().Details(item.ID).Log(r, err).Json(w)
+				return
+			}
+			errReposGetBlob().Github(err).Log(r, err).Json(w)
 			return
 		}
 
-		mc, err = cms.MergeLocalisedContent(rc, *cs)
+		mc, err = cms.MergeLocalisedContent(rc, *cs, locales)
 		if err != nil {
 			errCmsMergeLocalizedContent().Log(r, err).Json(w)
 			return
 		}
 	} else {
-		locales, statusCode, err := getLocales(ctx, accessToken, owner, repo, ref)
-		if err != nil {
-			errReposGetTree().Status(statusCode).Log(r, err).Json(w)
-			return
-		}
 		mc, err = cms.GetEmptyLocalisedContent(*cs, locales)
 		if err != nil {
 			errCmsMergeLocalizedContent().Log(r, err).Json(w)
@@ -536,6 +782,16 @@ func getEntry(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if publishedOnly(ctx) {
+		if mc.Status != statusPublished {
+			errNotFound().Log(r, fmt.Errorf("entry not published: %s", entry)).Json(w)
+			return
+		}
+		if entry != "_new" && entryNotModified(w, r, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection, entry) {
+			return
+		}
+	}
+
 	data := &localizedEntry{Name: mc.ID, Type: "blob", Content: mc, Schema: *cs}
 	jsonResponse(w, http.StatusOK, data)
 }
@@ -548,7 +804,7 @@ func getEntry(w http.ResponseWriter, r *http.Request) {
 // @Param		ref				path	string		true	"git ref (branch, tag, sha)"
 // @Param		collection		path	string		true	"collection"
 // @Param		entry			path	string		true	"entry"
-// @Success		200
+// @Success		200	{object}	trashItem
 // @Failure		500	{object}	errorData
 // @Router		/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}	[delete]
 // @Security	bearerToken
@@ -564,13 +820,125 @@ func delEntry(w http.ResponseWriter, r *http.Request) {
 
 	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
 	path := filepath.Join(cmsConfig.WorkDir, collection, entry)
-	resp, err := gh.DeleteFolder(ctx, accessToken, owner, repo, ref, path, commitMessage(collection, "delete", entry))
+	item, commit, _, err := moveToTrash(ctx, accessToken, owner, repo, ref, cms.TrashKindEntry, collection, entry, path)
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	emit(r, newEvent(events.EntryDelete, owner, repo, ref, collection, entry), commit)
+
+	jsonResponse(w, http.StatusOK, newTrashItem(item, cmsConfig.TrashRetention()))
+}
+
+// @Summary		Publish entry
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string		true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string		true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string		true	"git ref (branch, tag, sha)"
+// @Param		collection		path	string		true	"collection"
+// @Param		entry			path	string		true	"entry"
+// @Param		If-Match		header	string		false	"etag of the entry version to publish"
+// @Success		200	{object}	localizedEntry
+// @Failure		409	{object}	entryConflict
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}/publish	[post]
+// @Security	bearerToken
+func publishEntry(w http.ResponseWriter, r *http.Request) {
+	setEntryPublished(w, r, true)
+}
+
+// @Summary		Unpublish entry
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string		true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string		true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string		true	"git ref (branch, tag, sha)"
+// @Param		collection		path	string		true	"collection"
+// @Param		entry			path	string		true	"entry"
+// @Param		If-Match		header	string		false	"etag of the entry version to unpublish"
+// @Success		200	{object}	localizedEntry
+// @Failure		409	{object}	entryConflict
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/collections/{collection}/{entry}/unpublish	[post]
+// @Security	bearerToken
+func unpublishEntry(w http.ResponseWriter, r *http.Request) {
+	setEntryPublished(w, r, false)
+}
+
+func setEntryPublished(w http.ResponseWriter, r *http.Request, publish bool) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	collection := chi.URLParam(r, "collection")
+	entry := chi.URLParam(r, "entry")
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	cs, _, err := getContentSchema(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection)
+	if err != nil {
+		errCmsParseSchema().Log(r, err).Json(w)
+		return
+	}
+
+	current, tag, resp, err := getCurrentEntry(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection, entry, cs)
+	if err != nil {
+		e := errReposGetBlob()
+		if resp != nil {
+			e.Github(err)
+		}
+		e.Log(r, err).Json(w)
+		return
+	}
+	if !etagMatches(r.Header.Get("If-Match"), tag) {
+		entryConflictResponse(w, r, errCmsEntryConflict(), current, tag, fmt.Errorf("entry changed: %s", entry))
+		return
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetTree().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	mc := *current.Content
+	typ, action := events.EntryUnpublish, "unpublish"
+	if publish {
+		now := time.Now().UTC()
+		mc.Status, mc.PublishedAt, mc.PublishedBy = statusPublished, &now, gh.UserFromContext(ctx)
+		typ, action = events.EntryPublish, "publish"
+	} else {
+		mc.Status, mc.PublishedAt, mc.PublishedBy = statusDraft, nil, ""
+	}
+
+	items, err := cms.SeparateLocalisedContent(mc, locales, cmsConfig.WorkDir, collection)
+	if err != nil {
+		errCmsSeparateLocalizedContent().Log(r, err).Json(w)
+		return
+	}
+
+	commit, resp, err := gh.CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage(collection, action, entry))
+	if errors.Is(err, gh.ErrConflict) {
+		entryConflictResponse(w, r, errCmsEntryConflict(), current, tag, err)
+		return
+	}
+	if err != nil {
+		errCmsPublish().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	ev := newEvent(typ, owner, repo, ref, collection, entry)
+	ev.Locales = locales.Codes()
+	emit(r, ev, commit)
+
+	current.Content = &mc
+	jsonResponse(w, http.StatusOK, current)
 }
 
 // images
@@ -632,21 +1000,23 @@ func postImage(w http.ResponseWriter, r *http.Request) {
 	encoding := "base64"
 	content := base64.StdEncoding.EncodeToString(imgbytes)
 
-	blob, resp, err := gh.CreateBlob(ctx, accessToken, owner, repo, ref, &content, &encoding)
+	blob, _, err := gh.CreateBlob(ctx, accessToken, owner, repo, ref, &content, &encoding)
 	if err != nil {
-		errReposCreateBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCreateBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
-	resp, err = gh.CommitBlobs(ctx, accessToken, owner, repo, ref, []gh.BlobEntry{
+	commit, _, err := gh.CommitBlobs(ctx, accessToken, owner, repo, ref, []gh.BlobEntry{
 		{
 			Path: path,
 			SHA:  blob.SHA,
 		}}, commitMessage("images", "upload", fileName))
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
+	auditCommit(r, audit.ActionImageUpload, path, commit)
+
 	data := &entryItem{Name: fileName}
 	jsonResponse(w, http.StatusOK, data)
 }
@@ -672,9 +1042,13 @@ func getSettings(w http.ResponseWriter, r *http.Request) {
 	repo := chi.URLParam(r, "repo")
 	ref := chi.URLParam(r, "ref")
 
-	repoContents, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cms.SettingsFolder)
+	if pathNotModified(w, r, accessToken, owner, repo, ref, cms.SettingsFolder) {
+		return
+	}
+
+	repoContents, _, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cms.SettingsFolder)
 	if err != nil {
-		errReposGetTree().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -713,16 +1087,27 @@ func getSetting(w http.ResponseWriter, r *http.Request) {
 
 	name := setting + ".json"
 	path := filepath.Join(cms.SettingsFolder, name)
+
+	if pathNotModified(w, r, accessToken, owner, repo, ref, path) {
+		return
+	}
+
 	blob, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
 	if err != nil {
 		e := errReposGetBlob()
 		if resp != nil {
-			e.Status(resp.StatusCode)
+			e.Github(err)
 		}
 		e.Log(r, err).Json(w)
 		return
 	}
 
+	blob, err = hideHookSecrets(ctx, path, blob)
+	if err != nil {
+		errCmsParseBlob().Log(r, err).Json(w)
+		return
+	}
+
 	jsonResponse(w, http.StatusOK, &entryPayload{
 		Name:     name,
 		Contents: string(blob),
@@ -759,12 +1144,14 @@ func postSetting(w http.ResponseWriter, r *http.Request) {
 
 	path := filepath.Join(cms.SettingsFolder, strings.ToLower(setting)+".json")
 	contents := string(b)
-	resp, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &contents, commitMessage("settings", "create/update", setting))
+	commit, _, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &contents, commitMessage("settings", "create/update", setting))
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
+	auditCommit(r, audit.ActionSettingUpdate, path, commit)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -789,12 +1176,14 @@ func delSetting(w http.ResponseWriter, r *http.Request) {
 	setting := chi.URLParam(r, "setting")
 
 	path := filepath.Join(cms.SettingsFolder, setting+".json")
-	resp, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, nil, commitMessage("settings", "delete", setting))
+	commit, _, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, nil, commitMessage("settings", "delete", setting))
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
+	auditCommit(r, audit.ActionSettingDelete, path, commit)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -825,23 +1214,40 @@ func getReference(w http.ResponseWriter, r *http.Request) {
 
 	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
 	schemaPath := filepath.Join(cmsConfig.WorkDir, collection, content.JsonSchemaName)
-	sc, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, schemaPath)
+	sc, _, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, schemaPath)
 	if err != nil {
-		errReposGetBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 	cs := &content.Schema{}
 	err = json.Unmarshal(sc, &cs)
 	if err != nil {
-		errCmsParseSchema().Status(resp.StatusCode).Log(r, err).Json(w)
+		errCmsParseSchema().Github(err).Log(r, err).Json(w)
 		return
 	}
 
-	// Get files in directory
-	path := filepath.Join(cmsConfig.WorkDir, collection, id, locale+".json")
-	rc, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	// entries without a file of the locale are read along its fallback chain
+	var rc []byte
+	for _, l := range locales.Chain(locale) {
+		path := filepath.Join(cmsConfig.WorkDir, collection, id, l+".json")
+		rc, _, err = gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+		if !errors.Is(err, gh.ErrNotFound) {
+			break
+		}
+	}
 	if err != nil {
-		errReposGetBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		// report references pointing to trashed entries
+		if item := findTrashed(ctx, accessToken, owner, repo, ref, collection, id); item != nil {
+			errCmsTrashed().Details(item.ID).Log(r, err).Json(w)
+			return
+		}
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -852,6 +1258,11 @@ func getReference(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if publishedOnly(ctx) && contentData.Status != statusPublished {
+		errNotFound().Log(r, fmt.Errorf("entry not published: %s", id)).Json(w)
+		return
+	}
+
 	jsonResponse(w, http.StatusOK, &entryItem{Schema: *cs, Content: &contentData})
 }
 
@@ -881,7 +1292,7 @@ func getCollectionGroups(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		e := errReposGetBlob()
 		if resp != nil {
-			e.Status(resp.StatusCode)
+			e.Github(err)
 		}
 		e.Log(r, err).Json(w)
 		return
@@ -923,7 +1334,7 @@ func getCollectionGroup(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		e := errReposGetBlob()
 		if resp != nil {
-			e.Status(resp.StatusCode)
+			e.Github(err)
 		}
 		e.Log(r, err).Json(w)
 		return
@@ -944,15 +1355,15 @@ func getCollectionGroup(w http.ResponseWriter, r *http.Request) {
 			}
 			cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
 
-			repoContents, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir)
+			repoContents, _, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir)
 			if err != nil {
-				errReposGetTree().Status(resp.StatusCode).Log(r, err).Json(w)
+				errReposGetTree().Github(err).Log(r, err).Json(w)
 				return
 			}
 
 			treeItems := make([]*treeItem, 0)
 			for _, rc := range repoContents {
-				if *rc.Type == "dir" && collections[*rc.Name] {
+				if *rc.Type == "dir" && collections[*rc.Name] && !cms.IsReservedFolder(*rc.Name) {
 					treeItems = append(treeItems, &treeItem{
 						Name: rc.Name,
 						Type: rc.Type,
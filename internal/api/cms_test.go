@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/pkg/content"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+// fakeBranch answers the git api calls of reading and committing entries on main at c1,
+// which holds the files, and keeps the trees and commits written to it
+type fakeBranch struct {
+	sync.Mutex
+	files   map[string]string
+	written map[string]string
+	commit  struct {
+		Message string
+		Author  struct{ Name, Email string }
+	}
+}
+
+func (f *fakeBranch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	reply := func(status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/repos/"), "/", 3)
+	if len(parts) < 3 {
+		reply(404, map[string]any{"message": "Not Found"})
+		return
+	}
+	p := parts[2]
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "commits/") && strings.Contains(r.Header.Get("Accept"), "sha"):
+		fmt.Fprint(w, "c1")
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "commits/"):
+		reply(200, map[string]any{"sha": "c1", "commit": map[string]any{"sha": "c1"}})
+	case r.Method == http.MethodGet && p == "git/trees/c1":
+		entries := make([]map[string]any, 0)
+		dirs := make(map[string]bool)
+		for name := range f.files {
+			for d := name; strings.Contains(d, "/"); {
+				d = d[:strings.LastIndex(d, "/")]
+				if !dirs[d] {
+					dirs[d] = true
+					entries = append(entries, map[string]any{"path": d, "type": "tree", "sha": "t-" + d})
+				}
+			}
+			entries = append(entries, map[string]any{"path": name, "type": "blob", "sha": "b-" + name})
+		}
+		reply(200, map[string]any{"sha": "c1", "tree": entries})
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "git/blobs/b-"):
+		data, ok := f.files[strings.TrimPrefix(p, "git/blobs/b-")]
+		if !ok {
+			reply(404, map[string]any{"message": "Not Found"})
+			return
+		}
+		fmt.Fprint(w, data)
+	case r.Method == http.MethodGet && p == "git/ref/heads/main":
+		reply(200, map[string]any{"ref": "refs/heads/main", "object": map[string]any{"sha": "c1"}})
+	case r.Method == http.MethodPost && p == "git/trees":
+		var body struct {
+			Tree []struct{ Path, Content string }
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, e := range body.Tree {
+			f.written[e.Path] = e.Content
+		}
+		reply(201, map[string]any{"sha": "t2"})
+	case r.Method == http.MethodPost && p == "git/commits":
+		json.NewDecoder(r.Body).Decode(&f.commit)
+		reply(201, map[string]any{"sha": "c2"})
+	case r.Method == http.MethodPatch && p == "git/refs/heads/main":
+		reply(200, map[string]any{"ref": "refs/heads/main", "object": map[string]any{"sha": "c2"}})
+	default:
+		reply(404, map[string]any{"message": "Not Found"})
+	}
+}
+
+func fakeGitHub(t *testing.T, h http.Handler) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	prev := http.DefaultTransport
+	http.DefaultTransport = rewriteTransport{u, prev}
+	t.Cleanup(func() { http.DefaultTransport = prev })
+}
+
+// rewriteTransport sends the requests of the github client to the fake
+type rewriteTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (rt rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return rt.base.RoundTrip(r)
+}
+
+var testRepos int64
+
+func TestCreateOrUpdateEntry(t *testing.T) {
+	audit.SetDefault(audit.NewFile(filepath.Join(t.TempDir(), "audit.jsonl")))
+
+	stored := `{"id":"a","createdAt":"2023-01-02T03:04:05Z","createdBy":"bob","version":2,"status":"draft"}`
+	forged := `{"id":"a","createdBy":"mallory","updatedBy":"mallory","publishedBy":"mallory","version":2}`
+
+	tests := []struct {
+		name    string
+		entry   string
+		editor  *gh.Editor
+		created string
+		updated string
+		author  string
+		trailer string
+	}{
+		{"create", "", nil, "alice", "", "", "Moonbase-User: alice"},
+		{"update", "a", nil, "bob", "alice", "", "Moonbase-User: alice"},
+		{"editor", "a", &gh.Editor{Login: "alice", Name: "Alice", Email: "alice@example.com"}, "bob", "alice", "Alice <alice@example.com>", "Moonbase-User: alice"},
+		{"delegated editor", "a", &gh.Editor{Login: "alice", Name: "Alice", Email: "alice@example.com", Delegated: true}, "bob", "alice", "Alice <alice@example.com>", "On-behalf-of: Alice <alice@example.com>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeBranch{files: map[string]string{"_settings/locales.json": `["en"]`}, written: map[string]string{}}
+			if len(tt.entry) > 0 {
+				f.files["posts/a/en.json"] = stored
+			}
+			fakeGitHub(t, f)
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("owner", "o")
+			rctx.URLParams.Add("repo", fmt.Sprintf("entries-%d", atomic.AddInt64(&testRepos, 1)))
+			rctx.URLParams.Add("ref", "main")
+			rctx.URLParams.Add("collection", "posts")
+			rctx.URLParams.Add("entry", tt.entry)
+
+			payload, _ := json.Marshal(&entryPayload{Name: "a", Contents: forged})
+			ctx := gh.WithAccessToken(context.Background(), "token", "alice")
+			if tt.editor != nil {
+				ctx = gh.WithEditor(ctx, tt.editor)
+			}
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(payload))).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			createOrUpdateEntry(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+
+			f.Lock()
+			defer f.Unlock()
+			written := &content.ContentData{}
+			if err := json.Unmarshal([]byte(f.written["posts/a/en.json"]), written); err != nil {
+				t.Fatal(err)
+			}
+			if written.CreatedBy != tt.created || written.UpdatedBy != tt.updated || len(written.PublishedBy) > 0 {
+				t.Errorf("expected created by %q and updated by %q, got %q, %q and published by %q", tt.created, tt.updated, written.CreatedBy, written.UpdatedBy, written.PublishedBy)
+			}
+			if written.Version != 1 && len(tt.entry) == 0 || written.Version != 3 && len(tt.entry) > 0 {
+				t.Errorf("unexpected version %d", written.Version)
+			}
+
+			author := ""
+			if len(f.commit.Author.Name) > 0 {
+				author = fmt.Sprintf("%s <%s>", f.commit.Author.Name, f.commit.Author.Email)
+			}
+			if author != tt.author {
+				t.Errorf("expected the commit authored by %q, got %q", tt.author, author)
+			}
+			if !strings.Contains(f.commit.Message, "\n"+tt.trailer) {
+				t.Errorf("expected the trailer %q, got %q", tt.trailer, f.commit.Message)
+			}
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/xid"
 
 	"github.com/moonwalker/moonbase/internal/log"
+	gh "github.com/moonwalker/moonbase/pkg/github"
 )
 
 // API uses conventional HTTP response codes to indicate the success or failure of an API request.
@@ -30,6 +35,13 @@ var (
 	errAuthExchange    = errf(400, "err_auth_009", "oauth exchange failed")
 	errAuthGetUser     = errf(400, "err_auth_010", "failed to get user")
 	errAuthEncToken    = errf(400, "err_auth_011", "failed to encrypt token")
+	errAuthForbidden   = errf(403, "err_auth_012", "action not allowed")
+	errAuthRoles       = errf(500, "err_auth_013", "failed to read roles")
+	errAuthApp         = errf(403, "err_auth_014", "github app has no access to repository")
+	errAuthSession     = errf(500, "err_auth_015", "failed to create session")
+	errAuthRefresh     = errf(401, "err_auth_016", "invalid refresh token")
+	errAuthOIDC        = errf(400, "err_auth_017", "oidc login failed")
+	errAuthNoOIDC      = errf(404, "err_auth_018", "oidc login not configured")
 	// repos
 	errReposGet         = errf(404, "err_repos_001", "failed to get repositories")
 	errReposGetBranches = errf(404, "err_repos_002", "failed to get branches")
@@ -52,6 +64,45 @@ var (
 	errCmsReadContent              = errf(400, "err_cms_010", "failed to read content")
 	errCmsMergeLocalizedContent    = errf(400, "err_cms_011", "failed to merge localized content")
 	errCmsSeparateLocalizedContent = errf(400, "err_cms_011", "failed to separate localized content")
+	errCmsTrashList                = errf(404, "err_cms_012", "failed to list trash")
+	errCmsTrashRestore             = errf(400, "err_cms_013", "failed to restore trashed item")
+	errCmsTrashRestoreConflict     = errf(409, "err_cms_014", "restore target already exists")
+	errCmsTrashPurge               = errf(400, "err_cms_015", "failed to purge trash")
+	errCmsTrashed                  = errf(410, "err_cms_016", "entry has been moved to trash")
+	errCmsEntryConflict            = errf(409, "err_cms_017", "entry has been changed concurrently")
+	errCmsTransaction              = errf(400, "err_cms_018", "invalid transaction operation")
+	errCmsReference                = errf(400, "err_cms_019", "invalid reference")
+	errCmsName                     = errf(400, "err_cms_020", "invalid name")
+	errCmsPublish                  = errf(400, "err_cms_021", "failed to change entry publish status")
+	errCmsLocale                   = errf(400, "err_cms_022", "invalid locale")
+	errCmsLocaleExists             = errf(409, "err_cms_023", "locale already exists")
+	errCmsLocaleNotFound           = errf(404, "err_cms_024", "locale not found")
+	errCmsTranslation              = errf(400, "err_cms_025", "invalid translation catalog")
+	// webhooks
+	errWebhooksDelivery   = errf(404, "err_webhooks_001", "webhook delivery not found")
+	errWebhooksHook       = errf(404, "err_webhooks_002", "webhook not found")
+	errWebhooksDeliveries = errf(500, "err_webhooks_003", "failed to read webhook deliveries")
+	// hooks
+	errHooksSignature = errf(401, "err_hooks_001", "invalid webhook signature")
+	// api keys
+	errAPIKeysList   = errf(500, "err_apikeys_001", "failed to list api keys")
+	errAPIKeysCreate = errf(400, "err_apikeys_002", "failed to create api key")
+	errAPIKeysRevoke = errf(400, "err_apikeys_003", "failed to revoke api key")
+	// sessions
+	errSessionsList   = errf(500, "err_sessions_001", "failed to list sessions")
+	errSessionsRevoke = errf(400, "err_sessions_002", "failed to revoke session")
+	// audit
+	errAuditQuery = errf(400, "err_audit_001", "invalid audit query")
+	errAuditRead  = errf(500, "err_audit_002", "failed to read audit log")
+	// rate limits
+	errRateLimited = errf(429, "err_ratelimit_001", "too many requests")
+	errRateBudget  = errf(429, "err_ratelimit_002", "github api budget exhausted, retry later")
+	// github
+	errGithubRateLimit   = errf(429, "err_github_001", "github rate limit exceeded, retry later")
+	errGithubUnavailable = errf(503, "err_github_002", "github unavailable, retry later")
+	// index
+	errIndexBuild = errf(500, "err_index_001", "failed to build content index")
+	errIndexQuery = errf(400, "err_index_002", "invalid query")
 )
 
 type errorData struct {
@@ -61,13 +112,14 @@ type errorData struct {
 	Code       string   `json:"code"`
 	Message    string   `json:"message"`
 	Detailed   []string `json:"details,omitempty"`
+	retryAfter time.Duration
 }
 
 func errf(statusCode int, code, message string) func() *errorData {
 	return func() *errorData {
 		id := xid.New().String()
 		statusText := http.StatusText(statusCode)
-		return &errorData{id, statusCode, statusText, code, message, nil}
+		return &errorData{id, statusCode, statusText, code, message, nil, 0}
 	}
 }
 
@@ -81,7 +133,42 @@ func (e *errorData) Status(statusCode int) *errorData {
 	return e
 }
 
+// RetryAfter tells the client when to try again
+func (e *errorData) RetryAfter(d time.Duration) *errorData {
+	e.retryAfter = d
+	return e
+}
+
+// Github sets the status from the error of a github call, failures of github itself
+// replace the code as they say nothing about the request, errors of other origin are ignored
+func (e *errorData) Github(err error) *errorData {
+	var ghErr *gh.Error
+	if !errors.As(err, &ghErr) {
+		return e
+	}
+
+	var replacement *errorData
+	switch {
+	case errors.Is(ghErr, gh.ErrRateLimited):
+		replacement = errGithubRateLimit()
+		e.retryAfter = ghErr.RetryAfter
+	case errors.Is(ghErr, gh.ErrUnavailable):
+		replacement = errGithubUnavailable()
+	}
+	if replacement != nil {
+		e.Detailed = append([]string{e.Message}, e.Detailed...)
+		e.Code, e.Message = replacement.Code, replacement.Message
+	}
+
+	e.StatusCode = ghErr.StatusCode
+	e.StatusText = http.StatusText(ghErr.StatusCode)
+	return e
+}
+
 func (e *errorData) Json(w http.ResponseWriter) *errorData {
+	if e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
 	jsonResponse(w, e.StatusCode, e)
 	return e
 }
@@ -94,5 +181,6 @@ func (e *errorData) Log(r *http.Request, err error) *errorData {
 		Int("status", e.StatusCode).
 		Str("code", e.Code).
 		Msg(e.Message)
+	auditError(r, e)
 	return e
 }
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/log"
+	"github.com/moonwalker/moonbase/internal/webhooks"
+	"github.com/moonwalker/moonbase/pkg/content"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+const (
+	webhooksConfig   = "webhooks.json"
+	hooksLoadTimeout = 10 * time.Second
+)
+
+func newEvent(typ, owner, repo, ref, collection, entry string) *events.Event {
+	e := events.New(typ, owner, repo, ref)
+	e.Collection = collection
+	e.Entry = entry
+	return e
+}
+
+// emit publishes a content event caused by the current request, the webhook
+// configuration of the ref is refreshed first so deliveries use the latest settings
+func emit(r *http.Request, e *events.Event, commit *github.Commit) {
+	ctx := r.Context()
+	refreshHooks(ctx, gh.AccessTokenFromContext(ctx), e.Owner, e.Repo, e.Ref)
+
+	e.Commit = commit.GetSHA()
+	e.Actor = gh.UserFromContext(ctx)
+	events.Publish(e)
+
+	auditCommit(r, e.Type, path.Join(e.Collection, e.Entry), commit)
+}
+
+func init() {
+	webhooks.SetLoader(loadHooks)
+}
+
+// readHooks reads the webhook configuration of a ref, refs without one have no hooks
+func readHooks(ctx context.Context, accessToken, owner, repo, ref string) ([]*webhooks.Hook, error) {
+	path := filepath.Join(cms.SettingsFolder, webhooksConfig)
+	data, _, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+	if errors.Is(err, gh.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hooks, err := webhooks.ParseHooks(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhooks config %s: %w", path, err)
+	}
+	return hooks, nil
+}
+
+// hideHookSecrets removes the secrets from the webhooks config read at path,
+// unless the request may change settings and so could set the secrets anyway
+func hideHookSecrets(ctx context.Context, path string, data []byte) ([]byte, error) {
+	if filepath.Clean(strings.Trim(path, "/")) != filepath.Join(cms.SettingsFolder, webhooksConfig) {
+		return data, nil
+	}
+	if accessFromContext(ctx).allowed(cms.ActionSettings, "") {
+		return data, nil
+	}
+	return webhooks.Redact(data)
+}
+
+func refreshHooks(ctx context.Context, accessToken, owner, repo, ref string) {
+	hooks, err := readHooks(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		log.Error(err).Str("ref", refKey(owner, repo, ref)).Msg("failed to read webhooks config")
+		return
+	}
+	webhooks.SetHooks(owner, repo, ref, hooks)
+}
+
+// loadHooks reads the hooks of refs not written to since the start with the credential of the service,
+// events of pushes made elsewhere are delivered before any request refreshed them
+func loadHooks(owner, repo, ref string) ([]*webhooks.Hook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hooksLoadTimeout)
+	defer cancel()
+
+	token, err := serviceToken(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return readHooks(ctx, token, owner, repo, ref)
+}
+
+// emitSchemaChange publishes a schema change when the committed path is a collection schema
+func emitSchemaChange(r *http.Request, owner, repo, ref, path string, commit *github.Commit) {
+	if filepath.Base(path) != content.JsonSchemaName {
+		return
+	}
+	collection := filepath.Base(filepath.Dir(path))
+	emit(r, newEvent(events.SchemaChange, owner, repo, ref, collection, ""), commit)
+}
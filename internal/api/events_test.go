@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+)
+
+func TestHideHookSecrets(t *testing.T) {
+	config := []byte(`[{"id":"h1","url":"http://localhost","secret":"s3cret"}]`)
+	roles := &cms.Roles{}
+
+	tests := []struct {
+		name   string
+		access *access
+		path   string
+		hidden bool
+	}{
+		{"admin", &access{roles: roles, member: []string{cms.RoleAdmin}}, "_settings/webhooks.json", false},
+		{"repository without roles", nil, "_settings/webhooks.json", false},
+		{"editor", &access{roles: roles, member: []string{cms.RoleEditor}}, "_settings/webhooks.json", true},
+		{"editor with leading slash", &access{roles: roles, member: []string{cms.RoleEditor}}, "/_settings/webhooks.json", true},
+		{"other setting", &access{roles: roles, member: []string{cms.RoleEditor}}, "_settings/roles.json", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), ctxKeyAccess, tt.access)
+			data, err := hideHookSecrets(ctx, tt.path, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hidden := !strings.Contains(string(data), "s3cret"); hidden != tt.hidden {
+				t.Errorf("expected hidden %t, got %s", tt.hidden, data)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/moonwalker/moonbase/internal/audit"
 	gh "github.com/moonwalker/moonbase/pkg/github"
 )
 
@@ -72,7 +73,7 @@ func getRepos(w http.ResponseWriter, r *http.Request) {
 
 	grs, resp, err := gh.ListRepositories(ctx, accessToken, page, perPage, sort, direction)
 	if err != nil {
-		errReposGet().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGet().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -106,9 +107,9 @@ func getBranches(w http.ResponseWriter, r *http.Request) {
 	owner := chi.URLParam(r, "owner")
 	repo := chi.URLParam(r, "repo")
 
-	branches, resp, err := gh.ListBranches(ctx, accessToken, owner, repo)
+	branches, _, err := gh.ListBranches(ctx, accessToken, owner, repo)
 	if err != nil {
-		errReposGetBranches().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetBranches().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -148,9 +149,13 @@ func getTree(w http.ResponseWriter, r *http.Request) {
 	ref := chi.URLParam(r, "ref")
 	path := chi.URLParam(r, "*")
 
-	repoContents, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, path)
+	if pathNotModified(w, r, accessToken, owner, repo, ref, path) {
+		return
+	}
+
+	repoContents, _, err := gh.GetTree(ctx, accessToken, owner, repo, ref, path)
 	if err != nil {
-		errReposGetTree().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposGetTree().Github(err).Log(r, err).Json(w)
 		return
 	}
 
@@ -188,16 +193,26 @@ func getBlob(w http.ResponseWriter, r *http.Request) {
 	ref := chi.URLParam(r, "ref")
 	path := chi.URLParam(r, "*")
 
+	if pathNotModified(w, r, accessToken, owner, repo, ref, path) {
+		return
+	}
+
 	blob, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
 	if err != nil {
 		e := errReposGetBlob()
 		if resp != nil {
-			e.Status(resp.StatusCode)
+			e.Github(err)
 		}
 		e.Log(r, err).Json(w)
 		return
 	}
 
+	blob, err = hideHookSecrets(ctx, path, blob)
+	if err != nil {
+		errCmsParseBlob().Log(r, err).Json(w)
+		return
+	}
+
 	data := &blobEntry{Contents: blob}
 	jsonResponse(w, http.StatusOK, data)
 }
@@ -231,12 +246,16 @@ func postBlob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	contents := string(data.Contents)
-	resp, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &contents, string(data.CommitMessage))
+	commit, _, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, &contents, string(data.CommitMessage))
 	if err != nil {
-		errReposCommitBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
+	auditCommit(r, audit.ActionBlobUpdate, path, commit)
+
+	emitSchemaChange(r, owner, repo, ref, path, commit)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -261,11 +280,15 @@ func delBlob(w http.ResponseWriter, r *http.Request) {
 	path := chi.URLParam(r, "*")
 
 	deleteMessage := fmt.Sprintf("delete %s", filepath.Base(path))
-	resp, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, nil, deleteMessage)
+	commit, _, err := gh.CommitBlob(ctx, accessToken, owner, repo, ref, path, nil, deleteMessage)
 	if err != nil {
-		errReposDeleteBlob().Status(resp.StatusCode).Log(r, err).Json(w)
+		errReposDeleteBlob().Github(err).Log(r, err).Json(w)
 		return
 	}
 
+	auditCommit(r, audit.ActionBlobDelete, path, commit)
+
+	emitSchemaChange(r, owner, repo, ref, path, commit)
+
 	w.WriteHeader(http.StatusOK)
 }
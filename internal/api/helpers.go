@@ -2,35 +2,46 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
 	"path/filepath"
 
 	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/pkg/content"
 	gh "github.com/moonwalker/moonbase/pkg/github"
 )
 
-const localesConfig = "locales.json"
+// pathNotModified answers conditional reads of the git object at path,
+// validators are left out when they can't be resolved
+func pathNotModified(w http.ResponseWriter, r *http.Request, accessToken, owner, repo, ref, path string) bool {
+	ctx := r.Context()
+	tag, _, _ := gh.GetPathSHA(ctx, accessToken, owner, repo, ref, path)
+	modified, _, _ := gh.GetCommitDate(ctx, accessToken, owner, repo, ref)
+	return notModified(w, r, tag, modified)
+}
 
-func getLocales(ctx context.Context, accessToken, owner, repo, ref string) ([]string, int, error) {
-	path := filepath.Join(cms.SettingsFolder, localesConfig)
+// entryNotModified answers conditional reads of an entry
+func entryNotModified(w http.ResponseWriter, r *http.Request, accessToken, owner, repo, ref, workdir, collection, entry string) bool {
+	ctx := r.Context()
+	tag, _, _ := entryTag(ctx, accessToken, owner, repo, ref, workdir, collection, entry)
+	modified, _, _ := gh.GetCommitDate(ctx, accessToken, owner, repo, ref)
+	return notModified(w, r, tag, modified)
+}
 
-	fc, resp, err := gh.GetFileContent(ctx, accessToken, owner, repo, ref, path)
-	if err != nil {
-		return nil, resp.StatusCode, err
-	}
-	blob, err := fc.GetContent()
-	if err != nil {
-		return nil, http.StatusInternalServerError, err
-	}
+// getLocales reads the locales of the repository, only the default locale
+// is known when the repository has no locales config
+func getLocales(ctx context.Context, accessToken, owner, repo, ref string) (cms.Locales, error) {
+	path := filepath.Join(cms.SettingsFolder, cms.LocalesConfig)
 
-	locales := make([]string, 0)
-	err = json.Unmarshal([]byte(blob), &locales)
+	blob, _, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+	if errors.Is(err, gh.ErrNotFound) {
+		return cms.Locales{{Code: content.DefaultLocale, Enabled: true, Default: true}}, nil
+	}
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, err
 	}
 
-	return locales, http.StatusOK, nil
+	return cms.ParseLocales(blob)
 }
 
 // func getLocales(ctx context.Context, accessToken, owner, repo, branch, path string) ([]string, int, error) {
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/log"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+type pushResult struct {
+	Skipped []string        `json:"skipped"`
+	Events  []*events.Event `json:"events"`
+}
+
+// @Summary		GitHub push webhook
+// @Description	invalidates caches of the pushed ref and emits content events for commits not made by moonbase,
+// @Description	202 when the push is not processed or the config could not be read without a service credential
+// @Tags		hooks
+// @Accept		json
+// @Produce		json
+// @Param		X-Hub-Signature-256	header	string	true	"hmac signature of the payload"
+// @Param		X-GitHub-Event		header	string	true	"github event name"
+// @Success		200	{object}	pushResult
+// @Success		202
+// @Failure		401	{object}	errorData
+// @Router		/hooks/github	[post]
+func githubHook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+
+	if len(env.GithubWebhookSecret) == 0 || !gh.VerifySignature(env.GithubWebhookSecret, body, r.Header.Get(gh.SignatureHeader)) {
+		errHooksSignature().Log(r, errors.New("invalid webhook signature")).Json(w)
+		return
+	}
+
+	// ping and other events are acknowledged but not processed
+	if r.Header.Get(gh.EventHeader) != "push" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	push := &gh.PushHookPayload{}
+	err = json.Unmarshal(body, push)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+
+	ref, ok := push.Branch()
+	if !ok {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	owner := push.Repository.Owner.Login
+	if len(owner) == 0 {
+		owner = push.Repository.Owner.Name
+	}
+	repo := push.Repository.Name
+
+	gh.InvalidateRef(owner, repo, ref)
+
+	// deliveries carry no user token, the config is read with the credential of the service,
+	// without one the default config is assumed and the push only partly processed
+	status := http.StatusOK
+	cmsConfig := cms.ParseConfig(nil)
+	token, err := serviceToken(r.Context(), owner, repo)
+	if err != nil {
+		log.Error(err).Str("ref", refKey(owner, repo, ref)).Msg("push processed with the default config")
+		status = http.StatusAccepted
+	} else {
+		cmsConfig = getConfig(r.Context(), token, owner, repo, ref)
+		// the webhooks config may have been changed by the push as well
+		refreshHooks(r.Context(), token, owner, repo, ref)
+	}
+
+	res := &pushResult{Skipped: make([]string, 0), Events: make([]*events.Event, 0)}
+	cs := cms.NewChangeSet(cmsConfig.WorkDir)
+	for _, c := range push.Commits {
+		if gh.IsOwnCommit(c.Message) {
+			res.Skipped = append(res.Skipped, c.ID)
+			continue
+		}
+		cs.Add(c.ID, c.Added, c.Removed, c.Modified)
+	}
+
+	// github lists the first commits of large pushes only, the changes of all of them are compared
+	// instead, which is not possible for new branches
+	if len(push.Commits) >= gh.PushCommitsLimit && len(token) > 0 && len(strings.Trim(push.Before, "0")) > 0 {
+		changes, err := pushChanges(r.Context(), token, owner, repo, push, cmsConfig.WorkDir)
+		if err != nil {
+			log.Error(err).Str("ref", refKey(owner, repo, ref)).Msg("failed to compare pushed commits")
+		} else {
+			cs = changes
+		}
+	}
+
+	for _, c := range cs.Changes() {
+		e := newEvent(changeEvent(c), owner, repo, ref, c.Collection, c.Entry)
+		e.Locales = c.Locales
+		e.Commit = c.Commit
+		e.Actor = push.Pusher.Name
+		events.Publish(e)
+		res.Events = append(res.Events, e)
+	}
+
+	jsonResponse(w, status, res)
+}
+
+// pushChanges compares the commits before and after the push, changes are attributed to the
+// head of the push as the comparison can't tell which commit made them or whether it was an own one
+func pushChanges(ctx context.Context, token string, owner string, repo string, push *gh.PushHookPayload, workdir string) (*cms.ChangeSet, error) {
+	files, complete, _, err := gh.GetChangedFiles(ctx, token, owner, repo, push.Before, push.After)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return nil, fmt.Errorf("more files changed between %s and %s than github lists", push.Before, push.After)
+	}
+
+	added, removed, modified := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, f := range files {
+		switch f.GetStatus() {
+		case "added", "copied":
+			added = append(added, f.GetFilename())
+		case "removed":
+			removed = append(removed, f.GetFilename())
+		case "renamed":
+			removed = append(removed, f.GetPreviousFilename())
+			added = append(added, f.GetFilename())
+		default:
+			modified = append(modified, f.GetFilename())
+		}
+	}
+
+	cs := cms.NewChangeSet(workdir)
+	cs.Add(push.After, added, removed, modified)
+	return cs, nil
+}
+
+// serviceToken returns the credential reading repositories outside of user requests
+func serviceToken(ctx context.Context, owner string, repo string) (string, error) {
+	if gh.AppEnabled() {
+		token, _, err := gh.InstallationToken(ctx, owner, repo)
+		return token, err
+	}
+	if len(env.GithubServiceToken) > 0 {
+		return env.GithubServiceToken, nil
+	}
+	return "", errors.New("no service credential to read " + owner + "/" + repo)
+}
+
+// changeEvent maps a pushed change to an event type, an entry only counts as
+// created or deleted when every touched file of it was added or removed
+func changeEvent(c *cms.Change) string {
+	if len(c.Entry) == 0 {
+		switch {
+		case c.Added && !c.Removed:
+			return events.CollectionCreate
+		case c.Removed && !c.Added:
+			return events.CollectionDelete
+		}
+		return events.SchemaChange
+	}
+
+	switch {
+	case c.Added && !c.Removed && !c.Modified:
+		return events.EntryCreate
+	case c.Removed && !c.Added && !c.Modified:
+		return events.EntryDelete
+	}
+	return events.EntryUpdate
+}
@@ -0,0 +1,419 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	ix "github.com/moonwalker/moonbase/internal/index"
+	"github.com/moonwalker/moonbase/pkg/content"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type searchResponse struct {
+	Query      string      `json:"query"`
+	Hits       []*ix.Hit   `json:"hits"`
+	Facets     ix.Facets   `json:"facets"`
+	Pagination *pagination `json:"pagination"`
+}
+
+type indexStatus struct {
+	Commit      string         `json:"commit"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	Collections map[string]int `json:"collections"`
+}
+
+// ghSource reads the repository for the index with the token of the user or of the service
+type ghSource struct {
+	accessToken string
+	owner       string
+	repo        string
+	ref         string
+}
+
+func (s *ghSource) Resolve(ctx context.Context) (string, error) {
+	sha, _, err := gh.GetPathSHA(ctx, s.accessToken, s.owner, s.repo, s.ref, "")
+	return sha, err
+}
+
+func (s *ghSource) Archive(ctx context.Context, commit string) ([]*ix.File, error) {
+	rcs, _, err := gh.GetArchive(ctx, s.accessToken, s.owner, s.repo, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*ix.File, 0, len(rcs))
+	for _, rc := range rcs {
+		data, err := rc.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &ix.File{Path: rc.GetPath(), Data: []byte(data)})
+	}
+	return files, nil
+}
+
+func (s *ghSource) Changes(ctx context.Context, base string, head string) ([]string, bool, error) {
+	cfs, complete, _, err := gh.GetChangedFiles(ctx, s.accessToken, s.owner, s.repo, base, head)
+	if err != nil {
+		return nil, false, err
+	}
+
+	paths := make([]string, 0)
+	for _, cf := range cfs {
+		paths = append(paths, cf.GetFilename())
+		if len(cf.GetPreviousFilename()) > 0 {
+			paths = append(paths, cf.GetPreviousFilename())
+		}
+	}
+	return paths, complete, nil
+}
+
+func (s *ghSource) Read(ctx context.Context, commit string, path string) (*ix.File, error) {
+	data, _, err := gh.GetBlob(ctx, s.accessToken, s.owner, s.repo, commit, path)
+	if err != nil {
+		if errors.Is(err, gh.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sha, _, err := gh.GetPathSHA(ctx, s.accessToken, s.owner, s.repo, commit, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ix.File{Path: path, SHA: sha, Data: data}, nil
+}
+
+func init() {
+	ix.Default.SetSource(serviceSource)
+}
+
+// serviceSource reads the repository with the credential of the service to refresh indexes after changes
+func serviceSource(ctx context.Context, owner, repo, ref string) (ix.Source, error) {
+	token, err := serviceToken(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return &ghSource{token, owner, repo, ref}, nil
+}
+
+func getIndex(r *http.Request, owner, repo, ref string) (*ix.Index, error) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+	src := &ghSource{accessToken, owner, repo, ref}
+	return ix.Default.Get(ctx, owner, repo, ref, cmsConfig.WorkDir, src)
+}
+
+func newIndexStatus(idx *ix.Index) *indexStatus {
+	return &indexStatus{Commit: idx.Commit, UpdatedAt: idx.UpdatedAt, Collections: idx.Collections()}
+}
+
+// @Summary		Get index status
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Success		200	{object}	indexStatus
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/index	[get]
+// @Security	bearerToken
+func getIndexStatus(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	idx, err := getIndex(r, owner, repo, ref)
+	if err != nil {
+		errIndexBuild().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, newIndexStatus(idx))
+}
+
+// @Summary		Rebuild index
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Success		200	{object}	indexStatus
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/index	[post]
+// @Security	bearerToken
+func rebuildIndex(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+	src := &ghSource{accessToken, owner, repo, ref}
+
+	idx, err := ix.Default.Rebuild(ctx, owner, repo, ref, cmsConfig.WorkDir, src)
+	if err != nil {
+		errIndexBuild().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, newIndexStatus(idx))
+}
+
+// @Summary		Query entries
+// @Description	lists the entries of a collection from the content index
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		collection		path	string	true	"collection"
+// @Param		locale			query	string	false	"locale of the returned content"
+// @Param		page			query	int		false	"page number starting with 1"
+// @Param		limit			query	int		false	"page size"
+// @Param		filter			query	string	false	"comma separated field:value pairs"
+// @Param		order			query	string	false	"comma separated fields, prefixed with - or suffixed with :-1 for descending order"
+// @Success		200	{object}	listResponse
+// @Failure		400	{object}	errorData
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/query/{collection}	[get]
+// @Security	bearerToken
+func queryEntries(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	collection := chi.URLParam(r, "collection")
+
+	q, pg, err := parseQuery(r)
+	if err != nil {
+		errIndexQuery().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+	q.Collection = collection
+	if publishedOnly(r.Context()) {
+		q.Filter["status"] = statusPublished
+	}
+
+	ctx := r.Context()
+	locales, err := getLocales(ctx, gh.AccessTokenFromContext(ctx), owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+	if len(q.Locale) == 0 {
+		q.Locale = locales.Default()
+	}
+	q.Fallback = locales.Chain(q.Locale)[1:]
+
+	idx, err := getIndex(r, owner, repo, ref)
+	if err != nil {
+		errIndexBuild().Log(r, err).Json(w)
+		return
+	}
+
+	data, total := idx.Find(*q)
+	jsonResponse(w, http.StatusOK, newListResponse(data, idx.Schema(collection), q, pg, total))
+}
+
+func newListResponse(data []*content.ContentData, cs *content.Schema, q *ix.Query, pg *pagination, total int) *listResponse {
+	res := &listResponse{Data: data, Schema: cs, Pagination: paginate(pg, q.Limit, total)}
+	if len(q.Filter) > 0 {
+		f := filters(q.Filter)
+		res.Filter = &f
+	}
+	if len(q.Order) > 0 {
+		o := make(orderBy)
+		for _, ob := range q.Order {
+			o[ob.Field] = 1
+			if ob.Desc {
+				o[ob.Field] = -1
+			}
+		}
+		res.Order = &o
+	}
+	return res
+}
+
+// @Summary		Search entries
+// @Description	full-text search over the entries of every collection
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		q				query	string	true	"search text"
+// @Param		locale			query	string	false	"search only the given locale"
+// @Param		collection		query	string	false	"comma separated collections"
+// @Param		status			query	string	false	"entry status"
+// @Param		author			query	string	false	"last editor of the entry"
+// @Param		page			query	int		false	"page number starting with 1"
+// @Param		limit			query	int		false	"page size"
+// @Success		200	{object}	searchResponse
+// @Failure		400	{object}	errorData
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/search	[get]
+// @Security	bearerToken
+func searchEntries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	params := r.URL.Query()
+	text := strings.TrimSpace(params.Get("q"))
+	if len(text) == 0 {
+		errIndexQuery().Details("missing search text").Json(w)
+		return
+	}
+
+	q, pg, err := parseQuery(r)
+	if err != nil {
+		errIndexQuery().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+
+	idx, err := getIndex(r, owner, repo, ref)
+	if err != nil {
+		errIndexBuild().Log(r, err).Json(w)
+		return
+	}
+
+	status := params.Get("status")
+	if publishedOnly(ctx) {
+		status = statusPublished
+	}
+
+	res := idx.Search(ix.SearchQuery{
+		Text:        text,
+		Locale:      q.Locale,
+		Collections: readableCollections(ctx, idx, splitParam(params.Get("collection"))),
+		Status:      status,
+		Author:      params.Get("author"),
+		Offset:      q.Offset,
+		Limit:       q.Limit,
+	})
+
+	jsonResponse(w, http.StatusOK, &searchResponse{
+		Query:      text,
+		Hits:       res.Hits,
+		Facets:     res.Facets,
+		Pagination: paginate(pg, q.Limit, res.Total),
+	})
+}
+
+// readableCollections narrows the searched collections to the ones the user may read,
+// an empty list searches all of them
+func readableCollections(ctx context.Context, idx *ix.Index, collections []string) []string {
+	a := accessFromContext(ctx)
+	if a == nil {
+		return collections
+	}
+
+	if len(collections) == 0 {
+		for c := range idx.Collections() {
+			collections = append(collections, c)
+		}
+	}
+
+	res := make([]string, 0)
+	for _, c := range collections {
+		if a.allowed(cms.ActionRead, c) {
+			res = append(res, c)
+		}
+	}
+	if len(res) == 0 {
+		// nothing readable, match no collection at all
+		res = append(res, "")
+	}
+	return res
+}
+
+// paginate completes the requested page with the page count and the neighbouring pages
+func paginate(pg *pagination, limit int, total int) *pagination {
+	pg.TotalCount = int64(total)
+	pageCount := (pg.TotalCount + int64(limit) - 1) / int64(limit)
+	pg.PageCount = &pageCount
+	if pg.CurrentPage < pageCount {
+		next := pg.CurrentPage + 1
+		pg.NextPage = &next
+	}
+	if pg.CurrentPage > 1 {
+		prev := pg.CurrentPage - 1
+		pg.PreviousPage = &prev
+	}
+	return pg
+}
+
+// parseQuery reads the index query and the requested page of a list request
+func parseQuery(r *http.Request) (*ix.Query, *pagination, error) {
+	params := r.URL.Query()
+	q := &ix.Query{Locale: params.Get("locale"), Filter: make(map[string]string), Limit: defaultPageSize}
+	pg := &pagination{CurrentPage: 1}
+
+	if v := params.Get("page"); len(v) > 0 {
+		page, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || page < 1 {
+			return nil, nil, errors.New("invalid page")
+		}
+		pg.CurrentPage = page
+	}
+	if v := params.Get("limit"); len(v) > 0 {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return nil, nil, errors.New("invalid limit")
+		}
+		q.Limit = limit
+	}
+	q.Offset = int(pg.CurrentPage-1) * q.Limit
+
+	for _, f := range splitParam(params.Get("filter")) {
+		field, value, ok := strings.Cut(f, ":")
+		if !ok || len(field) == 0 {
+			return nil, nil, errors.New("invalid filter: " + f)
+		}
+		q.Filter[field] = value
+	}
+
+	for _, o := range splitParam(params.Get("order")) {
+		field, dir, _ := strings.Cut(o, ":")
+		desc := strings.HasPrefix(field, "-") || dir == "-1" || strings.EqualFold(dir, "desc")
+		field = strings.TrimPrefix(field, "-")
+		if len(field) == 0 {
+			return nil, nil, errors.New("invalid order: " + o)
+		}
+		q.Order = append(q.Order, ix.Order{Field: field, Desc: desc})
+	}
+
+	return q, pg, nil
+}
+
+func splitParam(v string) []string {
+	res := make([]string, 0)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/internal/cms"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+// @Summary		List locales
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Success		200	{object}	[]cms.Locale
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/locales	[get]
+// @Security	bearerToken
+func listLocales(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, locales)
+}
+
+// @Summary		Create locale
+// @Description	with files set every entry gets a file of the locale, copied from the locale it falls back to
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string		true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string		true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string		true	"git ref (branch, tag, sha)"
+// @Param		files			query	bool		false	"create the files of the locale"
+// @Param		payload			body	cms.Locale	true	"locale"
+// @Success		201	{object}	[]cms.Locale
+// @Failure		400	{object}	errorData
+// @Failure		409	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/locales	[post]
+// @Security	bearerToken
+func postLocale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	locale := &cms.Locale{}
+	err := json.NewDecoder(r.Body).Decode(locale)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+	if locales.Get(locale.Code) != nil {
+		errCmsLocaleExists().Details(locale.Code).Log(r, fmt.Errorf("locale exists: %s", locale.Code)).Json(w)
+		return
+	}
+
+	locales = append(locales, locale)
+	setDefaultLocale(locales, locale)
+
+	var items []gh.BlobEntry
+	if r.URL.Query().Get("files") == "true" {
+		// the new files are rewritten from the files they fall back to, which are read with their content
+		items, err = localeFiles(r, locales, true, func(workdir string, files []*github.RepositoryContent) ([]gh.BlobEntry, error) {
+			return cms.AddLocaleFiles(workdir, files, locales, locale.Code)
+		})
+		if err != nil {
+			errReposGetTree().Github(err).Log(r, err).Json(w)
+			return
+		}
+	}
+
+	commitLocales(w, r, locales, items, http.StatusCreated, "create", locale.Code)
+}
+
+// @Summary		Update locale
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string		true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string		true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string		true	"git ref (branch, tag, sha)"
+// @Param		locale			path	string		true	"locale code"
+// @Param		payload			body	cms.Locale	true	"locale"
+// @Success		200	{object}	[]cms.Locale
+// @Failure		400	{object}	errorData
+// @Failure		404	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/locales/{locale}	[put]
+// @Security	bearerToken
+func putLocale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	code := chi.URLParam(r, "locale")
+
+	locale := &cms.Locale{}
+	err := json.NewDecoder(r.Body).Decode(locale)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+	// the code is the name of the locale files, renaming would orphan them
+	if len(locale.Code) > 0 && locale.Code != code {
+		m := "locale code can't be changed"
+		errCmsLocale().Details(m).Log(r, fmt.Errorf("%s: %s", m, code)).Json(w)
+		return
+	}
+	locale.Code = code
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	found := false
+	for i, l := range locales {
+		if l.Code == code {
+			locales[i], found = locale, true
+		}
+	}
+	if !found {
+		errCmsLocaleNotFound().Details(code).Log(r, fmt.Errorf("locale not found: %s", code)).Json(w)
+		return
+	}
+	setDefaultLocale(locales, locale)
+
+	commitLocales(w, r, locales, nil, http.StatusOK, "update", code)
+}
+
+// @Summary		Delete locale
+// @Description	with files set the files of the locale are deleted from every entry
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		locale			path	string	true	"locale code"
+// @Param		files			query	bool	false	"delete the files of the locale"
+// @Success		200	{object}	[]cms.Locale
+// @Failure		400	{object}	errorData
+// @Failure		404	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/locales/{locale}	[delete]
+// @Security	bearerToken
+func delLocale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	code := chi.URLParam(r, "locale")
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	rest := make(cms.Locales, 0, len(locales))
+	for _, l := range locales {
+		if l.Code != code {
+			rest = append(rest, l)
+		}
+	}
+	if len(rest) == len(locales) {
+		errCmsLocaleNotFound().Details(code).Log(r, fmt.Errorf("locale not found: %s", code)).Json(w)
+		return
+	}
+
+	var items []gh.BlobEntry
+	if r.URL.Query().Get("files") == "true" {
+		items, err = localeFiles(r, rest, false, func(workdir string, files []*github.RepositoryContent) ([]gh.BlobEntry, error) {
+			return cms.RemoveLocaleFiles(workdir, files, code), nil
+		})
+		if err != nil {
+			errReposGetTree().Github(err).Log(r, err).Json(w)
+			return
+		}
+	}
+
+	commitLocales(w, r, rest, items, http.StatusOK, "delete", code)
+}
+
+// setDefaultLocale makes the locale the only default one when it is flagged as default
+func setDefaultLocale(locales cms.Locales, locale *cms.Locale) {
+	if !locale.Default {
+		return
+	}
+	for _, l := range locales {
+		l.Default = l == locale
+	}
+}
+
+// localeFiles returns the blob entries changing the locale files of every entry of the work dir,
+// nothing is read when the locales are invalid as they are rejected on commit anyway
+func localeFiles(r *http.Request, locales cms.Locales, contents bool, entries func(workdir string, files []*github.RepositoryContent) ([]gh.BlobEntry, error)) ([]gh.BlobEntry, error) {
+	if locales.Validate() != nil {
+		return nil, nil
+	}
+
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+	list := gh.ListFiles
+	if contents {
+		list = gh.GetContentsRecursive
+	}
+	files, _, err := list(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries(cmsConfig.WorkDir, files)
+}
+
+// commitLocales validates the locales and commits them together with the changed locale files
+func commitLocales(w http.ResponseWriter, r *http.Request, locales cms.Locales, items []gh.BlobEntry, statusCode int, action string, code string) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	err := locales.Validate()
+	if err != nil {
+		errCmsLocale().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+
+	data, err := json.MarshalIndent(locales, "", "  ")
+	if err != nil {
+		errCmsLocale().Log(r, err).Json(w)
+		return
+	}
+
+	path := filepath.Join(cms.SettingsFolder, cms.LocalesConfig)
+	contents := string(data)
+	items = append(items, gh.BlobEntry{Path: path, Content: &contents})
+
+	commit, _, err := gh.CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage("settings", action+" locale", code))
+	if err != nil {
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	auditCommit(r, audit.ActionSettingUpdate, path, commit)
+	jsonResponse(w, statusCode, locales)
+}
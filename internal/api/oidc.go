@@ -0,0 +1,141 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/oidc"
+	"github.com/moonwalker/moonbase/internal/sessions"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+// test the flow:
+// http://localhost:8080/login/oidc?return_url=/login/oidc/authenticate
+
+// oidcNonce binds the id token to the login it was requested for, without keeping state
+func oidcNonce(state string) string {
+	h := sha256.Sum256([]byte(oauthStateSecret + state))
+	return hex.EncodeToString(h[:16])
+}
+
+func oidcAuth(w http.ResponseWriter, r *http.Request) {
+	// changes of users without a github account need a credential to be pushed with
+	if !gh.AppEnabled() && len(env.OIDCServiceToken) == 0 {
+		errAuthNoOIDC().Log(r, errors.New("neither github app nor service token configured")).Json(w)
+		return
+	}
+
+	provider, err := oidc.Default(r.Context())
+	if err != nil {
+		e := errAuthOIDC()
+		if errors.Is(err, oidc.ErrNotConfigured) {
+			e = errAuthNoOIDC()
+		}
+		e.Log(r, err).Json(w)
+		return
+	}
+
+	state, err := gh.EncodeState(r, oauthStateSecret)
+	if err != nil {
+		errAuthEncState().Log(r, err).Json(w)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, oidcNonce(state)), http.StatusTemporaryRedirect)
+}
+
+// oidcCallback verifies the identity right away, as the nonce is bound to the state,
+// and passes it on to the return url the same way github logins pass the code
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	secret, returnURL := gh.DecodeState(r)
+	if secret != oauthStateSecret {
+		err := fmt.Errorf("expected: %s actual: %s", oauthStateSecret, secret)
+		errAuthBadSecret().Log(r, err).Json(w)
+		return
+	}
+
+	provider, err := oidc.Default(r.Context())
+	if err != nil {
+		errAuthOIDC().Log(r, err).Json(w)
+		return
+	}
+
+	id, err := provider.Exchange(r.Context(), r.FormValue("code"), oidcNonce(r.FormValue("state")))
+	if err != nil {
+		errAuthOIDC().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+
+	data, err := json.Marshal(id)
+	if err != nil {
+		errAuthOIDC().Log(r, err).Json(w)
+		return
+	}
+
+	url, err := gh.ReturnURLWithCode(returnURL, string(data), gh.RetUrlCodePath)
+	if err != nil {
+		errAuthEncRetURL().Log(r, err).Json(w)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+func oidcAuthenticate(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+
+	if code == "" {
+		code = chi.URLParam(r, "code")
+		if code == "" {
+			errAuthCodeMissing().Log(r, nil).Json(w)
+			return
+		}
+	}
+
+	decoded, err := gh.DecryptExchangeCode(code)
+	if err != nil {
+		errAuthDecOAuth().Log(r, err).Json(w)
+		return
+	}
+
+	id := &oidc.Identity{}
+	err = json.Unmarshal([]byte(decoded), id)
+	if err != nil {
+		errAuthDecOAuth().Log(r, err).Json(w)
+		return
+	}
+
+	session := &sessions.Session{
+		Provider:  sessions.ProviderOIDC,
+		Login:     id.Email,
+		Name:      id.Name,
+		Email:     id.Email,
+		Image:     id.Picture,
+		Groups:    id.Groups,
+		UserAgent: r.UserAgent(),
+		IP:        r.RemoteAddr,
+	}
+	refreshToken, err := sessions.Default.Create(session, "")
+	if err != nil {
+		errAuthSession().Log(r, err).Json(w)
+		return
+	}
+
+	usr, err := loginUser(sessionUser(session), "", session.ID, refreshToken)
+	if err != nil {
+		errAuthEncToken().Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionLogin, User: session.Login, Details: session.Provider})
+
+	jsonResponse(w, http.StatusOK, usr)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/ratelimit"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+type rateLimits struct {
+	UserPerMinute   int                `json:"userPerMinute"`
+	APIKeyPerMinute int                `json:"apiKeyPerMinute"`
+	GithubReserve   int                `json:"githubReserve"`
+	Github          []*ratelimit.Quota `json:"github"`
+}
+
+// withRateLimit limits the requests of users and api keys and holds requests back
+// before the github budget of their token runs out, as one request can make many calls
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var ok bool
+		var wait time.Duration
+		if key := apiKeyFromContext(ctx); key != nil {
+			ok, wait = ratelimit.APIKeys.Allow(key.ID)
+		} else {
+			ok, wait = ratelimit.Users.Allow(gh.UserFromContext(ctx))
+		}
+		if !ok {
+			errRateLimited().RetryAfter(wait).Log(r, fmt.Errorf("%s exceeded the request limit", gh.UserFromContext(ctx))).Json(w)
+			return
+		}
+
+		ok, wait = ratelimit.GitHub.Check(gh.AccessTokenFromContext(ctx))
+		if !ok {
+			errRateBudget().RetryAfter(wait).Log(r, fmt.Errorf("github budget of %s below reserve", gh.UserFromContext(ctx))).Json(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary		Rate limits
+// @Description	request limits and the remaining github quota of every token seen by this instance, tokens are hashed
+// @Tags		admin
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	rateLimits
+// @Failure		403	{object}	errorData
+// @Router		/admin/ratelimits	[get]
+// @Security	bearerToken
+func getRateLimits(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, &rateLimits{
+		UserPerMinute:   ratelimit.Users.PerMinute(),
+		APIKeyPerMinute: ratelimit.APIKeys.PerMinute(),
+		GithubReserve:   ratelimit.GitHub.Reserve(),
+		Github:          ratelimit.GitHub.Quotas(),
+	})
+}
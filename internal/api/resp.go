@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/env"
 )
 
 func rawResponse(w http.ResponseWriter, statusCode int, data []byte) {
@@ -27,3 +31,53 @@ func jsonResponse(w http.ResponseWriter, statusCode int, v any) []byte {
 	rawResponse(w, statusCode, res)
 	return res
 }
+
+func quoteETag(tag string) string {
+	return `"` + tag + `"`
+}
+
+// etagMatches reports whether the If-Match header value, if any, matches the given tag
+func etagMatches(h string, tag string) bool {
+	if len(h) == 0 || h == "*" {
+		return true
+	}
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if strings.Trim(t, `"`) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the validators of a read response and reports whether the copy of the client
+// is still fresh, in which case 304 has been written already. Responses depend on the credential,
+// delivery keys don't see drafts, so shared caches have to keep them apart.
+func notModified(w http.ResponseWriter, r *http.Request, tag string, modified time.Time) bool {
+	h := w.Header()
+	h.Set("Cache-Control", env.CacheControl)
+	h.Add("Vary", "Authorization")
+	if len(tag) > 0 {
+		h.Set("ETag", quoteETag(tag))
+	}
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is ignored when If-None-Match is present
+	if inm := r.Header.Get("If-None-Match"); len(inm) > 0 {
+		if len(tag) == 0 || !etagMatches(inm, tag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); len(ims) > 0 {
+		t, err := http.ParseTime(ims)
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz",W/"abc"`, true},
+		{`"xyz"`, false},
+		{`"abcd"`, false},
+	}
+	for _, tt := range tests {
+		if match := etagMatches(tt.header, "abc"); match != tt.match {
+			t.Errorf("%q: expected match %t, got %t", tt.header, tt.match, match)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		tag      string
+		modified time.Time
+		want     bool
+	}{
+		{"unconditional", nil, "abc", modified, false},
+		{"matching tag", map[string]string{"If-None-Match": `"abc"`}, "abc", modified, true},
+		{"weak tag", map[string]string{"If-None-Match": `W/"abc"`}, "abc", modified, true},
+		{"tag list", map[string]string{"If-None-Match": `"xyz", "abc"`}, "abc", modified, true},
+		{"any tag", map[string]string{"If-None-Match": "*"}, "abc", modified, true},
+		{"other tag", map[string]string{"If-None-Match": `"xyz"`}, "abc", modified, false},
+		{"no tag known", map[string]string{"If-None-Match": `"abc"`}, "", modified, false},
+		{"unchanged since", map[string]string{"If-Modified-Since": after}, "abc", modified, true},
+		{"changed since", map[string]string{"If-Modified-Since": before}, "abc", modified, false},
+		{"unknown change", map[string]string{"If-Modified-Since": after}, "abc", time.Time{}, false},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, "abc", modified, false},
+		{"tag takes precedence", map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": after}, "abc", modified, false},
+		{"tag takes precedence over stale date", map[string]string{"If-None-Match": `"abc"`, "If-Modified-Since": before}, "abc", modified, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			if got := notModified(w, r, tt.tag, tt.modified); got != tt.want {
+				t.Fatalf("expected not modified %t, got %t", tt.want, got)
+			}
+			if tt.want && w.Code != http.StatusNotModified {
+				t.Errorf("expected 304, got %d", w.Code)
+			}
+			if w.Header().Get("Vary") != "Authorization" {
+				t.Errorf("expected responses to vary by authorization, got %q", w.Header().Get("Vary"))
+			}
+			if len(tt.tag) > 0 && w.Header().Get("ETag") != `"`+tt.tag+`"` {
+				t.Errorf("unexpected etag %q", w.Header().Get("ETag"))
+			}
+			if lm := w.Header().Get("Last-Modified"); tt.modified.IsZero() != (len(lm) == 0) {
+				t.Errorf("unexpected last modified %q", lm)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/sessions"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+type refreshPayload struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type sessionItem struct {
+	*sessions.Session
+	Current bool `json:"current"`
+}
+
+// adminsOnly rejects users who are not configured as admins
+func adminsOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login := gh.UserFromContext(r.Context())
+		for _, a := range env.Admins {
+			if strings.EqualFold(a, login) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		errAuthForbidden().Details("admins only").Log(r, fmt.Errorf("%s is not an admin", login)).Json(w)
+	})
+}
+
+// @Summary		Refresh access token
+// @Description	the refresh token is rotated, the previous one can't be used again
+// @Tags		auth
+// @Accept		json
+// @Produce		json
+// @Param		payload			body	refreshPayload	true	"refresh token of the session"
+// @Success		200	{object}	gh.User
+// @Failure		401	{object}	errorData
+// @Router		/login/refresh	[post]
+func postRefresh(w http.ResponseWriter, r *http.Request) {
+	payload := &refreshPayload{}
+	err := json.NewDecoder(r.Body).Decode(payload)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+
+	session, refreshToken, token, err := sessions.Default.Refresh(payload.RefreshToken)
+	if err != nil {
+		e := errAuthRefresh()
+		if !errors.Is(err, sessions.ErrInvalid) && !errors.Is(err, sessions.ErrRevoked) && !errors.Is(err, sessions.ErrExpired) {
+			e = errAuthSession()
+		}
+		e.Log(r, err).Json(w)
+		return
+	}
+
+	var usr *gh.User
+	if session.Provider == sessions.ProviderOIDC {
+		usr = sessionUser(session)
+	} else {
+		ghUser, err := gh.GetUser(r.Context(), token)
+		if err != nil {
+			errAuthGetUser().Log(r, err).Json(w)
+			return
+		}
+		usr = githubUser(ghUser)
+	}
+
+	usr, err = loginUser(usr, token, session.ID, refreshToken)
+	if err != nil {
+		errAuthEncToken().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, usr)
+}
+
+// @Summary		Logout
+// @Description	revokes the session of the token
+// @Tags		auth
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	sessions.Session
+// @Failure		400	{object}	errorData
+// @Router		/logout	[post]
+// @Security	bearerToken
+func postLogout(w http.ResponseWriter, r *http.Request) {
+	session, err := sessions.Default.Revoke(gh.SessionFromContext(r.Context()).ID)
+	if err != nil {
+		errSessionsRevoke().Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionLogout, Path: session.ID})
+	jsonResponse(w, http.StatusOK, session)
+}
+
+// @Summary		Logout everywhere
+// @Description	revokes every session of the user
+// @Tags		auth
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	[]sessions.Session
+// @Failure		400	{object}	errorData
+// @Router		/logout/all	[post]
+// @Security	bearerToken
+func postLogoutAll(w http.ResponseWriter, r *http.Request) {
+	revoked, err := sessions.Default.RevokeAll(gh.UserFromContext(r.Context()))
+	if err != nil {
+		errSessionsRevoke().Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionLogout, Details: "all sessions"})
+
+	jsonResponse(w, http.StatusOK, revoked)
+}
+
+// @Summary		List sessions
+// @Description	active sessions of the user, the session of the token is marked current
+// @Tags		auth
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	[]sessionItem
+// @Failure		500	{object}	errorData
+// @Router		/sessions	[get]
+// @Security	bearerToken
+func getSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	list, err := sessions.Default.List(gh.UserFromContext(ctx))
+	if err != nil {
+		errSessionsList().Log(r, err).Json(w)
+		return
+	}
+
+	current := gh.SessionFromContext(ctx)
+	res := make([]*sessionItem, 0, len(list))
+	for _, s := range list {
+		res = append(res, &sessionItem{s, s.ID == current.ID})
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// @Summary		Revoke session
+// @Tags		auth
+// @Accept		json
+// @Produce		json
+// @Param		id				path	string	true	"session id"
+// @Success		200	{object}	sessions.Session
+// @Failure		404	{object}	errorData
+// @Router		/sessions/{id}	[delete]
+// @Security	bearerToken
+func delSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	// sessions of other users are not found
+	session, err := sessions.Default.Get(id)
+	if err == nil && !strings.EqualFold(session.Login, gh.UserFromContext(r.Context())) {
+		err = sessions.ErrInvalid
+	}
+	if err == nil {
+		session, err = sessions.Default.Revoke(id)
+	}
+	if err != nil {
+		revokeError(err).Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionSessionRevoke, Path: session.ID})
+
+	jsonResponse(w, http.StatusOK, session)
+}
+
+// @Summary		List sessions of users
+// @Description	active sessions of every user, or of one user with the login param
+// @Tags		admin
+// @Accept		json
+// @Produce		json
+// @Param		login			query	string	false	"github login of the user"
+// @Success		200	{object}	[]sessions.Session
+// @Failure		403	{object}	errorData
+// @Router		/admin/sessions	[get]
+// @Security	bearerToken
+func getAdminSessions(w http.ResponseWriter, r *http.Request) {
+	list, err := sessions.Default.List(r.URL.Query().Get("login"))
+	if err != nil {
+		errSessionsList().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, list)
+}
+
+// @Summary		Revoke session of a user
+// @Tags		admin
+// @Accept		json
+// @Produce		json
+// @Param		id				path	string	true	"session id"
+// @Success		200	{object}	sessions.Session
+// @Failure		404	{object}	errorData
+// @Router		/admin/sessions/{id}	[delete]
+// @Security	bearerToken
+func delAdminSession(w http.ResponseWriter, r *http.Request) {
+	session, err := sessions.Default.Revoke(chi.URLParam(r, "id"))
+	if err != nil {
+		revokeError(err).Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionSessionRevoke, Path: session.ID, Details: session.Login})
+
+	jsonResponse(w, http.StatusOK, session)
+}
+
+// @Summary		Revoke sessions of a user
+// @Tags		admin
+// @Accept		json
+// @Produce		json
+// @Param		login			path	string	true	"github login of the user"
+// @Success		200	{object}	[]sessions.Session
+// @Failure		400	{object}	errorData
+// @Router		/admin/users/{login}/sessions	[delete]
+// @Security	bearerToken
+func delAdminUserSessions(w http.ResponseWriter, r *http.Request) {
+	revoked, err := sessions.Default.RevokeAll(chi.URLParam(r, "login"))
+	if err != nil {
+		errSessionsRevoke().Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionSessionRevoke, Details: "all sessions of " + chi.URLParam(r, "login")})
+
+	jsonResponse(w, http.StatusOK, revoked)
+}
+
+func revokeError(err error) *errorData {
+	e := errSessionsRevoke()
+	if errors.Is(err, sessions.ErrInvalid) {
+		e.Status(http.StatusNotFound)
+	}
+	return e
+}
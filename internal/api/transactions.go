@@ -0,0 +1,449 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/pkg/content"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+const (
+	txOpCreate = "create"
+	txOpUpdate = "update"
+	txOpDelete = "delete"
+
+	txTypeEntry   = "entry"
+	txTypeSetting = "setting"
+	txTypeImage   = "image"
+
+	txStatusValid   = "valid"
+	txStatusFailed  = "failed"
+	txStatusApplied = "applied"
+)
+
+type transactionPayload struct {
+	Message    string                  `json:"message"`
+	Operations []*transactionOperation `json:"operations"`
+}
+
+type transactionOperation struct {
+	Op         string `json:"op"`
+	Type       string `json:"type"`
+	Collection string `json:"collection,omitempty"`
+	Entry      string `json:"entry,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Contents   string `json:"contents,omitempty"`
+	IfMatch    string `json:"ifMatch,omitempty"`
+}
+
+type transactionResult struct {
+	Index  int        `json:"index"`
+	Op     string     `json:"op"`
+	Type   string     `json:"type"`
+	Target string     `json:"target"`
+	Status string     `json:"status"`
+	Paths  []string   `json:"paths,omitempty"`
+	Error  *errorData `json:"error,omitempty"`
+}
+
+type transactionResponse struct {
+	Committed bool                 `json:"committed"`
+	Results   []*transactionResult `json:"results"`
+}
+
+// @Summary		Apply transaction
+// @Description	validates every operation first, then applies all of them as a single commit or none at all
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string				true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string				true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string				true	"git ref (branch, tag, sha)"
+// @Param		payload			body	transactionPayload	true	"transaction payload"
+// @Success		200	{object}	transactionResponse
+// @Failure		400	{object}	transactionResponse
+// @Failure		409	{object}	transactionResponse
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/transactions	[post]
+// @Security	bearerToken
+func postTransaction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	tx := &transactionPayload{}
+	err := json.NewDecoder(r.Body).Decode(tx)
+	if err != nil {
+		errJsonDecode().Log(r, err).Json(w)
+		return
+	}
+	if len(tx.Operations) == 0 {
+		m := "no operations"
+		errCmsTransaction().Details(m).Log(r, errors.New(m)).Json(w)
+		return
+	}
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetTree().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	// entries are read at the commit the branch points to now, so the commit
+	// can't overwrite changes landing while the transaction is prepared
+	base, _, err := gh.GetPathSHA(ctx, accessToken, owner, repo, ref, "")
+	if err != nil {
+		errReposGetTree().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	tp := &txPlan{
+		base:    base,
+		schemas: make(map[string]*content.Schema),
+		created: make(map[cms.EntryRef]bool),
+		deleted: make(map[cms.EntryRef]bool),
+		paths:   make(map[string]int),
+		pending: make([]*events.Event, 0),
+	}
+	res := &transactionResponse{Results: make([]*transactionResult, 0)}
+	items := make([]gh.BlobEntry, 0)
+	refs := make(map[int][]cms.EntryRef)
+	failed := false
+
+	// validate and prepare every operation before touching the repository
+	for i, op := range tx.Operations {
+		tr := &transactionResult{Index: i, Op: op.Op, Type: op.Type, Status: txStatusValid}
+		res.Results = append(res.Results, tr)
+
+		opItems, opRefs, e, err := tp.prepare(r, accessToken, owner, repo, ref, cmsConfig, locales, op, tr)
+		if e == nil {
+			e, err = tp.claim(i, opItems)
+		}
+		if e != nil {
+			tr.Status, tr.Error = txStatusFailed, e.Log(r, err)
+			failed = true
+			continue
+		}
+
+		for _, item := range opItems {
+			tr.Paths = append(tr.Paths, item.Path)
+		}
+		items = append(items, opItems...)
+		refs[i] = opRefs
+	}
+
+	// references have to point to entries existing after the transaction
+	for i, opRefs := range refs {
+		for _, er := range opRefs {
+			if err := tp.checkReference(r, accessToken, owner, repo, ref, cmsConfig, er); err != nil {
+				res.Results[i].Status, res.Results[i].Error = txStatusFailed, errCmsReference().Details(er.Collection+"/"+er.ID).Log(r, err)
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	// upload binary blobs only once everything is valid
+	for i, item := range items {
+		if item.Content == nil || !strings.HasPrefix(item.Path, cms.ImagesFolder+"/") {
+			continue
+		}
+		encoding := "base64"
+		blob, _, err := gh.CreateBlob(ctx, accessToken, owner, repo, ref, item.Content, &encoding)
+		if err != nil {
+			errReposCreateBlob().Github(err).Log(r, err).Json(w)
+			return
+		}
+		items[i] = gh.BlobEntry{Path: item.Path, SHA: blob.SHA}
+	}
+
+	msg := tx.Message
+	if len(msg) == 0 {
+		msg = commitMessage("content", "transaction", fmt.Sprintf("%d operations", len(tx.Operations)))
+	}
+
+	commit, _, err := gh.CommitBlobsAt(ctx, accessToken, owner, repo, ref, tp.base, items, msg)
+	if errors.Is(err, gh.ErrConflict) {
+		errCmsEntryConflict().Log(r, err)
+		jsonResponse(w, http.StatusConflict, res)
+		return
+	}
+	if err != nil {
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	res.Committed = true
+	for _, tr := range res.Results {
+		tr.Status = txStatusApplied
+	}
+	for _, e := range tp.pending {
+		emit(r, e, commit)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// txPlan keeps track of what a transaction touches while it is being validated
+type txPlan struct {
+	base    string
+	schemas map[string]*content.Schema
+	created map[cms.EntryRef]bool
+	deleted map[cms.EntryRef]bool
+	paths   map[string]int
+	pending []*events.Event
+}
+
+func (tp *txPlan) prepare(r *http.Request, accessToken, owner, repo, ref string, cmsConfig *cms.Config, locales cms.Locales, op *transactionOperation, tr *transactionResult) ([]gh.BlobEntry, []cms.EntryRef, *errorData, error) {
+	switch op.Type {
+	case txTypeEntry:
+		return tp.prepareEntry(r, accessToken, owner, repo, ref, cmsConfig, locales, op, tr)
+	case txTypeSetting:
+		if e, err := checkAccess(r.Context(), cms.ActionSettings, ""); e != nil {
+			return nil, nil, e, err
+		}
+		items, e, err := prepareSetting(op, tr)
+		if e == nil && op.Op == txOpDelete {
+			e, err = tp.exists(r.Context(), accessToken, owner, repo, tr.Target)
+		}
+		return items, nil, e, err
+	case txTypeImage:
+		action := cms.ActionCreate
+		if op.Op == txOpDelete {
+			action = cms.ActionDelete
+		}
+		if e, err := checkAccess(r.Context(), action, ""); e != nil {
+			return nil, nil, e, err
+		}
+		items, e, err := prepareImage(op, tr)
+		if e == nil && op.Op == txOpDelete {
+			e, err = tp.exists(r.Context(), accessToken, owner, repo, tr.Target)
+		}
+		return items, nil, e, err
+	}
+
+	m := fmt.Sprintf("unknown operation type: %s", op.Type)
+	return nil, nil, errCmsTransaction().Details(m), errors.New(m)
+}
+
+func (tp *txPlan) prepareEntry(r *http.Request, accessToken, owner, repo, ref string, cmsConfig *cms.Config, locales cms.Locales, op *transactionOperation, tr *transactionResult) ([]gh.BlobEntry, []cms.EntryRef, *errorData, error) {
+	ctx := r.Context()
+	collection := op.Collection
+	if len(collection) == 0 {
+		m := "missing collection name"
+		return nil, nil, errCmsTransaction().Details(m), errors.New(m)
+	}
+	if err := cms.ValidName(collection); err != nil {
+		return nil, nil, errCmsName().Details(err.Error()), err
+	}
+
+	if op.Op == txOpDelete {
+		if len(op.Entry) == 0 {
+			m := "missing entry name"
+			return nil, nil, errCmsTransaction().Details(m), errors.New(m)
+		}
+		if err := cms.ValidName(op.Entry); err != nil {
+			return nil, nil, errCmsName().Details(err.Error()), err
+		}
+		tr.Target = filepath.Join(collection, op.Entry)
+		path := filepath.Join(cmsConfig.WorkDir, collection, op.Entry)
+		if e, err := checkAccess(ctx, cms.ActionDelete, collection); e != nil {
+			return nil, nil, e, err
+		}
+		_, items, resp, err := getTrashEntries(ctx, accessToken, owner, repo, ref, cms.TrashKindEntry, collection, op.Entry, path)
+		if err != nil {
+			e := errCmsDeleteFolder()
+			if resp != nil {
+				e.Github(err)
+			}
+			return nil, nil, e, err
+		}
+		tp.deleted[cms.EntryRef{Collection: collection, ID: op.Entry}] = true
+		tp.pending = append(tp.pending, newEvent(events.EntryDelete, owner, repo, ref, collection, op.Entry))
+		return items, nil, nil, nil
+	}
+
+	if op.Op != txOpCreate && op.Op != txOpUpdate {
+		m := fmt.Sprintf("unknown operation: %s", op.Op)
+		return nil, nil, errCmsTransaction().Details(m), errors.New(m)
+	}
+
+	cs, err := tp.schema(r, accessToken, owner, repo, ref, cmsConfig, collection)
+	if err != nil {
+		return nil, nil, errCmsParseSchema(), err
+	}
+
+	contentData := content.MergedContentData{}
+	err = json.Unmarshal([]byte(op.Contents), &contentData)
+	if err != nil {
+		return nil, nil, errCmsReadContent(), err
+	}
+
+	entry := ""
+	if op.Op == txOpCreate {
+		if len(op.Entry) > 0 {
+			contentData.ID = op.Entry
+		}
+		contentData.ID = strings.ToLower(contentData.ID)
+	} else {
+		entry = op.Entry
+		contentData.ID = op.Entry
+	}
+	if len(contentData.ID) == 0 {
+		m := "missing entry name"
+		return nil, nil, errCmsTransaction().Details(m), errors.New(m)
+	}
+	tr.Target = filepath.Join(collection, contentData.ID)
+
+	err = cms.ValidateContent(*cs, contentData, locales.Default())
+	if err != nil {
+		return nil, nil, errCmsSchemaValidation().Details(err.Error()), err
+	}
+
+	ew, e, err := prepareEntryWrite(ctx, accessToken, owner, repo, tp.base, cmsConfig, cs, locales, collection, entry, op.IfMatch, &contentData)
+	if e != nil {
+		return nil, nil, e, err
+	}
+
+	if op.Op == txOpCreate {
+		tp.created[cms.EntryRef{Collection: collection, ID: contentData.ID}] = true
+	}
+
+	typ := events.EntryUpdate
+	if ew.Current == nil {
+		typ = events.EntryCreate
+	}
+	ev := newEvent(typ, owner, repo, ref, collection, contentData.ID)
+	ev.Locales = locales.Codes()
+	tp.pending = append(tp.pending, ev)
+
+	return ew.Items, cms.References(*cs, contentData), nil, nil
+}
+
+func (tp *txPlan) schema(r *http.Request, accessToken, owner, repo, ref string, cmsConfig *cms.Config, collection string) (*content.Schema, error) {
+	if cs, ok := tp.schemas[collection]; ok {
+		return cs, nil
+	}
+
+	cs, _, err := getContentSchema(r.Context(), accessToken, owner, repo, ref, cmsConfig.WorkDir, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	tp.schemas[collection] = cs
+	return cs, nil
+}
+
+// exists makes sure the file an operation deletes is there at the commit the transaction is read at
+func (tp *txPlan) exists(ctx context.Context, accessToken, owner, repo, path string) (*errorData, error) {
+	_, _, err := gh.GetPathSHA(ctx, accessToken, owner, repo, tp.base, path)
+	if err != nil {
+		return errReposGetBlob().Details(path).Github(err), err
+	}
+	return nil, nil
+}
+
+// claim makes sure no two operations write the same path
+func (tp *txPlan) claim(index int, items []gh.BlobEntry) (*errorData, error) {
+	for _, item := range items {
+		if other, ok := tp.paths[item.Path]; ok {
+			m := fmt.Sprintf("path %s is already changed by operation %d", item.Path, other)
+			return errCmsTransaction().Details(m), errors.New(m)
+		}
+	}
+	for _, item := range items {
+		tp.paths[item.Path] = index
+	}
+	return nil, nil
+}
+
+func (tp *txPlan) checkReference(r *http.Request, accessToken, owner, repo, ref string, cmsConfig *cms.Config, er cms.EntryRef) error {
+	if tp.deleted[er] {
+		return fmt.Errorf("referenced entry is deleted in the same transaction: %s/%s", er.Collection, er.ID)
+	}
+	if tp.created[er] {
+		return nil
+	}
+
+	path := filepath.Join(cmsConfig.WorkDir, er.Collection, er.ID)
+	_, _, err := gh.GetTree(r.Context(), accessToken, owner, repo, ref, path)
+	if err != nil {
+		return fmt.Errorf("referenced entry not found: %s/%s", er.Collection, er.ID)
+	}
+	return nil
+}
+
+func prepareSetting(op *transactionOperation, tr *transactionResult) ([]gh.BlobEntry, *errorData, error) {
+	if len(op.Name) == 0 {
+		m := "missing setting name"
+		return nil, errCmsTransaction().Details(m), errors.New(m)
+	}
+	if err := cms.ValidName(op.Name); err != nil {
+		return nil, errCmsName().Details(err.Error()), err
+	}
+
+	path := filepath.Join(cms.SettingsFolder, strings.ToLower(op.Name)+".json")
+	tr.Target = path
+
+	switch op.Op {
+	case txOpDelete:
+		return []gh.BlobEntry{{Path: path, Content: nil}}, nil, nil
+	case txOpCreate, txOpUpdate:
+		if !json.Valid([]byte(op.Contents)) {
+			m := "setting is not valid json"
+			return nil, errCmsParseBlob().Details(m), errors.New(m)
+		}
+		contents := op.Contents
+		return []gh.BlobEntry{{Path: path, Content: &contents}}, nil, nil
+	}
+
+	m := fmt.Sprintf("unknown operation: %s", op.Op)
+	return nil, errCmsTransaction().Details(m), errors.New(m)
+}
+
+func prepareImage(op *transactionOperation, tr *transactionResult) ([]gh.BlobEntry, *errorData, error) {
+	if len(op.Name) == 0 {
+		m := "missing image name"
+		return nil, errCmsTransaction().Details(m), errors.New(m)
+	}
+	if err := cms.ValidName(filepath.Base(op.Name)); err != nil {
+		return nil, errCmsName().Details(err.Error()), err
+	}
+
+	path := filepath.Join(cms.ImagesFolder, filepath.Base(op.Name))
+	tr.Target = path
+
+	switch op.Op {
+	case txOpDelete:
+		return []gh.BlobEntry{{Path: path, Content: nil}}, nil, nil
+	case txOpCreate, txOpUpdate:
+		// contents are base64 encoded and uploaded as a blob once the transaction is valid
+		if _, err := base64.StdEncoding.DecodeString(op.Contents); err != nil {
+			return nil, errCmsReadContent().Details("image contents must be base64 encoded"), err
+		}
+		contents := op.Contents
+		return []gh.BlobEntry{{Path: path, Content: &contents}}, nil, nil
+	}
+
+	m := fmt.Sprintf("unknown operation: %s", op.Op)
+	return nil, errCmsTransaction().Details(m), errors.New(m)
+}
@@ -0,0 +1,443 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/l10n"
+	"github.com/moonwalker/moonbase/pkg/content"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+type translationImport struct {
+	Locale    string                     `json:"locale"`
+	Committed bool                       `json:"committed"`
+	Commit    string                     `json:"commit,omitempty"`
+	Imported  int                        `json:"imported"`
+	Skipped   int                        `json:"skipped"`
+	Entries   []string                   `json:"entries"`
+	Conflicts []*cms.TranslationConflict `json:"conflicts"`
+	Errors    []*translationImportError  `json:"errors,omitempty"`
+}
+
+type translationImportError struct {
+	Target string     `json:"target"`
+	Error  *errorData `json:"error"`
+}
+
+// @Summary		Get translation report
+// @Description	lists the untranslated localized fields and the translations to review after the default locale changed, of every locale other than the default one unless a locale is given
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		collection		path	string	true	"collection"
+// @Param		locale			query	string	false	"locale"
+// @Success		200	{object}	[]cms.TranslationReport
+// @Failure		400	{object}	errorData
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/translations/{collection}	[get]
+// @Security	bearerToken
+func getTranslationReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	collection := chi.URLParam(r, "collection")
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	codes := locales.Codes()[1:]
+	if locale := r.URL.Query().Get("locale"); len(locale) > 0 {
+		if err := translationLocale(locales, locale); err != nil {
+			errCmsLocale().Details(err.Error()).Log(r, err).Json(w)
+			return
+		}
+		codes = []string{locale}
+	}
+
+	cs, _, err := getContentSchema(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection)
+	if err != nil {
+		errCmsParseSchema().Log(r, err).Json(w)
+		return
+	}
+
+	files, _, err := gh.GetContentsRecursive(ctx, accessToken, owner, repo, ref, filepath.Join(cmsConfig.WorkDir, collection))
+	if err != nil {
+		errReposGetTree().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	reports, err := cms.TranslationReports(cmsConfig.WorkDir, collection, files, *cs, locales, codes)
+	if err != nil {
+		errCmsMergeLocalizedContent().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, reports)
+}
+
+// @Summary		Export translations
+// @Description	exports the localized text fields of the selected collections and entries with the text of the default locale as source, translations to review are fuzzy
+// @Tags		cms
+// @Produce		application/xliff+xml
+// @Produce		text/x-gettext-translation
+// @Param		owner			path	string		true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string		true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string		true	"git ref (branch, tag, sha)"
+// @Param		locale			query	string		true	"target locale"
+// @Param		format			query	string		false	"xliff (default) or po"
+// @Param		collection		query	[]string	false	"collections to export"
+// @Param		entry			query	[]string	false	"entries to export as collection/entry"
+// @Param		status			query	[]string	false	"only export fields of the translation statuses"
+// @Success		200
+// @Failure		400	{object}	errorData
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/translations/_export	[get]
+// @Security	bearerToken
+func exportTranslations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	q := r.URL.Query()
+	locale := q.Get("locale")
+	format := q.Get("format")
+	if len(format) == 0 {
+		format = l10n.FormatXLIFF
+	}
+	if !l10n.Valid(format) {
+		m := fmt.Sprintf("unknown format: %s", format)
+		errCmsTranslation().Details(m).Log(r, errors.New(m)).Json(w)
+		return
+	}
+
+	// collections selected as a whole have no entries listed
+	collections := make([]string, 0)
+	entries := make(map[string][]string)
+	for _, c := range q["collection"] {
+		if _, ok := entries[c]; !ok {
+			collections = append(collections, c)
+		}
+		entries[c] = nil
+	}
+	for _, e := range q["entry"] {
+		c, id, ok := strings.Cut(e, "/")
+		if !ok || len(c) == 0 || len(id) == 0 {
+			m := fmt.Sprintf("invalid entry: %s", e)
+			errCmsTranslation().Details(m).Log(r, errors.New(m)).Json(w)
+			return
+		}
+		ids, selected := entries[c]
+		if !selected {
+			collections = append(collections, c)
+		} else if ids == nil {
+			continue
+		}
+		entries[c] = append(ids, id)
+	}
+	if len(collections) == 0 {
+		m := "no collections or entries selected"
+		errCmsTranslation().Details(m).Log(r, errors.New(m)).Json(w)
+		return
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+	if err := translationLocale(locales, locale); err != nil {
+		errCmsLocale().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	catalog := &l10n.Catalog{SourceLocale: locales.Default(), TargetLocale: locale, Units: make([]*l10n.Unit, 0)}
+	for _, collection := range collections {
+		if e, err := checkAccess(ctx, cms.ActionRead, collection); e != nil {
+			e.Log(r, err).Json(w)
+			return
+		}
+
+		cs, _, err := getContentSchema(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection)
+		if err != nil {
+			errCmsParseSchema().Details(collection).Log(r, err).Json(w)
+			return
+		}
+
+		files, err := translationFiles(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, collection, entries[collection])
+		if err != nil {
+			errReposGetTree().Github(err).Log(r, err).Json(w)
+			return
+		}
+
+		merged, err := cms.MergeEntries(cmsConfig.WorkDir, files, *cs, locales)
+		if err != nil {
+			errCmsMergeLocalizedContent().Log(r, err).Json(w)
+			return
+		}
+		for _, mc := range merged {
+			catalog.Units = append(catalog.Units, cms.TranslationUnits(collection, mc, *cs, locales, locale, q["status"])...)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err = l10n.Write(buf, format, catalog)
+	if err != nil {
+		errCmsTranslation().Log(r, err).Json(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", l10n.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s%s"`, repo, locale, l10n.Extension(format)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// @Summary		Import translations
+// @Description	writes the translated units of an xliff or po file to the locale files of their entries as a single commit, units of which the source changed since the export are reported as conflicts and left out, fuzzy and empty targets are skipped
+// @Tags		cms
+// @Accept		application/xliff+xml
+// @Accept		text/x-gettext-translation
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		locale			query	string	false	"target locale, the one of the file by default"
+// @Param		format			query	string	false	"xliff or po, detected by default"
+// @Success		200	{object}	translationImport
+// @Failure		400	{object}	translationImport
+// @Failure		409	{object}	translationImport
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/translations/_import	[post]
+// @Security	bearerToken
+func importTranslations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		errCmsReadContent().Log(r, err).Json(w)
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if len(format) == 0 {
+		format = l10n.Detect(data)
+	}
+	catalog, err := l10n.Read(bytes.NewReader(data), format)
+	if err != nil {
+		errCmsTranslation().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+
+	locales, err := getLocales(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errReposGetBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	locale := q.Get("locale")
+	if len(locale) == 0 {
+		locale = catalog.TargetLocale
+	}
+	if err := translationLocale(locales, locale); err != nil {
+		errCmsLocale().Details(err.Error()).Log(r, err).Json(w)
+		return
+	}
+	if len(catalog.SourceLocale) > 0 && catalog.SourceLocale != locales.Default() {
+		m := fmt.Sprintf("source locale %s is not the default locale %s", catalog.SourceLocale, locales.Default())
+		errCmsTranslation().Details(m).Log(r, errors.New(m)).Json(w)
+		return
+	}
+
+	// units are applied entry by entry in the order they first appear
+	targets := make([]cms.EntryRef, 0)
+	units := make(map[cms.EntryRef][]*l10n.Unit)
+	for _, u := range catalog.Units {
+		// the names of the file become repository paths
+		for _, name := range []string{u.Collection, u.Entry} {
+			if err := cms.ValidName(name); err != nil {
+				errCmsName().Details(u.Key()).Log(r, err).Json(w)
+				return
+			}
+		}
+		er := cms.EntryRef{Collection: u.Collection, ID: u.Entry}
+		if _, ok := units[er]; !ok {
+			targets = append(targets, er)
+		}
+		units[er] = append(units[er], u)
+	}
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	// entries are read at the commit the branch points to now, so the commit
+	// can't overwrite changes landing while the import is prepared
+	base, _, err := gh.GetPathSHA(ctx, accessToken, owner, repo, ref, "")
+	if err != nil {
+		errReposGetTree().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	res := &translationImport{Locale: locale, Entries: make([]string, 0), Conflicts: make([]*cms.TranslationConflict, 0)}
+	schemas := make(map[string]*content.Schema)
+	items := make([]gh.BlobEntry, 0)
+	pending := make([]*events.Event, 0)
+	for _, er := range targets {
+		target := er.Collection + "/" + er.ID
+		ew, e, err := prepareTranslationImport(r, accessToken, owner, repo, ref, base, cmsConfig, locales, locale, schemas, er, units[er], res)
+		if e != nil {
+			res.Errors = append(res.Errors, &translationImportError{Target: target, Error: e.Log(r, err)})
+			continue
+		}
+		if ew == nil {
+			continue
+		}
+
+		items = append(items, ew.Items...)
+		res.Entries = append(res.Entries, target)
+		ev := newEvent(events.EntryUpdate, owner, repo, ref, er.Collection, er.ID)
+		ev.Locales = locales.Codes()
+		pending = append(pending, ev)
+	}
+	res.Skipped = len(catalog.Units) - res.Imported - len(res.Conflicts)
+
+	if len(res.Errors) > 0 {
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if len(items) == 0 {
+		jsonResponse(w, http.StatusOK, res)
+		return
+	}
+
+	commit, _, err := gh.CommitBlobsAt(ctx, accessToken, owner, repo, ref, base, items, commitMessage("content", "import translations", locale))
+	if errors.Is(err, gh.ErrConflict) {
+		errCmsEntryConflict().Log(r, err)
+		jsonResponse(w, http.StatusConflict, res)
+		return
+	}
+	if err != nil {
+		errReposCommitBlob().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	res.Committed, res.Commit = true, commit.GetSHA()
+	for _, e := range pending {
+		emit(r, e, commit)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// prepareTranslationImport applies the units to the current state of an entry, records the imported
+// units and conflicts and returns the write of the entry, nil when there is nothing to write,
+// entries are read at the base commit
+func prepareTranslationImport(r *http.Request, accessToken, owner, repo, ref, base string, cmsConfig *cms.Config, locales cms.Locales, locale string, schemas map[string]*content.Schema, er cms.EntryRef, units []*l10n.Unit, res *translationImport) (*entryWrite, *errorData, error) {
+	ctx := r.Context()
+	if e, err := checkAccess(ctx, cms.ActionUpdate, er.Collection, locale); e != nil {
+		return nil, e, err
+	}
+
+	cs := schemas[er.Collection]
+	if cs == nil {
+		var err error
+		cs, _, err = getContentSchema(ctx, accessToken, owner, repo, ref, cmsConfig.WorkDir, er.Collection)
+		if err != nil {
+			return nil, errCmsParseSchema(), err
+		}
+		schemas[er.Collection] = cs
+	}
+
+	current, tag, resp, err := getCurrentEntry(ctx, accessToken, owner, repo, base, cmsConfig.WorkDir, er.Collection, er.ID, cs)
+	if err != nil {
+		e := errReposGetBlob()
+		if resp != nil {
+			e.Github(err)
+		}
+		return nil, e, err
+	}
+
+	mc := current.Content
+	applied, conflicts, err := cms.ApplyTranslations(mc, *cs, locales, locale, units)
+	if err != nil {
+		return nil, errCmsTranslation().Details(err.Error()), err
+	}
+	if applied == 0 {
+		res.Conflicts = append(res.Conflicts, conflicts...)
+		return nil, nil, nil
+	}
+
+	err = cms.ValidateContent(*cs, *mc, locales.Default())
+	if err != nil {
+		return nil, errCmsSchemaValidation().Details(err.Error()), err
+	}
+
+	ew, e, err := prepareEntryWrite(ctx, accessToken, owner, repo, base, cmsConfig, cs, locales, er.Collection, er.ID, tag, mc)
+	if e != nil {
+		return nil, e, err
+	}
+
+	res.Imported += applied
+	res.Conflicts = append(res.Conflicts, conflicts...)
+	return ew, nil, nil
+}
+
+// translationLocale checks that the locale is one content is translated to
+func translationLocale(locales cms.Locales, locale string) error {
+	if len(locale) == 0 {
+		return errors.New("missing locale")
+	}
+	if locales.Get(locale) == nil || locale == locales.Default() {
+		return fmt.Errorf("no translations in locale: %s", locale)
+	}
+	return nil
+}
+
+// translationFiles returns the files of the entries of a collection, of every entry when none are given
+func translationFiles(ctx context.Context, accessToken, owner, repo, ref, workdir, collection string, entries []string) ([]*github.RepositoryContent, error) {
+	if entries == nil {
+		files, _, err := gh.GetContentsRecursive(ctx, accessToken, owner, repo, ref, filepath.Join(workdir, collection))
+		return files, err
+	}
+
+	files := make([]*github.RepositoryContent, 0)
+	for _, id := range entries {
+		rc, _, err := gh.GetAllLocaleContents(ctx, accessToken, owner, repo, ref, filepath.Join(workdir, collection, id))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rc...)
+	}
+	return files, nil
+}
@@ -0,0 +1,380 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/audit"
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/events"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+type trashItem struct {
+	*cms.TrashItem
+	ExpiresAt time.Time `json:"expiresAt"`
+	Expired   bool      `json:"expired"`
+}
+
+type trashPurgeResult struct {
+	Purged []string `json:"purged"`
+}
+
+func newTrashItem(item *cms.TrashItem, retention time.Duration) *trashItem {
+	return &trashItem{item, item.ExpiresAt(retention), item.Expired(retention)}
+}
+
+// moveToTrash moves the folder at path into a new trash item and records who deleted it
+func moveToTrash(ctx context.Context, accessToken, owner, repo, ref, kind, collection, entry, path string) (*cms.TrashItem, *github.Commit, *github.Response, error) {
+	item, items, resp, err := getTrashEntries(ctx, accessToken, owner, repo, ref, kind, collection, entry, path)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+
+	commit, resp, err := gh.CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage(collection, "trash", filepath.Base(path)))
+	if err != nil {
+		return nil, nil, resp, err
+	}
+
+	return item, commit, resp, nil
+}
+
+// getTrashEntries returns the blob entries moving the folder at path into a new trash item
+func getTrashEntries(ctx context.Context, accessToken, owner, repo, ref, kind, collection, entry, path string) (*cms.TrashItem, []gh.BlobEntry, *github.Response, error) {
+	item := cms.NewTrashItem(kind, collection, entry, path, gh.UserFromContext(ctx))
+	meta, err := json.Marshal(item)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	items, resp, err := gh.GetMoveFolderEntries(ctx, accessToken, owner, repo, ref, path, item.FilesPath())
+	if err != nil {
+		return nil, nil, resp, err
+	}
+
+	metaContent := string(meta)
+	items = append(items,
+		gh.BlobEntry{Path: item.MetaPath(), Content: &metaContent},
+		gh.BlobEntry{Path: item.IndexPath(), Content: &metaContent},
+	)
+
+	return item, items, resp, nil
+}
+
+func getTrashItems(ctx context.Context, accessToken, owner, repo, ref string) ([]*cms.TrashItem, *github.Response, error) {
+	items := make([]*cms.TrashItem, 0)
+
+	repoContents, resp, err := gh.GetTree(ctx, accessToken, owner, repo, ref, cms.TrashFolder)
+	if err != nil {
+		// no trash folder yet
+		if errors.Is(err, gh.ErrNotFound) {
+			return items, resp, nil
+		}
+		return nil, resp, err
+	}
+
+	for _, rc := range repoContents {
+		// the index is not an item
+		if *rc.Type != "dir" || !cms.ValidTrashID(*rc.Name) {
+			continue
+		}
+		item, resp, err := getTrashItem(ctx, accessToken, owner, repo, ref, *rc.Name)
+		if err != nil {
+			return nil, resp, err
+		}
+		items = append(items, item)
+	}
+
+	return items, resp, nil
+}
+
+func getTrashItem(ctx context.Context, accessToken, owner, repo, ref, id string) (*cms.TrashItem, *github.Response, error) {
+	path := filepath.Join(cms.TrashItemPath(id), cms.TrashMetaName)
+	data, resp, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	item, err := cms.ParseTrashItem(data)
+	return item, resp, err
+}
+
+// findTrashed returns the latest trash item holding the given entry, if any,
+// looked up in the trash index of the collection
+func findTrashed(ctx context.Context, accessToken, owner, repo, ref, collection, entry string) *cms.TrashItem {
+	files, _, err := gh.ListFiles(ctx, accessToken, owner, repo, ref, cms.TrashIndexPath(collection))
+	if err != nil {
+		return nil
+	}
+
+	path := cms.LatestTrashed(files, collection, entry)
+	if len(path) == 0 {
+		return nil
+	}
+
+	data, _, err := gh.GetBlob(ctx, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return nil
+	}
+	item, err := cms.ParseTrashItem(data)
+	if err != nil || !item.Contains(collection, entry) {
+		return nil
+	}
+
+	return item
+}
+
+// trashIndexEntries returns the blob entry removing the index file of the item,
+// items trashed before the index was kept have none
+func trashIndexEntries(ctx context.Context, accessToken, owner, repo, ref string, item *cms.TrashItem) ([]gh.BlobEntry, *github.Response, error) {
+	files, resp, err := gh.ListFiles(ctx, accessToken, owner, repo, ref, cms.TrashIndexPath(item.Collection))
+	if err != nil {
+		if errors.Is(err, gh.ErrNotFound) {
+			return nil, resp, nil
+		}
+		return nil, resp, err
+	}
+
+	for _, f := range files {
+		if f.GetPath() == item.IndexPath() {
+			return []gh.BlobEntry{{Path: item.IndexPath(), Content: nil}}, resp, nil
+		}
+	}
+	return nil, resp, nil
+}
+
+// trashPurgeEntries returns the blob entries removing the items from the trash along with their index files
+func trashPurgeEntries(ctx context.Context, accessToken, owner, repo, ref string, items []*cms.TrashItem) ([]gh.BlobEntry, *github.Response, error) {
+	res := make([]gh.BlobEntry, 0)
+	for _, item := range items {
+		files, resp, err := gh.ListFiles(ctx, accessToken, owner, repo, ref, cms.TrashItemPath(item.ID))
+		if err != nil {
+			return nil, resp, err
+		}
+		for _, f := range files {
+			res = append(res, gh.BlobEntry{Path: f.GetPath(), Content: nil})
+		}
+
+		index, resp, err := trashIndexEntries(ctx, accessToken, owner, repo, ref, item)
+		if err != nil {
+			return nil, resp, err
+		}
+		res = append(res, index...)
+	}
+	return res, nil, nil
+}
+
+// @Summary		Get trash
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Success		200	{object}	[]trashItem
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/trash	[get]
+// @Security	bearerToken
+func getTrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	items, _, err := getTrashItems(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errCmsTrashList().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	res := make([]*trashItem, 0)
+	for _, item := range items {
+		res = append(res, newTrashItem(item, cmsConfig.TrashRetention()))
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// @Summary		Restore trashed item
+// @Tags		cms
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		id				path	string	true	"trash item id"
+// @Success		200	{object}	trashItem
+// @Failure		409	{object}	errorData
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/trash/{id}/restore	[post]
+// @Security	bearerToken
+func restoreTrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	id := chi.URLParam(r, "id")
+	if !cms.ValidTrashID(id) {
+		errNotFound().Details(id).Log(r, fmt.Errorf("invalid trash item id: %q", id)).Json(w)
+		return
+	}
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	item, _, err := getTrashItem(ctx, accessToken, owner, repo, ref, id)
+	if err != nil {
+		errCmsTrashRestore().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	action := cms.ActionCreate
+	if item.Kind == cms.TrashKindCollection {
+		action = cms.ActionSchema
+	}
+	if e, err := checkAccess(ctx, action, item.Collection); e != nil {
+		e.Log(r, err).Json(w)
+		return
+	}
+
+	// the original location has been reused in the meantime
+	_, _, err = gh.GetTree(ctx, accessToken, owner, repo, ref, item.Path)
+	if err == nil {
+		m := "restore target already exists"
+		errCmsTrashRestoreConflict().Details(item.Path).Log(r, errors.New(m)).Json(w)
+		return
+	}
+
+	extra := []gh.BlobEntry{{Path: item.MetaPath(), Content: nil}}
+	index, _, err := trashIndexEntries(ctx, accessToken, owner, repo, ref, item)
+	if err != nil {
+		errCmsTrashRestore().Github(err).Log(r, err).Json(w)
+		return
+	}
+	extra = append(extra, index...)
+	commit, _, err := gh.MoveFolder(ctx, accessToken, owner, repo, ref, item.FilesPath(), item.Path, extra, commitMessage("trash", "restore", id))
+	if err != nil {
+		errCmsTrashRestore().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	if item.Kind == cms.TrashKindCollection {
+		emit(r, newEvent(events.CollectionCreate, owner, repo, ref, item.Collection, ""), commit)
+	} else {
+		emit(r, newEvent(events.EntryCreate, owner, repo, ref, item.Collection, item.Entry), commit)
+	}
+
+	jsonResponse(w, http.StatusOK, newTrashItem(item, cmsConfig.TrashRetention()))
+}
+
+// @Summary		Purge trashed item
+// @Tags		cms
+// @Accept		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		id				path	string	true	"trash item id"
+// @Success		200	{object}	trashPurgeResult
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/trash/{id}	[delete]
+// @Security	bearerToken
+func purgeTrashItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+	id := chi.URLParam(r, "id")
+	if !cms.ValidTrashID(id) {
+		errNotFound().Details(id).Log(r, fmt.Errorf("invalid trash item id: %q", id)).Json(w)
+		return
+	}
+
+	item, _, err := getTrashItem(ctx, accessToken, owner, repo, ref, id)
+	if err != nil {
+		errCmsTrashPurge().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	items, _, err := trashPurgeEntries(ctx, accessToken, owner, repo, ref, []*cms.TrashItem{item})
+	if err == nil {
+		_, _, err = gh.CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage("trash", "purge", id))
+	}
+	if err != nil {
+		errCmsTrashPurge().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	auditLog(r, &audit.Record{Action: audit.ActionTrashPurge, Path: cms.TrashItemPath(id)})
+
+	jsonResponse(w, http.StatusOK, &trashPurgeResult{Purged: []string{id}})
+}
+
+// @Summary		Purge expired trash
+// @Description	permanently removes every trashed item older than the configured retention period
+// @Tags		cms
+// @Accept		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Success		200	{object}	trashPurgeResult
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/trash	[delete]
+// @Security	bearerToken
+func purgeTrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken := gh.AccessTokenFromContext(ctx)
+
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	ref := chi.URLParam(r, "ref")
+
+	cmsConfig := getConfig(ctx, accessToken, owner, repo, ref)
+
+	items, _, err := getTrashItems(ctx, accessToken, owner, repo, ref)
+	if err != nil {
+		errCmsTrashList().Github(err).Log(r, err).Json(w)
+		return
+	}
+
+	res := &trashPurgeResult{Purged: make([]string, 0)}
+	paths := make([]string, 0)
+	expired := make([]*cms.TrashItem, 0)
+	for _, item := range items {
+		if item.Expired(cmsConfig.TrashRetention()) {
+			res.Purged = append(res.Purged, item.ID)
+			paths = append(paths, cms.TrashItemPath(item.ID))
+			expired = append(expired, item)
+		}
+	}
+
+	if len(paths) > 0 {
+		entries, _, err := trashPurgeEntries(ctx, accessToken, owner, repo, ref, expired)
+		if err == nil {
+			_, _, err = gh.CommitBlobs(ctx, accessToken, owner, repo, ref, entries, commitMessage("trash", "purge", "expired"))
+		}
+		if err != nil {
+			errCmsTrashPurge().Github(err).Log(r, err).Json(w)
+			return
+		}
+		for _, p := range paths {
+			auditLog(r, &audit.Record{Action: audit.ActionTrashPurge, Path: p})
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
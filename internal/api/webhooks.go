@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/moonwalker/moonbase/internal/webhooks"
+)
+
+// @Summary		Get webhook deliveries
+// @Tags		webhooks
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		hook			query	string	false	"only deliveries of the given hook id"
+// @Success		200	{object}	[]webhooks.Delivery
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/webhooks/deliveries	[get]
+// @Security	bearerToken
+func getDeliveries(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	hook := r.FormValue("hook")
+
+	dls, err := webhooks.Default.Deliveries(owner, repo)
+	if err != nil {
+		errWebhooksDeliveries().Log(r, err).Json(w)
+		return
+	}
+
+	res := make([]*webhooks.Delivery, 0)
+	for _, dl := range dls {
+		if len(hook) == 0 || dl.HookID == hook {
+			res = append(res, dl)
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// @Summary		Redeliver webhook
+// @Tags		webhooks
+// @Accept		json
+// @Produce		json
+// @Param		owner			path	string	true	"the account owner of the repository (the name is not case sensitive)"
+// @Param		repo			path	string	true	"the name of the repository (the name is not case sensitive)"
+// @Param		ref				path	string	true	"git ref (branch, tag, sha)"
+// @Param		id				path	string	true	"delivery id"
+// @Success		202	{object}	webhooks.Delivery
+// @Failure		404	{object}	errorData
+// @Failure		500	{object}	errorData
+// @Router		/cms/{owner}/{repo}/{ref}/webhooks/deliveries/{id}/redeliver	[post]
+// @Security	bearerToken
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repo := chi.URLParam(r, "repo")
+	id := chi.URLParam(r, "id")
+
+	dls, err := webhooks.Default.Deliveries(owner, repo)
+	if err != nil {
+		errWebhooksDeliveries().Log(r, err).Json(w)
+		return
+	}
+
+	// deliveries of other repositories are not visible here
+	found := false
+	for _, dl := range dls {
+		if dl.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		errWebhooksDelivery().Log(r, webhooks.ErrDeliveryNotFound).Json(w)
+		return
+	}
+
+	dl, err := webhooks.Default.Redeliver(id)
+	if errors.Is(err, webhooks.ErrDeliveryNotFound) {
+		errWebhooksDelivery().Log(r, err).Json(w)
+		return
+	}
+	if errors.Is(err, webhooks.ErrHookNotFound) {
+		errWebhooksHook().Log(r, err).Json(w)
+		return
+	}
+	if err != nil {
+		errWebhooksDeliveries().Log(r, err).Json(w)
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, dl)
+}
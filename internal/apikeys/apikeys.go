@@ -0,0 +1,271 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/jwe"
+	"github.com/moonwalker/moonbase/internal/store"
+)
+
+const (
+	// Prefix tells api keys apart from login tokens
+	Prefix = "mbk_"
+
+	ScopeDelivery   = "delivery"
+	ScopePreview    = "preview"
+	ScopeManagement = "management"
+
+	storeKind = "apikeys"
+
+	// last use is only written once in a while to keep requests cheap
+	touchInterval = time.Minute
+)
+
+var (
+	ErrInvalid = errors.New("invalid api key")
+	ErrExpired = errors.New("api key expired")
+	ErrRevoked = errors.New("api key revoked")
+)
+
+// Key is the stored part of an api key, the secret itself is only kept as a hash,
+// roles are those of its creator when the key was made and limit what it may do
+type Key struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Scope       string     `json:"scope"`
+	Owner       string     `json:"owner"`
+	Repo        string     `json:"repo"`
+	Refs        []string   `json:"refs"`
+	Collections []string   `json:"collections,omitempty"`
+	Roles       []string   `json:"roles,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CreatedBy   string     `json:"createdBy"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+// record is how a key is stored, hash and wrapped token included
+type record struct {
+	Key
+	Hash  string `json:"hash"`
+	Token string `json:"token"`
+}
+
+// Manager mints, verifies and revokes api keys
+type Manager struct {
+	store store.Store
+	mu    sync.Mutex
+}
+
+var Default = &Manager{}
+
+func NewManager(s store.Store) *Manager {
+	return &Manager{store: s}
+}
+
+func (m *Manager) st() store.Store {
+	if m.store == nil {
+		return store.Default()
+	}
+	return m.store
+}
+
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeDelivery, ScopePreview, ScopeManagement:
+		return true
+	}
+	return false
+}
+
+// Create mints a new key acting with the access token of its creator, the returned
+// secret is the only time the full key is available
+func (m *Manager) Create(k *Key, accessToken string) (string, error) {
+	if !ValidScope(k.Scope) {
+		return "", errors.New("invalid scope: " + k.Scope)
+	}
+	if len(k.Owner) == 0 || len(k.Repo) == 0 || len(k.Refs) == 0 {
+		return "", errors.New("keys have to be bound to a repository and refs")
+	}
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwe.Encrypt(env.JweKey, []byte(accessToken))
+	if err != nil {
+		return "", err
+	}
+
+	k.ID = xid.New().String()
+	k.CreatedAt = time.Now().UTC()
+	plain := Prefix + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	rec := &record{Key: *k, Hash: hash(plain), Token: base64.RawURLEncoding.EncodeToString(token)}
+	err = store.PutJSON(m.st(), storeKind, k.ID, rec)
+	if err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+// Verify returns the key and the access token it acts with
+func (m *Manager) Verify(plain string) (*Key, string, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(plain, Prefix), "_")
+	if !strings.HasPrefix(plain, Prefix) || !ok {
+		return nil, "", ErrInvalid
+	}
+
+	rec := &record{}
+	err := store.GetJSON(m.st(), storeKind, id, rec)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, "", ErrInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(rec.Hash), []byte(hash(plain))) != 1 {
+		return nil, "", ErrInvalid
+	}
+	if rec.RevokedAt != nil {
+		return nil, "", ErrRevoked
+	}
+	now := time.Now().UTC()
+	if rec.ExpiresAt != nil && now.After(*rec.ExpiresAt) {
+		return nil, "", ErrExpired
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(rec.Token)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := jwe.Decrypt(env.JweKey, wrapped)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if rec.LastUsedAt == nil || now.Sub(*rec.LastUsedAt) > touchInterval {
+		m.touch(id, now)
+	}
+
+	return &rec.Key, string(token), nil
+}
+
+func (m *Manager) touch(id string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := &record{}
+	if store.GetJSON(m.st(), storeKind, id, rec) != nil {
+		return
+	}
+	rec.LastUsedAt = &now
+	store.PutJSON(m.st(), storeKind, id, rec)
+}
+
+// List returns the keys of a repository
+func (m *Manager) List(owner, repo string) ([]*Key, error) {
+	recs, err := store.ListJSON[record](m.st(), storeKind)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Key, 0)
+	for _, rec := range recs {
+		if strings.EqualFold(rec.Owner, owner) && strings.EqualFold(rec.Repo, repo) {
+			k := rec.Key
+			res = append(res, &k)
+		}
+	}
+	return res, nil
+}
+
+// Revoke disables the key of a repository for good
+func (m *Manager) Revoke(owner, repo, id string) (*Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := &record{}
+	err := store.GetJSON(m.st(), storeKind, id, rec)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(rec.Owner, owner) || !strings.EqualFold(rec.Repo, repo) {
+		return nil, store.ErrNotFound
+	}
+
+	if rec.RevokedAt == nil {
+		now := time.Now().UTC()
+		rec.RevokedAt = &now
+		err = store.PutJSON(m.st(), storeKind, id, rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &rec.Key, nil
+}
+
+// Bound reports whether the key may be used on the repository ref
+func (k *Key) Bound(owner, repo, ref string) bool {
+	if !strings.EqualFold(k.Owner, owner) || !strings.EqualFold(k.Repo, repo) {
+		return false
+	}
+	for _, r := range k.Refs {
+		if r == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the scope and the collections of the key grant the action,
+// delivery and preview keys only read while management keys can't change settings
+func (k *Key) Allows(action string, collection string) bool {
+	switch action {
+	case cms.ActionRead:
+	case cms.ActionSettings:
+		return false
+	default:
+		if k.Scope != ScopeManagement {
+			return false
+		}
+	}
+
+	if len(collection) == 0 || len(k.Collections) == 0 {
+		return true
+	}
+	for _, c := range k.Collections {
+		if c == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishedOnly reports whether the key only sees published content
+func (k *Key) PublishedOnly() bool {
+	return k.Scope == ScopeDelivery
+}
+
+func hash(plain string) string {
+	h := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,75 @@
+package apikeys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/store"
+)
+
+func TestKeys(t *testing.T) {
+	env.JweKey = []byte("0123456789abcdef0123456789abcdef")
+	m := NewManager(store.NewFile(t.TempDir()))
+
+	k := &Key{Name: "site", Scope: ScopeDelivery, Owner: "acme", Repo: "site", Refs: []string{"main"}}
+	plain, err := m.Create(k, "gh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, token, err := m.Verify(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "gh-token" || key.ID != k.ID || key.LastUsedAt != nil {
+		t.Error("unexpected key")
+	}
+	if key, _, _ = m.Verify(plain); key.LastUsedAt == nil {
+		t.Error("last use not tracked")
+	}
+
+	if _, _, err = m.Verify(plain + "x"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected invalid key, got %v", err)
+	}
+
+	keys, _ := m.List("ACME", "site")
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(keys))
+	}
+
+	if _, err = m.Revoke("acme", "other", k.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Error("revoked key of another repository")
+	}
+	m.Revoke("acme", "site", k.ID)
+	if _, _, err = m.Verify(plain); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected revoked key, got %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	plain, _ = m.Create(&Key{Scope: ScopePreview, Owner: "acme", Repo: "site", Refs: []string{"main"}, ExpiresAt: &past}, "gh-token")
+	if _, _, err = m.Verify(plain); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected expired key, got %v", err)
+	}
+
+	if _, err = m.Create(&Key{Scope: "root", Owner: "acme", Repo: "site", Refs: []string{"main"}}, "gh-token"); err == nil {
+		t.Error("created key with invalid scope")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	k := &Key{Scope: ScopeManagement, Owner: "acme", Repo: "site", Refs: []string{"main"}, Collections: []string{"posts"}}
+	if !k.Bound("Acme", "site", "main") || k.Bound("acme", "site", "dev") {
+		t.Error("unexpected ref binding")
+	}
+	if !k.Allows(cms.ActionUpdate, "posts") || k.Allows(cms.ActionUpdate, "pages") || k.Allows(cms.ActionSettings, "") {
+		t.Error("unexpected management permissions")
+	}
+
+	k.Scope = ScopePreview
+	if !k.Allows(cms.ActionRead, "posts") || k.Allows(cms.ActionCreate, "posts") || k.PublishedOnly() {
+		t.Error("unexpected preview permissions")
+	}
+}
@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/log"
+)
+
+const (
+	SinkFile   = "file"
+	SinkStdout = "stdout"
+	SinkRepo   = "repo"
+	SinkNone   = "none"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+
+	// actions besides the content event types
+	ActionLogin         = "auth.login"
+	ActionLogout        = "auth.logout"
+	ActionAuthFailure   = "auth.failure"
+	ActionDenied        = "access.denied"
+	ActionSessionRevoke = "session.revoke"
+	ActionSettingUpdate = "setting.update"
+	ActionSettingDelete = "setting.delete"
+	ActionBlobUpdate    = "blob.update"
+	ActionBlobDelete    = "blob.delete"
+	ActionImageUpload   = "image.upload"
+	ActionTrashPurge    = "trash.purge"
+	ActionAPIKeyCreate  = "apikey.create"
+	ActionAPIKeyRevoke  = "apikey.revoke"
+
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+var ErrNotQueryable = errors.New("audit: sink can't be queried")
+
+// Record is one entry of the audit trail
+type Record struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	Owner     string    `json:"owner,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	Ref       string    `json:"ref,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// Sink stores records, they are never changed once written
+type Sink interface {
+	Write(rec *Record) error
+}
+
+// Querier is implemented by sinks which can be read back
+type Querier interface {
+	Query(f *Filter) ([]*Record, error)
+}
+
+// Filter selects records, empty fields match everything and path matches by prefix
+type Filter struct {
+	User    string
+	Action  string
+	Outcome string
+	Owner   string
+	Repo    string
+	Ref     string
+	Path    string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+var shared struct {
+	sync.Mutex
+	sink Sink
+}
+
+// Default returns the sink configured by the environment
+func Default() Sink {
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.sink != nil {
+		return shared.sink
+	}
+
+	switch env.AuditSink {
+	case SinkNone:
+		shared.sink = discard{}
+	case SinkStdout:
+		shared.sink = NewStdout()
+	case SinkRepo:
+		owner, repo, _ := strings.Cut(env.AuditRepo, "/")
+		if len(owner) == 0 || len(repo) == 0 {
+			log.Error(errors.New("audit repo missing")).Str("repo", env.AuditRepo).Msg("audit sink unavailable, falling back to file")
+			break
+		}
+		shared.sink = NewRepo(owner, repo, env.AuditBranch)
+	}
+	if shared.sink == nil {
+		shared.sink = NewFile(filepath.Join(env.StoreDir, "audit.jsonl"))
+	}
+
+	return shared.sink
+}
+
+// SetDefault replaces the sink records are written to
+func SetDefault(s Sink) {
+	shared.Lock()
+	shared.sink = s
+	shared.Unlock()
+}
+
+// Log writes the record to the default sink, failures are logged as the
+// request causing the record already happened
+func Log(rec *Record) {
+	if len(rec.ID) == 0 {
+		rec.ID = xid.New().String()
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	if len(rec.Outcome) == 0 {
+		rec.Outcome = OutcomeSuccess
+	}
+
+	err := Default().Write(rec)
+	if err != nil {
+		log.Error(err).Str("action", rec.Action).Str("user", rec.User).Msg("failed to write audit record")
+	}
+}
+
+// Query reads records of the default sink, newest first
+func Query(f *Filter) ([]*Record, error) {
+	q, ok := Default().(Querier)
+	if !ok {
+		return nil, ErrNotQueryable
+	}
+	return q.Query(f)
+}
+
+// Match reports whether the record passes the filter
+func (f *Filter) Match(rec *Record) bool {
+	switch {
+	case len(f.User) > 0 && !strings.EqualFold(f.User, rec.User):
+		return false
+	case len(f.Action) > 0 && f.Action != rec.Action && !strings.HasPrefix(rec.Action, f.Action+"."):
+		return false
+	case len(f.Outcome) > 0 && f.Outcome != rec.Outcome:
+		return false
+	case len(f.Owner) > 0 && !strings.EqualFold(f.Owner, rec.Owner):
+		return false
+	case len(f.Repo) > 0 && !strings.EqualFold(f.Repo, rec.Repo):
+		return false
+	case len(f.Ref) > 0 && f.Ref != rec.Ref:
+		return false
+	case len(f.Path) > 0 && !strings.HasPrefix(rec.Path, f.Path):
+		return false
+	case !f.From.IsZero() && rec.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !rec.Time.Before(f.To):
+		return false
+	}
+	return true
+}
+
+func (f *Filter) limit() int {
+	if f.Limit <= 0 {
+		return defaultLimit
+	}
+	if f.Limit > maxLimit {
+		return maxLimit
+	}
+	return f.Limit
+}
+
+type discard struct{}
+
+func (discard) Write(rec *Record) error {
+	return nil
+}
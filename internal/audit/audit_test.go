@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileQuery(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "audit", "audit.jsonl"))
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	records := []*Record{
+		{ID: "1", User: "octocat", Action: "entry.create", Owner: "acme", Repo: "site", Path: "posts/hello"},
+		{ID: "2", User: "octocat", Action: "entry.publish", Owner: "acme", Repo: "site", Path: "posts/hello"},
+		{ID: "3", User: "hubot", Action: ActionDenied, Outcome: OutcomeDenied, Owner: "acme", Repo: "site", Path: "_settings/roles.json"},
+		{ID: "4", User: "octocat", Action: ActionLogin},
+	}
+	for i, rec := range records {
+		rec.Time = start.Add(time.Duration(i) * time.Hour)
+		if err := f.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		ids    []string
+	}{
+		{"all, newest first", &Filter{}, []string{"4", "3", "2", "1"}},
+		{"user", &Filter{User: "OctoCat"}, []string{"4", "2", "1"}},
+		{"action prefix", &Filter{Action: "entry"}, []string{"2", "1"}},
+		{"path prefix", &Filter{Path: "_settings/"}, []string{"3"}},
+		{"outcome", &Filter{Outcome: OutcomeDenied}, []string{"3"}},
+		{"range", &Filter{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, []string{"3", "2"}},
+		{"limit", &Filter{Limit: 2}, []string{"4", "3"}},
+	}
+	for _, tt := range tests {
+		res, err := f.Query(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != len(tt.ids) {
+			t.Errorf("%s: expected %v, got %d records", tt.name, tt.ids, len(res))
+			continue
+		}
+		for i, rec := range res {
+			if rec.ID != tt.ids[i] {
+				t.Errorf("%s: expected %v, got %s at %d", tt.name, tt.ids, rec.ID, i)
+			}
+		}
+	}
+}
+
+func TestQueryMissingFile(t *testing.T) {
+	res, err := NewFile(filepath.Join(t.TempDir(), "audit.jsonl")).Query(&Filter{})
+	if err != nil || len(res) != 0 {
+		t.Errorf("expected no records, got %d %v", len(res), err)
+	}
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File appends records as json lines to a local file
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Write(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(f.path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Query scans the whole file, only the newest matches up to the limit are kept
+func (f *File) Query(flt *Filter) ([]*Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make([]*Record, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return scan(file, flt)
+}
+
+// scan reads json lines in chronological order and returns the newest matching records first
+func scan(r io.Reader, flt *Filter) ([]*Record, error) {
+	limit := flt.limit()
+	ring := make([]*Record, 0, limit)
+	next := 0
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		rec := &Record{}
+		if json.Unmarshal(sc.Bytes(), rec) != nil || !flt.Match(rec) {
+			continue
+		}
+		if len(ring) < limit {
+			ring = append(ring, rec)
+			continue
+		}
+		ring[next] = rec
+		next = (next + 1) % limit
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]*Record, 0, len(ring))
+	for i := len(ring) - 1; i >= 0; i-- {
+		res = append(res, ring[(next+i)%len(ring)])
+	}
+	return res, nil
+}
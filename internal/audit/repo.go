@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/log"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+const (
+	repoFolder = "audit"
+	dayLayout  = "2006-01-02"
+
+	// records are committed in batches, one commit per flush
+	flushInterval = 30 * time.Second
+	maxPending    = 10000
+	// queries without a start only read the files of the last days
+	defaultQueryDays = 7
+	maxQueryDays     = 31
+)
+
+// Repo commits records to a branch of a github repository, one json lines file per day
+type Repo struct {
+	owner  string
+	repo   string
+	branch string
+
+	mu      sync.Mutex
+	pending []*Record
+}
+
+func NewRepo(owner, repo, branch string) *Repo {
+	s := &Repo{owner: owner, repo: repo, branch: branch}
+	go func() {
+		for range time.Tick(flushInterval) {
+			s.Flush()
+		}
+	}()
+	return s
+}
+
+// Write queues the record for the next flush
+func (s *Repo) Write(rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) >= maxPending {
+		return errors.New("audit: too many records pending")
+	}
+	s.pending = append(s.pending, rec)
+	return nil
+}
+
+// Flush commits the pending records, they are kept for the next flush when the commit fails
+func (s *Repo) Flush() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	err := s.commit(pending)
+	if err != nil {
+		log.Error(err).Str("repo", s.owner+"/"+s.repo).Int("records", len(pending)).Msg("failed to commit audit records")
+		s.mu.Lock()
+		s.pending = append(pending, s.pending...)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Repo) commit(records []*Record) error {
+	ctx := context.Background()
+	token, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	days := make(map[string]*bytes.Buffer)
+	for _, rec := range records {
+		p := dayPath(rec.Time)
+		if days[p] == nil {
+			current, resp, err := gh.GetBlob(ctx, token, s.owner, s.repo, s.branch, p)
+			if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+				return err
+			}
+			days[p] = bytes.NewBuffer(current)
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		days[p].Write(append(data, '\n'))
+	}
+
+	items := make([]gh.BlobEntry, 0, len(days))
+	for p, buf := range days {
+		c := buf.String()
+		items = append(items, gh.BlobEntry{Path: p, Content: &c})
+	}
+
+	_, _, err = gh.CommitBlobs(ctx, token, s.owner, s.repo, s.branch, items, fmt.Sprintf("chore(audit): %d records", len(records)))
+	return err
+}
+
+// Query reads the files of the days the filter spans, pending records included
+func (s *Repo) Query(flt *Filter) ([]*Record, error) {
+	ctx := context.Background()
+	token, err := s.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	to := flt.To
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	from := flt.From
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -defaultQueryDays)
+	}
+	if to.Sub(from) > maxQueryDays*24*time.Hour {
+		from = to.AddDate(0, 0, -maxQueryDays)
+	}
+
+	res := make([]*Record, 0)
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.AddDate(0, 0, 1) {
+		data, resp, err := gh.GetBlob(ctx, token, s.owner, s.repo, s.branch, dayPath(day))
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		recs, err := scan(bytes.NewReader(data), flt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, recs...)
+	}
+
+	s.mu.Lock()
+	for _, rec := range s.pending {
+		if flt.Match(rec) {
+			res = append(res, rec)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.After(res[j].Time)
+	})
+	if len(res) > flt.limit() {
+		res = res[:flt.limit()]
+	}
+	return res, nil
+}
+
+// token returns the credential the audit repository is written with
+func (s *Repo) token(ctx context.Context) (string, error) {
+	if len(env.AuditToken) > 0 {
+		return env.AuditToken, nil
+	}
+	if gh.AppEnabled() {
+		token, _, err := gh.InstallationToken(ctx, s.owner, s.repo)
+		return token, err
+	}
+	if len(env.OIDCServiceToken) > 0 {
+		return env.OIDCServiceToken, nil
+	}
+	return "", errors.New("audit: no credential for the audit repository")
+}
+
+func dayPath(t time.Time) string {
+	return path.Join(repoFolder, t.UTC().Format(dayLayout)+".jsonl")
+}
@@ -0,0 +1,31 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stdout writes records as json lines to the standard output, to be collected with the logs
+type Stdout struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewStdout() *Stdout {
+	return &Stdout{w: os.Stdout}
+}
+
+func (s *Stdout) Write(rec *Record) error {
+	data, err := json.Marshal(map[string]any{"audit": rec})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
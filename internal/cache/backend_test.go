@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testBackend(t *testing.T, b Backend) {
+	_, err := b.Get("foo")
+	if err != ErrNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	err = b.Set("foo", []byte("bar"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := b.Get("foo")
+	if err != nil || string(v) != "bar" {
+		t.Errorf("unexpected entry: %q %v", v, err)
+	}
+
+	err = b.Delete("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get("foo"); err != ErrNotFound {
+		t.Errorf("expected deleted entry, got %v", err)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemory(time.Minute))
+}
+
+func TestDiskBackend(t *testing.T) {
+	d, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, d)
+
+	d.Set("ttl", []byte("bar"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := d.Get("ttl"); err != ErrNotFound {
+		t.Errorf("expected expired entry, got %v", err)
+	}
+
+	d.Set("sweep", []byte("bar"), time.Nanosecond)
+	d.Set("keep", []byte("bar"), time.Minute)
+	time.Sleep(time.Millisecond)
+	if err := d.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.path("sweep")); !os.IsNotExist(err) {
+		t.Errorf("expected swept entry, got %v", err)
+	}
+	if v, err := d.Get("keep"); err != nil || string(v) != "bar" {
+		t.Errorf("unexpected entry: %q %v", v, err)
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	addr := startRedis(t)
+
+	r, err := NewRedis("redis://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testBackend(t, r)
+}
+
+func TestRedisBroadcast(t *testing.T) {
+	addr := startRedis(t)
+
+	sub, err := NewRedis("redis://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	pub, err := NewRedis("redis://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	received := make(chan string, 1)
+	sub.Subscribe(invalidateChannel, func(msg string) {
+		received <- msg
+	})
+
+	err = pub.Publish(invalidateChannel, "refs foo/bar@main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg != "refs foo/bar@main" {
+			t.Errorf("unexpected message: %s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+// startRedis runs a minimal redis protocol server good enough for the backend
+func startRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	subs := make(map[string][]net.Conn)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				rc := &redisConn{c, bufio.NewReader(c)}
+				for {
+					v, err := rc.read()
+					if err != nil {
+						return
+					}
+					args := make([]string, 0)
+					for _, a := range v.([]interface{}) {
+						args = append(args, string(a.([]byte)))
+					}
+
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "PING":
+						c.Write([]byte("+PONG\r\n"))
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							c.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						data[args[1]] = args[2]
+						c.Write([]byte("+OK\r\n"))
+					case "DEL":
+						delete(data, args[1])
+						c.Write([]byte(":1\r\n"))
+					case "SUBSCRIBE":
+						subs[args[1]] = append(subs[args[1]], c)
+						rc.write([]string{"subscribe", args[1], "1"})
+					case "PUBLISH":
+						for _, s := range subs[args[1]] {
+							(&redisConn{Conn: s}).write([]string{"message", args[1], args[2]})
+						}
+						c.Write([]byte(":1\r\n"))
+					default:
+						c.Write([]byte("-ERR unknown command\r\n"))
+					}
+					mu.Unlock()
+				}
+			}(c)
+		}
+	}()
+
+	return l.Addr().String()
+}
@@ -1,37 +1,109 @@
 package cache
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
-	"github.com/allegro/bigcache/v3"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/log"
 )
 
+const (
+	BackendMemory = "memory"
+	BackendDisk   = "disk"
+	BackendRedis  = "redis"
+)
+
+var ErrNotFound = errors.New("cache: entry not found")
+
+// Backend stores cache entries, ttl is a hint for backends able to expire entries one by one
+type Backend interface {
+	Get(key string) ([]byte, error)
+	Set(key string, entry []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// Cache keeps its entries under its namespace, so caches sharing a backend never read each other's entries
 type Cache struct {
-	core *bigcache.BigCache
+	backend   Backend
+	namespace string
+	eviction  time.Duration
+}
+
+var shared = struct {
+	sync.Mutex
+	backend Backend
+}{}
+
+// New returns a cache on the backend configured by the environment
+func New(namespace string, eviction time.Duration) *Cache {
+	return NewWithBackend(defaultBackend(eviction), namespace, eviction)
+}
+
+func NewWithBackend(backend Backend, namespace string, eviction time.Duration) *Cache {
+	return &Cache{backend, namespace, eviction}
+}
+
+// defaultBackend returns the shared disk or redis backend, in-memory caches are per instance
+func defaultBackend(eviction time.Duration) Backend {
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.backend != nil {
+		return shared.backend
+	}
+
+	var err error
+	switch env.CacheBackend {
+	case BackendDisk:
+		var d *Disk
+		d, err = NewDisk(env.CacheDir)
+		if err == nil {
+			shared.backend = d
+			go d.SweepEvery(diskSweepInterval)
+		}
+	case BackendRedis:
+		var r *Redis
+		r, err = NewRedis(env.RedisURL)
+		if err == nil {
+			shared.backend = r
+			SetBroadcaster(r)
+		}
+	}
+	if err != nil {
+		log.Error(err).Str("backend", env.CacheBackend).Msg("cache backend unavailable, falling back to memory")
+	}
+	if shared.backend == nil {
+		return NewMemory(eviction)
+	}
+
+	return shared.backend
 }
 
-func New(eviction time.Duration) *Cache {
-	core, _ := bigcache.New(context.Background(), bigcache.DefaultConfig(eviction))
-	return &Cache{core}
+func (c *Cache) key(key string) string {
+	return c.namespace + ":" + key
 }
 
 // raw
 
 func (c *Cache) Get(key string) ([]byte, error) {
-	entry, err := c.core.Get(key)
-	return entry, err
+	return c.backend.Get(c.key(key))
 }
 
 func (c *Cache) Set(key string, entry []byte) error {
-	return c.core.Set(key, entry)
+	return c.backend.Set(c.key(key), entry, c.eviction)
+}
+
+func (c *Cache) Delete(key string) error {
+	return c.backend.Delete(c.key(key))
 }
 
 // json
 
 func (c *Cache) GetJSON(key string, v any) error {
-	entry, err := c.core.Get(key)
+	entry, err := c.Get(key)
 	if err != nil {
 		return err
 	}
@@ -44,7 +116,7 @@ func (c *Cache) SetJSON(key string, v any) error {
 	if err != nil {
 		return err
 	}
-	return c.core.Set(key, entry)
+	return c.Set(key, entry)
 }
 
 // generic
@@ -53,9 +125,9 @@ type GenericCache[T any] struct {
 	cache *Cache
 }
 
-func NewGeneric[T any](eviction time.Duration) *GenericCache[T] {
+func NewGeneric[T any](namespace string, eviction time.Duration) *GenericCache[T] {
 	return &GenericCache[T]{
-		cache: New(eviction),
+		cache: New(namespace, eviction),
 	}
 }
 
@@ -68,3 +140,7 @@ func (c *GenericCache[T]) Get(key string) (T, error) {
 func (c *GenericCache[T]) Set(key string, v T) error {
 	return c.cache.SetJSON(key, v)
 }
+
+func (c *GenericCache[T]) Delete(key string) error {
+	return c.cache.Delete(key)
+}
@@ -10,7 +10,7 @@ type testy struct {
 }
 
 func TestCacheGeneric(t *testing.T) {
-	genc := NewGeneric[*testy](time.Minute * 1)
+	genc := NewGeneric[*testy]("test", time.Minute*1)
 	ty := &testy{Foo: "bar"}
 
 	err := genc.Set("foo", ty)
@@ -27,3 +27,21 @@ func TestCacheGeneric(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestCacheNamespace(t *testing.T) {
+	b := NewMemory(time.Minute)
+	users := NewWithBackend(b, "users", time.Minute)
+	teams := NewWithBackend(b, "teams", time.Minute)
+
+	users.Set("token", []byte("user"))
+	teams.Set("token", []byte("teams"))
+
+	v, err := users.Get("token")
+	if err != nil || string(v) != "user" {
+		t.Errorf("unexpected entry: %q %v", v, err)
+	}
+	teams.Delete("token")
+	if _, err := users.Get("token"); err != nil {
+		t.Errorf("entry of another namespace deleted: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/log"
+)
+
+// Disk stores every entry in its own file prefixed with the expiry time,
+// so the cache survives restarts and can be shared by processes on the same volume
+type Disk struct {
+	dir string
+}
+
+func NewDisk(dir string) (*Disk, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &Disk{dir}, nil
+}
+
+const diskSweepInterval = time.Hour
+
+func (d *Disk) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, ErrNotFound
+	}
+
+	if expired(data) {
+		os.Remove(d.path(key))
+		return nil, ErrNotFound
+	}
+
+	return data[8:], nil
+}
+
+func (d *Disk) Set(key string, entry []byte, ttl time.Duration) error {
+	data := make([]byte, 8, len(entry)+8)
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	data = append(data, entry...)
+
+	// write and rename so readers never see partial entries
+	tmp, err := os.CreateTemp(d.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+func (d *Disk) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (d *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+// Sweep removes the expired entries, which are otherwise only removed when read
+func (d *Disk) Sweep() error {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || len(f.Name()) != sha256.Size*2 {
+			continue
+		}
+		p := filepath.Join(d.dir, f.Name())
+		if diskExpired(p) {
+			os.Remove(p)
+		}
+	}
+	return nil
+}
+
+// SweepEvery sweeps the expired entries at every interval
+func (d *Disk) SweepEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if err := d.Sweep(); err != nil {
+			log.Error(err).Str("dir", d.dir).Msg("failed to sweep disk cache")
+		}
+	}
+}
+
+// diskExpired reads the expiry time of an entry file
+func diskExpired(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	data := make([]byte, 8)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return false
+	}
+	return expired(data)
+}
+
+func expired(data []byte) bool {
+	expires := int64(binary.BigEndian.Uint64(data[:8]))
+	return expires > 0 && time.Now().UnixNano() > expires
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/rs/xid"
+
+	"github.com/moonwalker/moonbase/internal/log"
+)
+
+const invalidateChannel = "invalidate"
+
+// Broadcaster distributes messages between the replicas sharing a backend
+type Broadcaster interface {
+	Publish(channel string, msg string) error
+	Subscribe(channel string, fn func(msg string))
+}
+
+var invalidation = struct {
+	sync.RWMutex
+	instance    string
+	handlers    map[string][]func(key string)
+	broadcaster Broadcaster
+}{
+	instance: xid.New().String(),
+	handlers: make(map[string][]func(key string)),
+}
+
+// SetBroadcaster makes invalidations reach every replica subscribed to the broadcaster
+func SetBroadcaster(b Broadcaster) {
+	invalidation.Lock()
+	invalidation.broadcaster = b
+	invalidation.Unlock()
+
+	b.Subscribe(invalidateChannel, func(msg string) {
+		parts := strings.SplitN(msg, " ", 3)
+		// own invalidations are already handled locally
+		if len(parts) != 3 || parts[0] == invalidation.instance {
+			return
+		}
+		dispatch(parts[1], parts[2])
+	})
+}
+
+// OnInvalidate registers a handler called whenever a key of the topic is invalidated on any replica
+func OnInvalidate(topic string, fn func(key string)) {
+	invalidation.Lock()
+	defer invalidation.Unlock()
+	invalidation.handlers[topic] = append(invalidation.handlers[topic], fn)
+}
+
+// Invalidate runs the local handlers of the topic right away and broadcasts the invalidation to other replicas
+func Invalidate(topic string, key string) {
+	dispatch(topic, key)
+
+	invalidation.RLock()
+	b := invalidation.broadcaster
+	invalidation.RUnlock()
+
+	if b != nil {
+		err := b.Publish(invalidateChannel, invalidation.instance+" "+topic+" "+key)
+		if err != nil {
+			log.Error(err).Str("topic", topic).Msg("failed to broadcast cache invalidation")
+		}
+	}
+}
+
+func dispatch(topic string, key string) {
+	invalidation.RLock()
+	defer invalidation.RUnlock()
+	for _, fn := range invalidation.handlers[topic] {
+		fn(key)
+	}
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+// Memory is an in-process backend, entries expire after the eviction of the instance
+type Memory struct {
+	core *bigcache.BigCache
+}
+
+func NewMemory(eviction time.Duration) *Memory {
+	core, _ := bigcache.New(context.Background(), bigcache.DefaultConfig(eviction))
+	return &Memory{core}
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	entry, err := m.core.Get(key)
+	if errors.Is(err, bigcache.ErrEntryNotFound) {
+		return nil, ErrNotFound
+	}
+	return entry, err
+}
+
+func (m *Memory) Set(key string, entry []byte, ttl time.Duration) error {
+	return m.core.Set(key, entry)
+}
+
+func (m *Memory) Delete(key string) error {
+	err := m.core.Delete(key)
+	if errors.Is(err, bigcache.ErrEntryNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,330 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/log"
+)
+
+const (
+	redisPrefix      = "moonbase:"
+	redisPoolSize    = 8
+	redisTimeout     = 5 * time.Second
+	redisReconnectIn = time.Second
+)
+
+// Redis is a backend speaking the redis protocol, it also broadcasts messages with pub/sub
+type Redis struct {
+	addr     string
+	username string
+	password string
+	db       int
+
+	pool chan *redisConn
+	done chan struct{}
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedis connects to the server of a redis://[user:password@]host:port[/db] url
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme: %s", u.Scheme)
+	}
+
+	r := &Redis{
+		addr: u.Host,
+		pool: make(chan *redisConn, redisPoolSize),
+		done: make(chan struct{}),
+	}
+	if !strings.Contains(r.addr, ":") {
+		r.addr += ":6379"
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); len(db) > 0 {
+		r.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis db: %s", db)
+		}
+	}
+
+	// fail early when the server is not reachable
+	_, err = r.Do("PING")
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Redis) Get(key string) ([]byte, error) {
+	v, err := r.Do("GET", redisPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	return v.([]byte), nil
+}
+
+func (r *Redis) Set(key string, entry []byte, ttl time.Duration) error {
+	args := []string{"SET", redisPrefix + key, string(entry)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := r.Do(args...)
+	return err
+}
+
+func (r *Redis) Delete(key string) error {
+	_, err := r.Do("DEL", redisPrefix+key)
+	return err
+}
+
+func (r *Redis) Publish(channel string, msg string) error {
+	_, err := r.Do("PUBLISH", redisPrefix+channel, msg)
+	return err
+}
+
+// Subscribe calls fn with every message of the channel until the client is closed,
+// the subscription is restored when the connection drops
+func (r *Redis) Subscribe(channel string, fn func(msg string)) {
+	ready := make(chan struct{})
+	go func() {
+		first := true
+		for {
+			err := r.subscribe(redisPrefix+channel, fn, func() {
+				if first {
+					first = false
+					close(ready)
+				}
+			})
+			select {
+			case <-r.done:
+				return
+			case <-time.After(redisReconnectIn):
+			}
+			log.Error(err).Str("channel", channel).Msg("redis subscription lost, reconnecting")
+		}
+	}()
+
+	// wait for the first subscription so no message published right after is missed
+	select {
+	case <-ready:
+	case <-time.After(redisTimeout):
+	}
+}
+
+func (r *Redis) subscribe(channel string, fn func(msg string), subscribed func()) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-r.done:
+			c.Close()
+		case <-stop:
+		}
+	}()
+
+	err = c.write([]string{"SUBSCRIBE", channel})
+	if err != nil {
+		return err
+	}
+
+	for {
+		v, err := c.read()
+		if err != nil {
+			return err
+		}
+		msg, ok := v.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		switch kind, _ := msg[0].([]byte); string(kind) {
+		case "subscribe":
+			subscribed()
+		case "message":
+			if payload, ok := msg[2].([]byte); ok {
+				fn(string(payload))
+			}
+		}
+	}
+}
+
+// Close stops subscriptions and closes pooled connections
+func (r *Redis) Close() error {
+	close(r.done)
+	for {
+		select {
+		case c := <-r.pool:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Do sends a command and returns its reply, bulk strings are returned as []byte
+func (r *Redis) Do(args ...string) (interface{}, error) {
+	c, err := r.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetDeadline(time.Now().Add(redisTimeout))
+	err = c.write(args)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	v, err := c.read()
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		// the connection is in an unknown state
+		c.Close()
+		return nil, err
+	}
+
+	r.release(c)
+	return v, err
+}
+
+func (r *Redis) conn() (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+		return r.dial()
+	}
+}
+
+func (r *Redis) release(c *redisConn) {
+	select {
+	case r.pool <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (r *Redis) dial() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", r.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{nc, bufio.NewReader(nc)}
+
+	init := make([][]string, 0)
+	if len(r.password) > 0 {
+		if len(r.username) > 0 {
+			init = append(init, []string{"AUTH", r.username, r.password})
+		} else {
+			init = append(init, []string{"AUTH", r.password})
+		}
+	}
+	if r.db > 0 {
+		init = append(init, []string{"SELECT", strconv.Itoa(r.db)})
+	}
+
+	c.SetDeadline(time.Now().Add(redisTimeout))
+	for _, args := range init {
+		err = c.write(args)
+		if err == nil {
+			_, err = c.read()
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	c.SetDeadline(time.Time{})
+
+	return c, nil
+}
+
+func (c *redisConn) write(args []string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.Conn, b.String())
+	return err
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = c.read()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply: %q", line)
+}
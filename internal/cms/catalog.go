@@ -0,0 +1,126 @@
+package cms
+
+import (
+	"fmt"
+
+	"github.com/moonwalker/moonbase/internal/l10n"
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+// TranslationConflict is an imported translation of a source text which changed since it was exported
+type TranslationConflict struct {
+	Collection string `json:"collection"`
+	Entry      string `json:"entry"`
+	Field      string `json:"field"`
+	Exported   string `json:"exported"`
+	Current    string `json:"current"`
+	Target     string `json:"target"`
+}
+
+// translatable reports whether a field holds text to translate
+func translatable(f *content.Field) bool {
+	return f.Localized && !f.Reference && !f.List && f.Schema == nil
+}
+
+// TranslationUnits returns the units translating the localized text fields of an entry to the locale,
+// fields without text in the default locale are left out and translations to review are fuzzy,
+// with statuses given only fields in one of them are returned
+func TranslationUnits(collection string, mc *content.MergedContentData, cs content.Schema, ls Locales, locale string, statuses []string) []*l10n.Unit {
+	def := ls.Default()
+
+	units := make([]*l10n.Unit, 0)
+	for _, f := range cs.Fields {
+		if !translatable(f) {
+			continue
+		}
+		source, ok := mc.Fields[f.ID][def].(string)
+		if !ok || len(source) == 0 {
+			continue
+		}
+
+		status := content.TranslationUntranslated
+		if t := mc.Translations[f.ID][locale]; t != nil {
+			status = t.Status
+		}
+		if len(statuses) > 0 && !contains(statuses, status) {
+			continue
+		}
+
+		label := f.Label
+		if len(label) == 0 {
+			label = f.ID
+		}
+		u := &l10n.Unit{
+			Collection: collection,
+			Entry:      mc.ID,
+			Field:      f.ID,
+			Source:     source,
+			Notes:      []string{"field: " + label, "type: " + f.Type},
+		}
+		if status != content.TranslationUntranslated {
+			u.Target, _ = mc.Fields[f.ID][locale].(string)
+		}
+		if status == content.TranslationReview {
+			u.Fuzzy = true
+			u.Notes = append(u.Notes, "review: the source changed since it was translated")
+		}
+		units = append(units, u)
+	}
+	return units
+}
+
+// ApplyTranslations sets the targets of the units of an entry in the locale and marks them translated,
+// fuzzy and empty targets are left out and units of which the source is no longer the value of the
+// default locale are returned as conflicts, units of unknown or not translatable fields are rejected
+func ApplyTranslations(mc *content.MergedContentData, cs content.Schema, ls Locales, locale string, units []*l10n.Unit) (int, []*TranslationConflict, error) {
+	fields := make(map[string]*content.Field)
+	for _, f := range cs.Fields {
+		fields[f.ID] = f
+	}
+	if mc.Fields == nil {
+		mc.Fields = make(map[string]map[string]interface{})
+	}
+	if mc.Translations == nil {
+		mc.Translations = make(map[string]map[string]*content.Translation)
+	}
+
+	def := ls.Default()
+	applied := 0
+	conflicts := make([]*TranslationConflict, 0)
+	for _, u := range units {
+		f := fields[u.Field]
+		if f == nil {
+			return 0, nil, fmt.Errorf("unknown field: %s", u.Key())
+		}
+		if !translatable(f) {
+			return 0, nil, fmt.Errorf("field not translatable: %s", u.Key())
+		}
+		if u.Fuzzy || len(u.Target) == 0 {
+			continue
+		}
+
+		current, _ := mc.Fields[f.ID][def].(string)
+		if current != u.Source {
+			conflicts = append(conflicts, &TranslationConflict{
+				Collection: u.Collection,
+				Entry:      u.Entry,
+				Field:      u.Field,
+				Exported:   u.Source,
+				Current:    current,
+				Target:     u.Target,
+			})
+			continue
+		}
+
+		if mc.Fields[f.ID] == nil {
+			mc.Fields[f.ID] = make(map[string]interface{})
+		}
+		if mc.Translations[f.ID] == nil {
+			mc.Translations[f.ID] = make(map[string]*content.Translation)
+		}
+		mc.Fields[f.ID][locale] = u.Target
+		mc.Translations[f.ID][locale] = &content.Translation{Status: content.TranslationTranslated}
+		applied++
+	}
+	return applied, conflicts, nil
+}
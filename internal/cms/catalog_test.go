@@ -0,0 +1,61 @@
+package cms
+
+import (
+	"testing"
+
+	"github.com/moonwalker/moonbase/internal/l10n"
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+func TestTranslationUnits(t *testing.T) {
+	ls, _ := ParseLocales([]byte(`["en", "de"]`))
+	cs := content.Schema{Fields: content.Fields{
+		{ID: "title", Label: "Title", Type: "string", Localized: true},
+		{ID: "body", Type: "markdown", Localized: true},
+		{ID: "tags", Type: "string", Localized: true, List: true},
+		{ID: "slug", Type: "string"},
+	}}
+	mc := &content.MergedContentData{ID: "a", Fields: map[string]map[string]interface{}{
+		"title": {"en": "Hello", "de": "Hallo"},
+		"body":  {"en": "Text", "de": nil},
+		"tags":  {"en": []interface{}{"news"}},
+		"slug":  {"en": "hello"},
+	}, Translations: map[string]map[string]*content.Translation{
+		"title": {"de": {Status: content.TranslationReview}},
+		"body":  {"de": {Status: content.TranslationUntranslated}},
+	}}
+
+	units := TranslationUnits("posts", mc, cs, ls, "de", nil)
+	if len(units) != 2 {
+		t.Fatalf("expected the localized text fields, got %d units", len(units))
+	}
+	if u := units[0]; u.Key() != "posts/a/title" || u.Source != "Hello" || u.Target != "Hallo" || !u.Fuzzy {
+		t.Errorf("unexpected unit %+v", u)
+	}
+	if u := units[1]; u.Target != "" || u.Fuzzy || u.Notes[0] != "field: body" {
+		t.Errorf("unexpected unit %+v", u)
+	}
+	if units := TranslationUnits("posts", mc, cs, ls, "de", []string{content.TranslationUntranslated}); len(units) != 1 || units[0].Field != "body" {
+		t.Errorf("units not filtered by status: %+v", units)
+	}
+
+	// the source of the title changed after the export
+	mc.Fields["title"]["en"] = "Hello world"
+	applied, conflicts, err := ApplyTranslations(mc, cs, ls, "de", []*l10n.Unit{
+		{Collection: "posts", Entry: "a", Field: "title", Source: "Hello", Target: "Hallo!"},
+		{Collection: "posts", Entry: "a", Field: "body", Source: "Text", Target: "Inhalt"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 || len(conflicts) != 1 || conflicts[0].Current != "Hello world" {
+		t.Errorf("unexpected import: %d applied, conflicts %+v", applied, conflicts)
+	}
+	if mc.Fields["body"]["de"] != "Inhalt" || mc.Translations["body"]["de"].Status != content.TranslationTranslated || mc.Fields["title"]["de"] != "Hallo" {
+		t.Errorf("unexpected content %v %v", mc.Fields, mc.Translations)
+	}
+
+	if _, _, err := ApplyTranslations(mc, cs, ls, "de", []*l10n.Unit{{Collection: "posts", Entry: "a", Field: "slug", Source: "hello", Target: "hallo"}}); err == nil {
+		t.Error("expected an error for a field which is not localized")
+	}
+}
@@ -0,0 +1,123 @@
+package cms
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+// ContentPath is a repository path resolved to the collection and entry it belongs to,
+// collection schemas have no entry
+type ContentPath struct {
+	Collection string
+	Entry      string
+	Locale     string
+}
+
+// ParseContentPath resolves a repository path relative to the work dir,
+// reserved folders and files outside of collections are not content
+func ParseContentPath(workdir, path string) (*ContentPath, bool) {
+	rel, err := filepath.Rel(filepath.Join("/", workdir), filepath.Join("/", path))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, false
+	}
+
+	parts := strings.Split(rel, "/")
+	if IsReservedFolder(parts[0]) {
+		return nil, false
+	}
+
+	switch len(parts) {
+	case 2:
+		if parts[1] == content.JsonSchemaName {
+			return &ContentPath{Collection: parts[0]}, true
+		}
+	case 3:
+		locale := strings.TrimSuffix(parts[2], filepath.Ext(parts[2]))
+		return &ContentPath{Collection: parts[0], Entry: parts[1], Locale: locale}, true
+	}
+
+	return nil, false
+}
+
+// Change sums up what happened to a collection schema or an entry over a series of commits
+type Change struct {
+	Collection string
+	Entry      string
+	Locales    []string
+	Commit     string
+	Added      bool
+	Removed    bool
+	Modified   bool
+}
+
+// ChangeSet collects the content changes of pushed commits in the order they first appear
+type ChangeSet struct {
+	workdir string
+	keys    []string
+	changes map[string]*Change
+}
+
+func NewChangeSet(workdir string) *ChangeSet {
+	return &ChangeSet{workdir: workdir, changes: make(map[string]*Change)}
+}
+
+// Add records the paths touched by a commit
+func (cs *ChangeSet) Add(commit string, added, removed, modified []string) {
+	for _, p := range added {
+		if c := cs.change(commit, p); c != nil {
+			c.Added = true
+		}
+	}
+	for _, p := range removed {
+		if c := cs.change(commit, p); c != nil {
+			c.Removed = true
+		}
+	}
+	for _, p := range modified {
+		if c := cs.change(commit, p); c != nil {
+			c.Modified = true
+		}
+	}
+}
+
+// change returns the change the path belongs to, nil if the path is not content
+func (cs *ChangeSet) change(commit, path string) *Change {
+	cp, ok := ParseContentPath(cs.workdir, path)
+	if !ok {
+		return nil
+	}
+
+	key := cp.Collection + "/" + cp.Entry
+	c, ok := cs.changes[key]
+	if !ok {
+		c = &Change{Collection: cp.Collection, Entry: cp.Entry}
+		cs.changes[key] = c
+		cs.keys = append(cs.keys, key)
+	}
+	c.Commit = commit
+
+	if len(cp.Locale) > 0 && !contains(c.Locales, cp.Locale) {
+		c.Locales = append(c.Locales, cp.Locale)
+	}
+
+	return c
+}
+
+func (cs *ChangeSet) Changes() []*Change {
+	res := make([]*Change, 0)
+	for _, k := range cs.keys {
+		res = append(res, cs.changes[k])
+	}
+	return res
+}
+
+func contains(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package cms
+
+import (
+	"testing"
+)
+
+func TestParseContentPath(t *testing.T) {
+	cp, ok := ParseContentPath("content", "content/posts/hello/en.json")
+	if !ok || cp.Collection != "posts" || cp.Entry != "hello" || cp.Locale != "en" {
+		t.Fail()
+	}
+
+	cp, ok = ParseContentPath("content", "content/posts/_schema.json")
+	if !ok || cp.Collection != "posts" || len(cp.Entry) > 0 {
+		t.Fail()
+	}
+
+	for _, p := range []string{"README.md", "content/_trash/abc/_trash.json", "other/posts/hello/en.json", "content/posts/readme.md"} {
+		if _, ok := ParseContentPath("content", p); ok {
+			t.Errorf("unexpected content path: %s", p)
+		}
+	}
+}
+
+func TestChangeSet(t *testing.T) {
+	cs := NewChangeSet("")
+	cs.Add("c1", []string{"posts/hello/en.json", "posts/hello/de.json"}, nil, []string{"posts/_schema.json"})
+	cs.Add("c2", nil, []string{"pages/about/en.json"}, []string{"README.md"})
+
+	changes := cs.Changes()
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %d", len(changes))
+	}
+
+	hello := changes[0]
+	if hello.Entry != "hello" || !hello.Added || len(hello.Locales) != 2 || hello.Commit != "c1" {
+		t.Fail()
+	}
+	if changes[1].Entry != "" || !changes[1].Modified {
+		t.Fail()
+	}
+	if changes[2].Collection != "pages" || !changes[2].Removed || changes[2].Commit != "c2" {
+		t.Fail()
+	}
+}
@@ -2,6 +2,10 @@ package cms
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,10 +14,18 @@ const (
 	ConfigPath     = "moonbase.yaml"
 	ImagesFolder   = "_images"
 	SettingsFolder = "_settings"
+	TrashFolder    = "_trash"
+
+	defaultTrashRetentionDays = 30
 )
 
 type Config struct {
-	WorkDir string `json:"workdir" yaml:"workdir"`
+	WorkDir string      `json:"workdir" yaml:"workdir"`
+	Trash   TrashConfig `json:"trash" yaml:"trash"`
+}
+
+type TrashConfig struct {
+	RetentionDays int `json:"retentionDays" yaml:"retentionDays"`
 }
 
 func ParseConfig(data []byte) *Config {
@@ -26,3 +38,33 @@ func ParseConfig(data []byte) *Config {
 
 	return cfg
 }
+
+// TrashRetention returns how long trashed items are kept before they can be purged
+func (c *Config) TrashRetention() time.Duration {
+	days := c.Trash.RetentionDays
+	if days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// IsReservedFolder reports whether the folder name is used by moonbase itself and is not a collection
+func IsReservedFolder(name string) bool {
+	switch name {
+	case ImagesFolder, SettingsFolder, TrashFolder:
+		return true
+	}
+	return false
+}
+
+// ValidName checks that a collection, entry or setting name is a single path segment which is
+// not a reserved folder, names sent in request bodies are joined into repository paths
+func ValidName(name string) error {
+	if len(name) == 0 {
+		return errors.New("missing name")
+	}
+	if strings.ContainsAny(name, "/\\") || strings.Contains(name, "..") || IsReservedFolder(name) {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+	return nil
+}
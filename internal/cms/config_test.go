@@ -26,3 +26,16 @@ func testParse(t *testing.T, path string, dir string) {
 		t.Error(errors.New("working dir mismatch"))
 	}
 }
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"posts", "hello-world", "a.b", "_new"} {
+		if err := ValidName(name); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+	for _, name := range []string{"", "..", "../..", "a/b", "a\\b", "x..", TrashFolder, SettingsFolder, ImagesFolder} {
+		if ValidName(name) == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
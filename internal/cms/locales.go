@@ -0,0 +1,279 @@
+package cms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+	gh "github.com/moonwalker/moonbase/pkg/github"
+)
+
+// LocalesConfig is the name of the settings file holding the locales of a repository
+const LocalesConfig = "locales.json"
+
+// Locale is a locale of the content, values missing in it are taken from the locales
+// of its fallback chain in order and finally from the default locale
+type Locale struct {
+	Code     string   `json:"code"`
+	Name     string   `json:"name,omitempty"`
+	Enabled  bool     `json:"enabled"`
+	Default  bool     `json:"default,omitempty"`
+	Fallback []string `json:"fallback,omitempty"`
+}
+
+type Locales []*Locale
+
+// ParseLocales reads the locales config, a plain list of codes is read as enabled locales
+// with the default one being "en" when listed, otherwise the first
+func ParseLocales(data []byte) (Locales, error) {
+	codes := make([]string, 0)
+	if err := json.Unmarshal(data, &codes); err == nil {
+		ls := make(Locales, 0, len(codes))
+		for _, c := range codes {
+			ls = append(ls, &Locale{Code: c, Enabled: true, Default: c == content.DefaultLocale})
+		}
+		if len(ls) > 0 && ls.Get(content.DefaultLocale) == nil {
+			ls[0].Default = true
+		}
+		return ls, nil
+	}
+
+	ls := make(Locales, 0)
+	err := json.Unmarshal(data, &ls)
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// Validate checks that codes are unique, there is a single enabled default locale
+// and fallback chains only point to other known locales without cycles
+func (ls Locales) Validate() error {
+	defaults := 0
+	seen := make(map[string]bool)
+	for _, l := range ls {
+		if len(l.Code) == 0 || strings.ContainsAny(l.Code, "/\\. ") {
+			return fmt.Errorf("invalid locale code: %q", l.Code)
+		}
+		if seen[l.Code] {
+			return fmt.Errorf("duplicate locale: %s", l.Code)
+		}
+		seen[l.Code] = true
+		if l.Default {
+			defaults++
+			if !l.Enabled {
+				return fmt.Errorf("default locale disabled: %s", l.Code)
+			}
+		}
+	}
+	if defaults != 1 {
+		return errors.New("exactly one default locale required")
+	}
+
+	for _, l := range ls {
+		for _, f := range l.Fallback {
+			if !seen[f] || f == l.Code {
+				return fmt.Errorf("invalid fallback of %s: %s", l.Code, f)
+			}
+		}
+		if err := ls.checkCycle(l.Code, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ls Locales) checkCycle(code string, path map[string]bool) error {
+	if path[code] {
+		return fmt.Errorf("fallback cycle at %s", code)
+	}
+	l := ls.Get(code)
+	if l == nil {
+		return nil
+	}
+
+	path[code] = true
+	for _, f := range l.Fallback {
+		if err := ls.checkCycle(f, path); err != nil {
+			return err
+		}
+	}
+	delete(path, code)
+	return nil
+}
+
+// Get returns the locale with the code, nil if there is none
+func (ls Locales) Get(code string) *Locale {
+	for _, l := range ls {
+		if l.Code == code {
+			return l
+		}
+	}
+	return nil
+}
+
+// Default returns the code of the default locale
+func (ls Locales) Default() string {
+	for _, l := range ls {
+		if l.Default {
+			return l.Code
+		}
+	}
+	return content.DefaultLocale
+}
+
+// Codes returns the codes of the enabled locales, the default locale first
+func (ls Locales) Codes() []string {
+	def := ls.Default()
+	codes := []string{def}
+	for _, l := range ls {
+		if l.Enabled && l.Code != def {
+			codes = append(codes, l.Code)
+		}
+	}
+	return codes
+}
+
+// Chain returns the locale followed by the locales its values fall back to, depth first
+// along the fallback lists and ending with the default locale
+func (ls Locales) Chain(code string) []string {
+	chain := make([]string, 0)
+	seen := make(map[string]bool)
+	var walk func(code string)
+	walk = func(code string) {
+		if seen[code] {
+			return
+		}
+		seen[code] = true
+		chain = append(chain, code)
+		if l := ls.Get(code); l != nil {
+			for _, f := range l.Fallback {
+				walk(f)
+			}
+		}
+	}
+	walk(code)
+	walk(ls.Default())
+	return chain
+}
+
+// Resolve returns the value of the locale, or the first value set along its fallback chain
+func (ls Locales) Resolve(values map[string]interface{}, code string) interface{} {
+	for _, l := range ls.Chain(code) {
+		if v := values[l]; !unset(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// inherited returns the value a locale gets from its fallback chain, the stored
+// values of locale files are already resolved, so the first stored one is it
+func (ls Locales) inherited(stored map[string]map[string]interface{}, field string, code string) (interface{}, bool) {
+	for _, l := range ls.Chain(code)[1:] {
+		if fields, ok := stored[l]; ok {
+			return fields[field], true
+		}
+	}
+	return nil, false
+}
+
+// unset reports whether a locale value is left to the fallback chain
+func unset(v interface{}) bool {
+	return v == nil || v == ""
+}
+
+// AddLocaleFiles returns the blob entries giving every entry a file of the locale, copied
+// from the file the locale falls back to with every translation status reset, as nothing
+// is translated to the new locale yet. Entries which have a file of it already are left alone,
+// the files have to carry their content.
+func AddLocaleFiles(workdir string, files []*github.RepositoryContent, ls Locales, code string) ([]gh.BlobEntry, error) {
+	entries := entryFiles(workdir, files)
+
+	res := make([]gh.BlobEntry, 0)
+	for _, dir := range sortedKeys(entries) {
+		locales := entries[dir]
+		if locales[code] != nil {
+			continue
+		}
+		for _, l := range ls.Chain(code)[1:] {
+			if f := locales[l]; f != nil {
+				data, err := untranslatedCopy(f)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, gh.BlobEntry{Path: filepath.Join(dir, code+".json"), Content: &data})
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// untranslatedCopy returns the content of a locale file with the status of every translation untranslated
+func untranslatedCopy(f *github.RepositoryContent) (string, error) {
+	cnt, err := f.GetContent()
+	if err != nil {
+		return "", fmt.Errorf("error getting repo content: %s", err)
+	}
+
+	cd := &content.ContentData{}
+	err = json.Unmarshal([]byte(cnt), cd)
+	if err != nil {
+		return "", fmt.Errorf("error parsing localised content data %s: %s", f.GetPath(), err)
+	}
+	for k := range cd.Translations {
+		cd.Translations[k] = &content.Translation{Status: content.TranslationUntranslated}
+	}
+
+	data, err := json.Marshal(cd)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// RemoveLocaleFiles returns the blob entries deleting the file of the locale from every entry
+func RemoveLocaleFiles(workdir string, files []*github.RepositoryContent, code string) []gh.BlobEntry {
+	entries := entryFiles(workdir, files)
+
+	res := make([]gh.BlobEntry, 0)
+	for _, dir := range sortedKeys(entries) {
+		if f := entries[dir][code]; f != nil {
+			res = append(res, gh.BlobEntry{Path: f.GetPath(), Content: nil})
+		}
+	}
+	return res
+}
+
+// entryFiles groups the locale files of entries by the folder of the entry
+func entryFiles(workdir string, files []*github.RepositoryContent) map[string]map[string]*github.RepositoryContent {
+	entries := make(map[string]map[string]*github.RepositoryContent)
+	for _, f := range files {
+		cp, ok := ParseContentPath(workdir, f.GetPath())
+		if !ok || len(cp.Entry) == 0 || filepath.Ext(f.GetPath()) != ".json" {
+			continue
+		}
+		dir := filepath.Dir(f.GetPath())
+		if entries[dir] == nil {
+			entries[dir] = make(map[string]*github.RepositoryContent)
+		}
+		entries[dir][cp.Locale] = f
+	}
+	return entries
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,134 @@
+package cms
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+const testLocales = `[
+	{"code": "en", "name": "English", "enabled": true},
+	{"code": "de", "name": "Deutsch", "enabled": true, "default": true},
+	{"code": "de-AT", "name": "Österreichisch", "enabled": true, "fallback": ["de"]},
+	{"code": "fr", "enabled": false}
+]`
+
+func TestParseLocales(t *testing.T) {
+	ls, err := ParseLocales([]byte(`["de", "en", "fr"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if ls.Default() != "en" || !reflect.DeepEqual(ls.Codes(), []string{"en", "de", "fr"}) {
+		t.Errorf("unexpected locales from a list: %s %v", ls.Default(), ls.Codes())
+	}
+
+	ls, err = ParseLocales([]byte(testLocales))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if ls.Default() != "de" || !reflect.DeepEqual(ls.Codes(), []string{"de", "en", "de-AT"}) {
+		t.Errorf("unexpected locales: %s %v", ls.Default(), ls.Codes())
+	}
+	if chain := ls.Chain("de-AT"); !reflect.DeepEqual(chain, []string{"de-AT", "de"}) {
+		t.Errorf("unexpected chain %v", chain)
+	}
+	if chain := ls.Chain("en"); !reflect.DeepEqual(chain, []string{"en", "de"}) {
+		t.Errorf("unexpected chain %v", chain)
+	}
+}
+
+func TestValidateLocales(t *testing.T) {
+	invalid := map[string]string{
+		"no default":       `[{"code": "en", "enabled": true}]`,
+		"two defaults":     `[{"code": "en", "enabled": true, "default": true}, {"code": "de", "enabled": true, "default": true}]`,
+		"disabled default": `[{"code": "en", "default": true}]`,
+		"duplicate":        `[{"code": "en", "enabled": true, "default": true}, {"code": "en"}]`,
+		"bad code":         `[{"code": "../en", "enabled": true, "default": true}]`,
+		"unknown fallback": `[{"code": "en", "enabled": true, "default": true}, {"code": "de", "fallback": ["at"]}]`,
+		"cycle":            `[{"code": "en", "enabled": true, "default": true}, {"code": "de", "fallback": ["at"]}, {"code": "at", "fallback": ["de"]}]`,
+	}
+	for name, data := range invalid {
+		ls, err := ParseLocales([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ls.Validate() == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLocaleFallback(t *testing.T) {
+	ls, _ := ParseLocales([]byte(testLocales))
+	cs := content.Schema{Fields: content.Fields{{ID: "title", Localized: true}, {ID: "count"}}}
+
+	mc := content.MergedContentData{ID: "post", Fields: map[string]map[string]interface{}{
+		"title": {"de": "Hallo", "en": "Hello", "de-AT": nil},
+		"count": {"de": 1.0},
+	}}
+	items, err := SeparateLocalisedContent(mc, ls, "content", "posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	rc := make([]*github.RepositoryContent, 0)
+	for _, it := range items {
+		files[it.Path] = *it.Content
+		rc = append(rc, &github.RepositoryContent{Name: github.String(it.Path), Path: github.String(it.Path), Content: it.Content})
+	}
+	if len(files) != 3 || files["content/posts/post/fr.json"] != "" {
+		t.Fatalf("expected files of the enabled locales, got %v", files)
+	}
+	if at := files["content/posts/post/de-AT.json"]; at != `{"id":"post","fields":{"count":1,"title":"Hallo"}}` {
+		t.Errorf("fallback not resolved: %s", at)
+	}
+
+	merged, err := MergeLocalisedContent(rc, cs, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(merged.Fields, mc.Fields) {
+		t.Errorf("inherited values not left empty: %v", merged.Fields)
+	}
+}
+
+func TestLocaleFiles(t *testing.T) {
+	ls, _ := ParseLocales([]byte(testLocales))
+	files := []*github.RepositoryContent{
+		{Path: github.String("content/posts/_schema.json"), SHA: github.String("s")},
+		{Path: github.String("content/posts/a/de.json"), SHA: github.String("a-de"), Content: github.String(`{"id":"a","fields":{"title":"Hallo"},"translations":{"title":{"status":"translated","source":"x"}}}`)},
+		{Path: github.String("content/posts/a/en.json"), SHA: github.String("a-en")},
+		{Path: github.String("content/posts/b/de.json"), SHA: github.String("b-de")},
+		{Path: github.String("content/posts/b/de-AT.json"), SHA: github.String("b-at")},
+		{Path: github.String("content/_trash/x/files/de.json"), SHA: github.String("x")},
+	}
+
+	added, err := AddLocaleFiles("content", files, ls, "de-AT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].Path != "content/posts/a/de-AT.json" {
+		t.Fatalf("unexpected added files %+v", added)
+	}
+	cd := &content.ContentData{}
+	json.Unmarshal([]byte(*added[0].Content), cd)
+	if cd.Fields["title"] != "Hallo" || cd.Translations["title"].Status != content.TranslationUntranslated || len(cd.Translations["title"].Source) > 0 {
+		t.Errorf("unexpected added file %s", *added[0].Content)
+	}
+
+	removed := RemoveLocaleFiles("content", files, "de")
+	if len(removed) != 2 || removed[0].Path != "content/posts/a/de.json" || removed[0].Content != nil || removed[1].Path != "content/posts/b/de.json" {
+		t.Errorf("unexpected removed files %+v", removed)
+	}
+}
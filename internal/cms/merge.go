@@ -1,9 +1,13 @@
 package cms
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -36,108 +40,141 @@ func GetNameLocaleFromPath(path string) (string, string) {
 	return name, locale
 }
 
-func MergeLocalisedContent(rc []*github.RepositoryContent, cs content.Schema) (*content.MergedContentData, error) {
+// EntryTag returns a version tag of an entry derived from the blob SHAs of its files,
+// it changes whenever any locale of the entry changes
+func EntryTag(rc []*github.RepositoryContent) string {
+	files := make([]string, 0)
+	for _, c := range rc {
+		files = append(files, c.GetPath()+":"+c.GetSHA())
+	}
+	sort.Strings(files)
+
+	h := sha1.New()
+	for _, f := range files {
+		h.Write([]byte(f))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MergeLocalisedContent merges the locale files of an entry together with the translation statuses
+// of its localized fields, untranslated values equal to the value a locale inherits from its
+// fallback chain are left empty
+func MergeLocalisedContent(rc []*github.RepositoryContent, cs content.Schema, ls Locales) (*content.MergedContentData, error) {
 	result := &content.MergedContentData{}
 	result.Fields = make(map[string]map[string]interface{})
+	result.Translations = make(map[string]map[string]*content.Translation)
 
 	localizedFields := getLocalizedFields(cs)
+	def := ls.Default()
 
-	// Get default locale content
+	stored := make(map[string]map[string]interface{})
+	translations := make(map[string]map[string]*content.Translation)
 	for _, c := range rc {
-		if *c.Name != content.JsonSchemaName {
-			_, l := GetNameLocaleFromPath(*c.Path)
+		if *c.Name == content.JsonSchemaName {
+			continue
+		}
+		_, l := GetNameLocaleFromPath(*c.Path)
 
-			cnt, err := c.GetContent()
-			if err != nil {
-				return nil, fmt.Errorf("error getting repo content: %s", err)
-			}
+		cnt, err := c.GetContent()
+		if err != nil {
+			return nil, fmt.Errorf("error getting repo content: %s", err)
+		}
 
-			dcd := &content.ContentData{}
-			err = json.Unmarshal([]byte(cnt), dcd)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing localised content data: %s", err)
-			}
+		dcd := &content.ContentData{}
+		err = json.Unmarshal([]byte(cnt), dcd)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing localised content data: %s", err)
+		}
+		stored[l] = dcd.Fields
+		translations[l] = dcd.Translations
+
+		if l == def {
+			result.ID = dcd.ID
+			result.Version = dcd.Version
+			result.Status = dcd.Status
 
-			if l == content.DefaultLocale {
-				result.ID = dcd.ID
-				result.Version = dcd.Version
-				result.Status = dcd.Status
-
-				if dcd.CreatedAt != "" {
-					ct, _ := time.Parse(time.RFC3339Nano, dcd.CreatedAt)
-					result.CreatedAt = &ct
-				}
-				result.CreatedBy = dcd.CreatedBy
-				if dcd.UpdatedAt != "" {
-					ut, _ := time.Parse(time.RFC3339Nano, dcd.UpdatedAt)
-					result.UpdatedAt = &ut
-				}
-				result.UpdatedBy = dcd.UpdatedBy
-				if dcd.PublishedAt != "" {
-					pt, _ := time.Parse(time.RFC3339Nano, dcd.PublishedAt)
-					result.PublishedAt = &pt
-				}
-				result.PublishedBy = dcd.PublishedBy
+			if dcd.CreatedAt != "" {
+				ct, _ := time.Parse(time.RFC3339Nano, dcd.CreatedAt)
+				result.CreatedAt = &ct
+			}
+			result.CreatedBy = dcd.CreatedBy
+			if dcd.UpdatedAt != "" {
+				ut, _ := time.Parse(time.RFC3339Nano, dcd.UpdatedAt)
+				result.UpdatedAt = &ut
 			}
+			result.UpdatedBy = dcd.UpdatedBy
+			if dcd.PublishedAt != "" {
+				pt, _ := time.Parse(time.RFC3339Nano, dcd.PublishedAt)
+				result.PublishedAt = &pt
+			}
+			result.PublishedBy = dcd.PublishedBy
+		}
+	}
+
+	for _, csf := range cs.Fields {
+		k := csf.ID
+		result.Fields[k] = make(map[string]interface{})
+		if fields, ok := stored[def]; ok {
+			result.Fields[k][def] = fields[k]
+		}
+		if !localizedFields[k] {
+			continue
+		}
 
-			//for k, v := range dcd.Fields {
-			for _, csf := range cs.Fields {
-				k := csf.ID
-				v := dcd.Fields[k]
-
-				if result.Fields[k] == nil {
-					result.Fields[k] = make(map[string]interface{})
-				}
-
-				if l == content.DefaultLocale {
-					result.Fields[k][l] = v
-					if localizedFields[k] && len(result.Fields[k]) > 0 {
-						// clear existing locale values if they are the same as the default if any
-						for el, ev := range result.Fields[k] {
-							if el != content.DefaultLocale && ev == v {
-								result.Fields[k][el] = nil
-							}
-						}
-					}
-				} else if localizedFields[k] {
-					// check if field is localized and locale value is not the same as the default
-					if v != result.Fields[k][content.DefaultLocale] {
-						result.Fields[k][l] = v
-					} else {
-						result.Fields[k][l] = nil
-					}
-				}
+		result.Translations[k] = make(map[string]*content.Translation)
+		for l, fields := range stored {
+			if l == def {
+				continue
+			}
+			v := fields[k]
+			inherited, ok := ls.inherited(stored, k, l)
+			t := translationState(translations[l][k], v, inherited, stored[def][k])
+			// locales only hold what differs from the locales they fall back to,
+			// unless the same value is a translation
+			if ok && t.Status == content.TranslationUntranslated && reflect.DeepEqual(v, inherited) {
+				v = nil
 			}
+			result.Fields[k][l] = v
+			result.Translations[k][l] = t
 		}
 	}
 	return result, nil
 }
 
-func SeparateLocalisedContent(mcd content.MergedContentData, locales []string, workDir, collection string) ([]gh.BlobEntry, error) {
+// SeparateLocalisedContent returns the locale files of the enabled locales, values
+// left empty in a locale are resolved along its fallback chain and the translation
+// statuses are kept with the files of the other locales than the default
+func SeparateLocalisedContent(mcd content.MergedContentData, ls Locales, workDir, collection string) ([]gh.BlobEntry, error) {
 	var res []gh.BlobEntry
 
-	for _, l := range locales {
+	def := ls.Default()
+	for _, l := range ls.Codes() {
 		fields := make(map[string]interface{})
+		var translations map[string]*content.Translation
 		for key, value := range mcd.Fields {
-			if fields[key] == nil {
-				fields[key] = make(map[string]interface{})
-			}
-			if (value[l] != nil && value[l] != "") || l == content.DefaultLocale {
+			if l == def {
 				fields[key] = value[l]
 			} else {
-				fields[key] = value[content.DefaultLocale]
+				fields[key] = ls.Resolve(value, l)
 			}
 		}
+		if l != def {
+			translations = storedTranslations(mcd, l)
+		}
 
 		s, err := json.Marshal(content.ContentData{
-			ID:          mcd.ID,
-			CreatedAt:   mcd.CreatedAt.Format(time.RFC3339Nano),
-			CreatedBy:   mcd.CreatedBy,
-			UpdatedAt:   mcd.UpdatedAt.Format(time.RFC3339Nano),
-			UpdatedBy:   mcd.UpdatedBy,
-			PublishedAt: mcd.PublishedAt.Format(time.RFC3339Nano),
-			PublishedBy: mcd.PublishedBy,
-			Fields:      fields,
+			ID:           mcd.ID,
+			CreatedAt:    formatTime(mcd.CreatedAt),
+			CreatedBy:    mcd.CreatedBy,
+			UpdatedAt:    formatTime(mcd.UpdatedAt),
+			UpdatedBy:    mcd.UpdatedBy,
+			PublishedAt:  formatTime(mcd.PublishedAt),
+			PublishedBy:  mcd.PublishedBy,
+			Version:      mcd.Version,
+			Status:       mcd.Status,
+			Fields:       fields,
+			Translations: translations,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("error marshalling content data:%s", err)
@@ -153,7 +190,14 @@ func SeparateLocalisedContent(mcd content.MergedContentData, locales []string, w
 	return res, nil
 }
 
-func GetEmptyLocalisedContent(cs content.Schema, locales []string) (*content.MergedContentData, error) {
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func GetEmptyLocalisedContent(cs content.Schema, ls Locales) (*content.MergedContentData, error) {
 	result := &content.MergedContentData{}
 	result.Fields = make(map[string]map[string]interface{})
 
@@ -165,10 +209,10 @@ func GetEmptyLocalisedContent(cs content.Schema, locales []string) (*content.Mer
 		if result.Fields[k] == nil {
 			result.Fields[k] = make(map[string]interface{})
 		}
-		result.Fields[k][content.DefaultLocale] = nil
+		result.Fields[k][ls.Default()] = nil
 
 		if localizedFields[k] {
-			for _, l := range locales {
+			for _, l := range ls.Codes() {
 				result.Fields[k][l] = nil
 			}
 		}
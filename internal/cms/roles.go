@@ -0,0 +1,241 @@
+package cms
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+// RolesConfig is the name of the settings file holding the roles of a repository
+const RolesConfig = "roles.json"
+
+const (
+	ActionRead     = "read"
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionPublish  = "publish"
+	ActionSchema   = "schema"
+	ActionSettings = "settings"
+)
+
+const (
+	RoleAdmin      = "admin"
+	RoleEditor     = "editor"
+	RoleAuthor     = "author"
+	RoleTranslator = "translator"
+	RoleViewer     = "viewer"
+)
+
+const (
+	anyValue = "*"
+	// defaultLocaleValue stands for the default locale of the repository in the locales of a permission
+	defaultLocaleValue = "$default"
+)
+
+// Roles maps GitHub logins and teams to roles, roles grant actions on collections and locales
+type Roles struct {
+	Roles   map[string]*Role `json:"roles,omitempty"`
+	Members []*Member        `json:"members"`
+	Default string           `json:"default,omitempty"`
+	// DefaultLocale is the default locale of the repository, "en" if not set
+	DefaultLocale string `json:"-"`
+}
+
+// Role is a set of permissions, a role of the config replaces the builtin role with the same name
+type Role struct {
+	Permissions []*Permission `json:"permissions"`
+}
+
+// Permission grants actions on collections and locales, "*" matches any value,
+// a value prefixed with "!" excludes it and "$default" is the default locale
+type Permission struct {
+	Collections []string `json:"collections"`
+	Locales     []string `json:"locales,omitempty"`
+	Actions     []string `json:"actions"`
+}
+
+// Member assigns a role to GitHub logins and to teams given as org/team-slug
+type Member struct {
+	Role  string   `json:"role"`
+	Users []string `json:"users,omitempty"`
+	Teams []string `json:"teams,omitempty"`
+}
+
+var builtinRoles = map[string]*Role{
+	RoleAdmin: {Permissions: []*Permission{
+		{Collections: []string{anyValue}, Actions: []string{anyValue}},
+	}},
+	RoleEditor: {Permissions: []*Permission{
+		{Collections: []string{anyValue}, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionPublish}},
+	}},
+	RoleAuthor: {Permissions: []*Permission{
+		{Collections: []string{anyValue}, Actions: []string{ActionRead, ActionCreate, ActionUpdate}},
+	}},
+	RoleTranslator: {Permissions: []*Permission{
+		{Collections: []string{anyValue}, Actions: []string{ActionRead}},
+		{Collections: []string{anyValue}, Locales: []string{anyValue, "!" + defaultLocaleValue}, Actions: []string{ActionUpdate}},
+	}},
+	RoleViewer: {Permissions: []*Permission{
+		{Collections: []string{anyValue}, Actions: []string{ActionRead}},
+	}},
+}
+
+func ParseRoles(data []byte) (*Roles, error) {
+	roles := &Roles{}
+	err := json.Unmarshal(data, roles)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Role returns the role by name, a builtin one if the config does not define it
+func (rs *Roles) Role(name string) *Role {
+	if r, ok := rs.Roles[name]; ok {
+		return r
+	}
+	return builtinRoles[name]
+}
+
+// MemberRoles returns the roles of a user, the default role when the user is not a member of any
+func (rs *Roles) MemberRoles(login string, teams []string) []string {
+	res := make([]string, 0)
+	for _, m := range rs.Members {
+		if containsFold(m.Users, login) || containsAnyFold(m.Teams, teams) {
+			res = append(res, m.Role)
+		}
+	}
+	if len(res) == 0 && len(rs.Default) > 0 {
+		res = append(res, rs.Default)
+	}
+	return res
+}
+
+// Allowed reports whether any of the roles grants the action, an empty collection or locale
+// stands for the repository as a whole and is granted by a permission on any of them
+func (rs *Roles) Allowed(roles []string, action, collection, locale string) bool {
+	for _, name := range roles {
+		role := rs.Role(name)
+		if role == nil {
+			continue
+		}
+		for _, p := range role.Permissions {
+			if p.allows(action, collection, locale, rs.defaultLocale()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rs *Roles) defaultLocale() string {
+	if len(rs.DefaultLocale) == 0 {
+		return content.DefaultLocale
+	}
+	return rs.DefaultLocale
+}
+
+func (p *Permission) allows(action, collection, locale, defaultLocale string) bool {
+	if !matchAny(p.Actions, action) {
+		return false
+	}
+	if len(collection) > 0 && !matchAny(p.Collections, collection) {
+		return false
+	}
+	if len(locale) > 0 && len(p.Locales) > 0 && !matchAny(localeValues(p.Locales, defaultLocale), locale) {
+		return false
+	}
+	return true
+}
+
+// matchAny matches the value against a list of values, wildcards and exclusions
+func matchAny(list []string, v string) bool {
+	match := false
+	for _, i := range list {
+		switch {
+		case strings.HasPrefix(i, "!"):
+			if strings.EqualFold(i[1:], v) {
+				return false
+			}
+		case i == anyValue || strings.EqualFold(i, v):
+			match = true
+		}
+	}
+	return match
+}
+
+// localeValues replaces the default locale placeholder of permission locales
+func localeValues(list []string, defaultLocale string) []string {
+	res := make([]string, 0, len(list))
+	for _, i := range list {
+		switch i {
+		case defaultLocaleValue:
+			i = defaultLocale
+		case "!" + defaultLocaleValue:
+			i = "!" + defaultLocale
+		}
+		res = append(res, i)
+	}
+	return res
+}
+
+func containsFold(list []string, v string) bool {
+	for _, i := range list {
+		if strings.EqualFold(i, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAnyFold(list []string, values []string) bool {
+	for _, v := range values {
+		if containsFold(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangedLocales returns the locales of which field values or requested translation statuses differ
+// between the current content and the new one, every locale of the new content when there is no current one
+func ChangedLocales(current *content.MergedContentData, mc content.MergedContentData) []string {
+	changed := make(map[string]bool)
+	for f, values := range mc.Fields {
+		for l, v := range values {
+			if current == nil || !reflect.DeepEqual(current.Fields[f][l], v) {
+				changed[l] = true
+			}
+		}
+	}
+	for f, values := range mc.Translations {
+		for l, t := range values {
+			if t == nil {
+				continue
+			}
+			if current == nil || current.Translations[f][l] == nil || current.Translations[f][l].Status != t.Status {
+				changed[l] = true
+			}
+		}
+	}
+	if current != nil {
+		for f, values := range current.Fields {
+			for l := range values {
+				if _, ok := mc.Fields[f][l]; !ok {
+					changed[l] = true
+				}
+			}
+		}
+	}
+
+	res := make([]string, 0, len(changed))
+	for l := range changed {
+		res = append(res, l)
+	}
+	sort.Strings(res)
+	return res
+}
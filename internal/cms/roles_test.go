@@ -0,0 +1,97 @@
+package cms
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+const testRoles = `{
+	"roles": {
+		"blogger": {"permissions": [
+			{"collections": ["posts"], "actions": ["read", "create", "update"]},
+			{"collections": ["*", "!posts"], "actions": ["read"]}
+		]}
+	},
+	"members": [
+		{"role": "admin", "users": ["Alice"]},
+		{"role": "translator", "teams": ["acme/translators"]},
+		{"role": "blogger", "users": ["bob"]}
+	],
+	"default": "viewer"
+}`
+
+func TestRoles(t *testing.T) {
+	rs, err := ParseRoles([]byte(testRoles))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := rs.MemberRoles("alice", nil)
+	if !rs.Allowed(admin, ActionSettings, "", "") || !rs.Allowed(admin, ActionSchema, "posts", "") {
+		t.Error("admin should be allowed everything")
+	}
+
+	translator := rs.MemberRoles("carol", []string{"ACME/translators"})
+	if !reflect.DeepEqual(translator, []string{RoleTranslator}) {
+		t.Fatalf("unexpected roles: %v", translator)
+	}
+	if !rs.Allowed(translator, ActionUpdate, "posts", "de") || rs.Allowed(translator, ActionUpdate, "posts", "en") {
+		t.Error("translator should update other than the default locale only")
+	}
+	rs.DefaultLocale = "de"
+	if !rs.Allowed(translator, ActionUpdate, "posts", "en") || rs.Allowed(translator, ActionUpdate, "posts", "de") {
+		t.Error("translator should not update the configured default locale")
+	}
+	rs.DefaultLocale = ""
+	if rs.Allowed(translator, ActionDelete, "posts", "") {
+		t.Error("translator should not delete")
+	}
+
+	blogger := rs.MemberRoles("bob", nil)
+	if !rs.Allowed(blogger, ActionCreate, "posts", "") || rs.Allowed(blogger, ActionCreate, "pages", "") {
+		t.Error("blogger should create posts only")
+	}
+	if !rs.Allowed(blogger, ActionRead, "pages", "") || rs.Allowed(blogger, ActionPublish, "posts", "") {
+		t.Error("unexpected blogger permissions")
+	}
+
+	viewer := rs.MemberRoles("dave", nil)
+	if !rs.Allowed(viewer, ActionRead, "posts", "") || rs.Allowed(viewer, ActionUpdate, "posts", "") {
+		t.Error("default role should only read")
+	}
+
+	rs.Default = ""
+	if len(rs.MemberRoles("dave", nil)) > 0 {
+		t.Error("unexpected roles without default")
+	}
+}
+
+func TestChangedLocales(t *testing.T) {
+	current := &content.MergedContentData{Fields: map[string]map[string]interface{}{
+		"title": {"en": "hello", "de": "hallo"},
+		"body":  {"fr": "corps"},
+	}}
+	mc := content.MergedContentData{Fields: map[string]map[string]interface{}{
+		"title": {"en": "hello", "de": "servus"},
+	}}
+
+	if locales := ChangedLocales(current, mc); !reflect.DeepEqual(locales, []string{"de", "fr"}) {
+		t.Errorf("unexpected changed locales: %v", locales)
+	}
+	if locales := ChangedLocales(nil, mc); !reflect.DeepEqual(locales, []string{"de", "en"}) {
+		t.Errorf("unexpected changed locales: %v", locales)
+	}
+
+	current.Translations = map[string]map[string]*content.Translation{"title": {"fr": {Status: content.TranslationReview}}}
+	mc.Fields["body"] = map[string]interface{}{"fr": "corps"}
+	mc.Translations = map[string]map[string]*content.Translation{"title": {"fr": {Status: content.TranslationTranslated}}}
+	if locales := ChangedLocales(current, mc); !reflect.DeepEqual(locales, []string{"de", "fr"}) {
+		t.Errorf("translation status not changing the locale: %v", locales)
+	}
+	mc.Translations["title"]["fr"].Status = content.TranslationReview
+	if locales := ChangedLocales(current, mc); !reflect.DeepEqual(locales, []string{"de"}) {
+		t.Errorf("unexpected changed locales: %v", locales)
+	}
+}
@@ -0,0 +1,195 @@
+package cms
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+// TranslationReport lists the entries of a collection with localized fields
+// which are not translated to a locale or need review
+type TranslationReport struct {
+	Collection   string                   `json:"collection"`
+	Locale       string                   `json:"locale"`
+	Entries      int                      `json:"entries"`
+	Fields       int                      `json:"fields"`
+	Translated   int                      `json:"translated"`
+	Untranslated int                      `json:"untranslated"`
+	Review       int                      `json:"review"`
+	Items        []*TranslationReportItem `json:"items"`
+}
+
+type TranslationReportItem struct {
+	Entry        string   `json:"entry"`
+	Untranslated []string `json:"untranslated,omitempty"`
+	Review       []string `json:"review,omitempty"`
+}
+
+// sourceHash identifies the default locale value a translation was made from
+func sourceHash(v interface{}) string {
+	b, _ := json.Marshal(v)
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:])
+}
+
+// translationState returns the status of a stored localized value, translations made from
+// another default value need review, files written before statuses were tracked
+// are translated where they differ from the inherited value
+func translationState(stored *content.Translation, v interface{}, inherited interface{}, source interface{}) *content.Translation {
+	if stored == nil {
+		if unset(v) || reflect.DeepEqual(v, inherited) {
+			return &content.Translation{Status: content.TranslationUntranslated}
+		}
+		return &content.Translation{Status: content.TranslationTranslated, Source: sourceHash(source)}
+	}
+
+	t := *stored
+	if t.Status == content.TranslationTranslated && t.Source != sourceHash(source) {
+		t.Status = content.TranslationReview
+	}
+	return &t
+}
+
+// storedTranslations returns the statuses of the localized fields as written to the file of a locale,
+// review is not stored as it follows from the source of a translation
+func storedTranslations(mcd content.MergedContentData, locale string) map[string]*content.Translation {
+	res := make(map[string]*content.Translation)
+	for k, values := range mcd.Translations {
+		t := values[locale]
+		if t == nil {
+			continue
+		}
+		st := *t
+		if st.Status == content.TranslationReview {
+			st.Status = content.TranslationTranslated
+		}
+		if st.Status != content.TranslationTranslated {
+			st.Source = ""
+		}
+		res[k] = &st
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// TrackTranslations sets the translation statuses of content about to be written: changed values
+// are translated from the current default value, requested statuses mark values translated or
+// untranslated explicitly and values left as they were keep their status, so review stays pending
+// until the value changes or is marked translated
+func TrackTranslations(current *content.MergedContentData, mc *content.MergedContentData, cs content.Schema, ls Locales) {
+	requested := mc.Translations
+	mc.Translations = make(map[string]map[string]*content.Translation)
+
+	def := ls.Default()
+	for _, f := range cs.Fields {
+		if !f.Localized {
+			continue
+		}
+		k := f.ID
+		source := sourceHash(mc.Fields[k][def])
+		mc.Translations[k] = make(map[string]*content.Translation)
+
+		for _, l := range ls.Codes() {
+			if l == def {
+				continue
+			}
+			v := mc.Fields[k][l]
+			req := requested[k][l]
+
+			var prev *content.Translation
+			var prevValue interface{}
+			if current != nil {
+				prev, prevValue = current.Translations[k][l], current.Fields[k][l]
+			}
+
+			translated := &content.Translation{Status: content.TranslationTranslated, Source: source}
+			t := &content.Translation{Status: content.TranslationUntranslated}
+			switch {
+			case req != nil && req.Status == content.TranslationUntranslated:
+			case req != nil && req.Status == content.TranslationTranslated && (prev == nil || prev.Status != content.TranslationTranslated):
+				t = translated
+			case !unset(v) && (current == nil || !reflect.DeepEqual(v, prevValue)):
+				t = translated
+			case prev != nil && prev.Status != content.TranslationUntranslated && reflect.DeepEqual(v, prevValue):
+				t = &content.Translation{Status: content.TranslationTranslated, Source: prev.Source}
+			}
+			mc.Translations[k][l] = t
+		}
+	}
+}
+
+// MergeEntries merges the locale files of every entry among the files of a collection, ordered by entry
+func MergeEntries(workdir string, files []*github.RepositoryContent, cs content.Schema, ls Locales) ([]*content.MergedContentData, error) {
+	entries := entryFiles(workdir, files)
+
+	merged := make([]*content.MergedContentData, 0, len(entries))
+	for _, dir := range sortedKeys(entries) {
+		rc := make([]*github.RepositoryContent, 0)
+		for _, l := range sortedKeys(entries[dir]) {
+			rc = append(rc, entries[dir][l])
+		}
+		mc, err := MergeLocalisedContent(rc, cs, ls)
+		if err != nil {
+			return nil, err
+		}
+		if len(mc.ID) == 0 {
+			mc.ID = filepath.Base(dir)
+		}
+		merged = append(merged, mc)
+	}
+	return merged, nil
+}
+
+// TranslationReports returns the translation report of each locale for the files of a collection,
+// entries without a file of a locale are untranslated in it
+func TranslationReports(workdir string, collection string, files []*github.RepositoryContent, cs content.Schema, ls Locales, locales []string) ([]*TranslationReport, error) {
+	merged, err := MergeEntries(workdir, files, cs, ls)
+	if err != nil {
+		return nil, err
+	}
+
+	localized := make([]string, 0)
+	for _, f := range cs.Fields {
+		if f.Localized {
+			localized = append(localized, f.ID)
+		}
+	}
+
+	res := make([]*TranslationReport, 0, len(locales))
+	for _, l := range locales {
+		tr := &TranslationReport{Collection: collection, Locale: l, Entries: len(merged), Items: make([]*TranslationReportItem, 0)}
+		for _, mc := range merged {
+			item := &TranslationReportItem{Entry: mc.ID}
+			for _, k := range localized {
+				tr.Fields++
+				status := content.TranslationUntranslated
+				if t := mc.Translations[k][l]; t != nil {
+					status = t.Status
+				}
+				switch status {
+				case content.TranslationTranslated:
+					tr.Translated++
+				case content.TranslationReview:
+					tr.Review++
+					item.Review = append(item.Review, k)
+				default:
+					tr.Untranslated++
+					item.Untranslated = append(item.Untranslated, k)
+				}
+			}
+			if len(item.Untranslated) > 0 || len(item.Review) > 0 {
+				tr.Items = append(tr.Items, item)
+			}
+		}
+		res = append(res, tr)
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package cms
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+var translationSchema = content.Schema{Fields: content.Fields{{ID: "title", Localized: true}, {ID: "body", Localized: true}, {ID: "count"}}}
+
+// roundTrip writes the content as locale files and merges them back
+func roundTrip(t *testing.T, mc content.MergedContentData, ls Locales) (*content.MergedContentData, []*github.RepositoryContent) {
+	items, err := SeparateLocalisedContent(mc, ls, "content", "posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := make([]*github.RepositoryContent, 0)
+	for _, it := range items {
+		rc = append(rc, &github.RepositoryContent{Name: github.String(it.Path), Path: github.String(it.Path), Content: it.Content})
+	}
+	merged, err := MergeLocalisedContent(rc, translationSchema, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return merged, rc
+}
+
+func status(mc *content.MergedContentData, field, locale string) string {
+	if t := mc.Translations[field][locale]; t != nil {
+		return t.Status
+	}
+	return ""
+}
+
+func TestTranslations(t *testing.T) {
+	ls, _ := ParseLocales([]byte(`["en", "de"]`))
+
+	mc := content.MergedContentData{ID: "post", Fields: map[string]map[string]interface{}{
+		"title": {"en": "Hello", "de": "Hallo"},
+		"body":  {"en": "Text", "de": nil},
+		"count": {"en": 1.0},
+	}}
+	TrackTranslations(nil, &mc, translationSchema, ls)
+	current, _ := roundTrip(t, mc, ls)
+	if status(current, "title", "de") != content.TranslationTranslated || status(current, "body", "de") != content.TranslationUntranslated {
+		t.Fatalf("unexpected statuses %v", current.Translations)
+	}
+
+	// changing the default value leaves the translation for review
+	next := *current
+	next.Fields = map[string]map[string]interface{}{
+		"title": {"en": "Hello world", "de": "Hallo"},
+		"body":  {"en": "Text", "de": nil},
+		"count": {"en": 1.0},
+	}
+	TrackTranslations(current, &next, translationSchema, ls)
+	current, _ = roundTrip(t, next, ls)
+	if status(current, "title", "de") != content.TranslationReview {
+		t.Fatalf("expected review, got %v", current.Translations["title"])
+	}
+
+	// saving again keeps the review pending until it is marked translated
+	next = *current
+	TrackTranslations(current, &next, translationSchema, ls)
+	current, _ = roundTrip(t, next, ls)
+	if status(current, "title", "de") != content.TranslationReview {
+		t.Fatalf("review lost, got %v", current.Translations["title"])
+	}
+
+	// a value the same as the default one is kept when marked translated
+	next = *current
+	next.Translations = map[string]map[string]*content.Translation{
+		"title": {"de": {Status: content.TranslationTranslated}},
+		"body":  {"de": {Status: content.TranslationTranslated}},
+	}
+	TrackTranslations(current, &next, translationSchema, ls)
+	current, rc := roundTrip(t, next, ls)
+	if status(current, "title", "de") != content.TranslationTranslated || status(current, "body", "de") != content.TranslationTranslated {
+		t.Fatalf("unexpected statuses %v", current.Translations)
+	}
+	if current.Fields["body"]["de"] != "Text" {
+		t.Errorf("translated value left empty: %v", current.Fields["body"])
+	}
+
+	reports, err := TranslationReports("content", "posts", rc, translationSchema, ls, []string{"de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := reports[0]; r.Entries != 1 || r.Fields != 2 || r.Translated != 2 || len(r.Items) != 0 {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
+
+func TestTranslationReports(t *testing.T) {
+	ls, _ := ParseLocales([]byte(`["en", "de", "fr"]`))
+	files := []*github.RepositoryContent{
+		{Name: github.String("en.json"), Path: github.String("content/posts/a/en.json"), Content: github.String(`{"id":"a","fields":{"title":"Hello","body":"Text"}}`)},
+		{Name: github.String("de.json"), Path: github.String("content/posts/a/de.json"), Content: github.String(`{"id":"a","fields":{"title":"Hallo","body":"Text"}}`)},
+		{Name: github.String("en.json"), Path: github.String("content/posts/b/en.json"), Content: github.String(`{"id":"b","fields":{"title":"Bye","body":"Text"}}`)},
+		{Name: github.String("de.json"), Path: github.String("content/posts/b/de.json"), Content: github.String(`{"id":"b","fields":{"title":"Tschüss","body":"Text"},"translations":{"title":{"status":"translated","source":"stale"}}}`)},
+	}
+
+	reports, err := TranslationReports("content", "posts", files, translationSchema, ls, []string{"de", "fr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	de := reports[0]
+	if de.Translated != 1 || de.Review != 1 || de.Untranslated != 2 || len(de.Items) != 2 {
+		t.Errorf("unexpected report %+v", de)
+	}
+	if b := de.Items[1]; b.Entry != "b" || len(b.Review) != 1 || b.Review[0] != "title" {
+		t.Errorf("unexpected report item %+v", b)
+	}
+
+	fr := reports[1]
+	if fr.Untranslated != 4 || len(fr.Items) != 2 {
+		t.Errorf("missing locale files not untranslated: %+v", fr)
+	}
+}
@@ -0,0 +1,121 @@
+package cms
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/rs/xid"
+)
+
+const (
+	TrashMetaName = "_trash.json"
+
+	trashIndexFolder = "_index"
+
+	TrashKindEntry      = "entry"
+	TrashKindCollection = "collection"
+)
+
+// TrashItem describes an entry or collection moved into the trash folder,
+// the original files live under TrashItem.FilesPath()
+type TrashItem struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Collection string    `json:"collection"`
+	Entry      string    `json:"entry,omitempty"`
+	Path       string    `json:"path"`
+	DeletedAt  time.Time `json:"deletedAt"`
+	DeletedBy  string    `json:"deletedBy"`
+}
+
+func NewTrashItem(kind, collection, entry, path, login string) *TrashItem {
+	return &TrashItem{
+		ID:         xid.New().String(),
+		Kind:       kind,
+		Collection: collection,
+		Entry:      entry,
+		Path:       path,
+		DeletedAt:  time.Now().UTC(),
+		DeletedBy:  login,
+	}
+}
+
+func ParseTrashItem(data []byte) (*TrashItem, error) {
+	item := &TrashItem{}
+	err := json.Unmarshal(data, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ValidTrashID reports whether the id is the one of a trash item, which are xids
+func ValidTrashID(id string) bool {
+	_, err := xid.FromString(id)
+	return err == nil
+}
+
+// TrashIndexPath returns the folder indexing the trash items of a collection
+func TrashIndexPath(collection string) string {
+	return filepath.Join(TrashFolder, trashIndexFolder, collection)
+}
+
+func TrashItemPath(id string) string {
+	return filepath.Join(TrashFolder, id)
+}
+
+func (t *TrashItem) MetaPath() string {
+	return filepath.Join(TrashItemPath(t.ID), TrashMetaName)
+}
+
+// IndexPath returns the file indexing the item under its collection, and its entry for trashed entries,
+// so the items holding an entry are found without reading every item of the trash
+func (t *TrashItem) IndexPath() string {
+	dir := TrashIndexPath(t.Collection)
+	if t.Kind == TrashKindEntry {
+		dir = filepath.Join(dir, t.Entry)
+	}
+	return filepath.Join(dir, t.ID+".json")
+}
+
+func (t *TrashItem) FilesPath() string {
+	return filepath.Join(TrashItemPath(t.ID), "files")
+}
+
+func (t *TrashItem) ExpiresAt(retention time.Duration) time.Time {
+	return t.DeletedAt.Add(retention)
+}
+
+func (t *TrashItem) Expired(retention time.Duration) bool {
+	return time.Now().After(t.ExpiresAt(retention))
+}
+
+// Contains reports whether the trashed item holds the given entry, either
+// directly or as part of a trashed collection
+func (t *TrashItem) Contains(collection, entry string) bool {
+	if t.Collection != collection {
+		return false
+	}
+	return t.Kind == TrashKindCollection || t.Entry == entry
+}
+
+// LatestTrashed returns the index file of the latest trash item holding the entry among
+// the index files of its collection, empty if there is none
+func LatestTrashed(files []*github.RepositoryContent, collection, entry string) string {
+	dir := TrashIndexPath(collection)
+	latest, latestID := "", ""
+	for _, f := range files {
+		p := f.GetPath()
+		if d := filepath.Dir(p); d != dir && d != filepath.Join(dir, entry) {
+			continue
+		}
+		// xids sort by the time they were made
+		id := filepath.Base(p[:len(p)-len(filepath.Ext(p))])
+		if ValidTrashID(id) && id > latestID {
+			latest, latestID = p, id
+		}
+	}
+	return latest
+}
@@ -0,0 +1,62 @@
+package cms
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+)
+
+func TestTrashItemContains(t *testing.T) {
+	entry := NewTrashItem(TrashKindEntry, "posts", "hello", "content/posts/hello", "foo")
+	if !entry.Contains("posts", "hello") || entry.Contains("posts", "other") {
+		t.Fail()
+	}
+
+	collection := NewTrashItem(TrashKindCollection, "posts", "", "content/posts", "foo")
+	if !collection.Contains("posts", "other") || collection.Contains("pages", "other") {
+		t.Fail()
+	}
+}
+
+func TestTrashItemExpired(t *testing.T) {
+	cfg := ParseConfig([]byte("trash:\n  retentionDays: 1\n"))
+	item := NewTrashItem(TrashKindEntry, "posts", "hello", "posts/hello", "foo")
+	if item.Expired(cfg.TrashRetention()) {
+		t.Fail()
+	}
+
+	item.DeletedAt = item.DeletedAt.Add(-48 * time.Hour)
+	if !item.Expired(cfg.TrashRetention()) {
+		t.Fail()
+	}
+
+	if ParseConfig(nil).TrashRetention() != defaultTrashRetentionDays*24*time.Hour {
+		t.Fail()
+	}
+}
+
+func TestTrashIndex(t *testing.T) {
+	older := NewTrashItem(TrashKindCollection, "posts", "", "content/posts", "foo")
+	entry := NewTrashItem(TrashKindEntry, "posts", "hello", "content/posts/hello", "foo")
+	other := NewTrashItem(TrashKindEntry, "posts", "other", "content/posts/other", "foo")
+	files := []*github.RepositoryContent{
+		{Path: github.String(older.IndexPath())},
+		{Path: github.String(entry.IndexPath())},
+		{Path: github.String(other.IndexPath())},
+	}
+
+	if p := LatestTrashed(files, "posts", "hello"); p != entry.IndexPath() {
+		t.Errorf("unexpected index file %s", p)
+	}
+	if p := LatestTrashed(files, "posts", "new"); p != older.IndexPath() {
+		t.Errorf("entry of a trashed collection not found: %s", p)
+	}
+	if p := LatestTrashed(files[2:], "posts", "new"); p != "" {
+		t.Errorf("unexpected index file %s", p)
+	}
+
+	if !ValidTrashID(entry.ID) || ValidTrashID("..") || ValidTrashID("") {
+		t.Error("unexpected trash id validation")
+	}
+}
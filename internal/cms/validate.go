@@ -0,0 +1,97 @@
+package cms
+
+import (
+	"fmt"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+const validationRequired = "required"
+
+// EntryRef points to an entry of a collection
+type EntryRef struct {
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+}
+
+// ValidateContent checks merged entry content against the collection schema,
+// required fields have to be set in the default locale
+func ValidateContent(cs content.Schema, mc content.MergedContentData, defaultLocale string) error {
+	fields := make(map[string]*content.Field)
+	for _, f := range cs.Fields {
+		fields[f.ID] = f
+	}
+
+	for k := range mc.Fields {
+		if fields[k] == nil {
+			return fmt.Errorf("unknown field: %s", k)
+		}
+	}
+
+	for _, f := range cs.Fields {
+		if isRequired(f) && isEmptyValue(mc.Fields[f.ID][defaultLocale]) {
+			return fmt.Errorf("missing field: %s", f.ID)
+		}
+	}
+
+	return nil
+}
+
+// References returns the entries referenced by the content, the referenced
+// collection is the one described by the nested schema of the reference field
+func References(cs content.Schema, mc content.MergedContentData) []EntryRef {
+	refs := make([]EntryRef, 0)
+	for _, f := range cs.Fields {
+		if !f.Reference || f.Schema == nil || len(f.Schema.ID) == 0 {
+			continue
+		}
+		for _, v := range mc.Fields[f.ID] {
+			for _, id := range referenceIDs(v) {
+				refs = append(refs, EntryRef{f.Schema.ID, id})
+			}
+		}
+	}
+	return refs
+}
+
+func referenceIDs(v interface{}) []string {
+	switch rv := v.(type) {
+	case string:
+		if len(rv) > 0 {
+			return []string{rv}
+		}
+	case []interface{}:
+		ids := make([]string, 0)
+		for _, i := range rv {
+			ids = append(ids, referenceIDs(i)...)
+		}
+		return ids
+	case map[string]interface{}:
+		// reference objects carry the entry id
+		if id, ok := rv["id"].(string); ok && len(id) > 0 {
+			return []string{id}
+		}
+	}
+	return nil
+}
+
+func isRequired(f *content.Field) bool {
+	for _, v := range f.Validations {
+		if v.Type == validationRequired && v.Value != false {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch ev := v.(type) {
+	case nil:
+		return true
+	case string:
+		return len(ev) == 0
+	case []interface{}:
+		return len(ev) == 0
+	}
+	return false
+}
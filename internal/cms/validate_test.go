@@ -0,0 +1,43 @@
+package cms
+
+import (
+	"testing"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+var testSchema = content.Schema{
+	Fields: content.Fields{
+		{ID: "title", Validations: []*content.Validation{{Type: "required", Value: true}}},
+		{ID: "author", Reference: true, Schema: &content.Schema{ID: "authors"}},
+	},
+}
+
+func TestValidateContent(t *testing.T) {
+	mc := content.MergedContentData{Fields: map[string]map[string]interface{}{
+		"title": {"en": "hello"},
+	}}
+	if err := ValidateContent(testSchema, mc, content.DefaultLocale); err != nil {
+		t.Error(err)
+	}
+
+	mc.Fields["title"]["en"] = ""
+	if err := ValidateContent(testSchema, mc, content.DefaultLocale); err == nil {
+		t.Error("expected missing field error")
+	}
+
+	mc.Fields["unknown"] = map[string]interface{}{"en": 1}
+	if err := ValidateContent(testSchema, mc, content.DefaultLocale); err == nil {
+		t.Error("expected unknown field error")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	mc := content.MergedContentData{Fields: map[string]map[string]interface{}{
+		"author": {"en": []interface{}{"foo", map[string]interface{}{"id": "bar"}}},
+	}}
+	refs := References(testSchema, mc)
+	if len(refs) != 2 || refs[0] != (EntryRef{"authors", "foo"}) || refs[1] != (EntryRef{"authors", "bar"}) {
+		t.Errorf("unexpected references: %v", refs)
+	}
+}
@@ -2,16 +2,44 @@ package env
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 var (
-	JwtKey             []byte
-	JweKey             []byte
-	GithubClientID     string
-	GithubClientSecret string
+	JwtKey              []byte
+	JweKey              []byte
+	GithubClientID      string
+	GithubClientSecret  string
+	GithubWebhookSecret string
+	GithubServiceToken  string
+	GithubAppID         string
+	GithubAppKey        []byte
+	CacheBackend        string
+	CacheDir            string
+	RedisURL            string
+	CacheControl        string
+	IndexDir            string
+	StoreBackend        string
+	StoreDir            string
+	Admins              []string
+	OIDCIssuer          string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCRedirectURL     string
+	OIDCGroupsClaim     string
+	OIDCAllowedDomains  []string
+	OIDCServiceToken    string
+	AuditSink           string
+	AuditRepo           string
+	AuditBranch         string
+	AuditToken          string
+	RateLimitUser       int
+	RateLimitAPIKey     int
+	GithubRateReserve   int
 )
 
 func init() {
@@ -26,6 +54,32 @@ func init() {
 	JweKey = []byte(os.Getenv("JWE_KEY"))
 	GithubClientID = os.Getenv("GITHUB_CLIENT_ID")
 	GithubClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
+	GithubWebhookSecret = os.Getenv("GITHUB_WEBHOOK_SECRET")
+	GithubServiceToken = os.Getenv("GITHUB_SERVICE_TOKEN")
+	GithubAppID = os.Getenv("GITHUB_APP_ID")
+	GithubAppKey = appKey()
+	CacheBackend = get("CACHE_BACKEND", "memory")
+	CacheDir = get("CACHE_DIR", filepath.Join(os.TempDir(), "moonbase-cache"))
+	RedisURL = get("REDIS_URL", "redis://localhost:6379")
+	CacheControl = get("CACHE_CONTROL", "private, no-cache")
+	IndexDir = get("INDEX_DIR", "")
+	StoreBackend = get("STORE_BACKEND", "file")
+	StoreDir = get("STORE_DIR", "data")
+	Admins = list("ADMINS")
+	OIDCIssuer = os.Getenv("OIDC_ISSUER")
+	OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	OIDCRedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	OIDCGroupsClaim = get("OIDC_GROUPS_CLAIM", "groups")
+	OIDCAllowedDomains = list("OIDC_ALLOWED_DOMAINS")
+	OIDCServiceToken = os.Getenv("OIDC_SERVICE_TOKEN")
+	AuditSink = get("AUDIT_SINK", "file")
+	AuditRepo = os.Getenv("AUDIT_REPO")
+	AuditBranch = get("AUDIT_BRANCH", "audit")
+	AuditToken = os.Getenv("AUDIT_GITHUB_TOKEN")
+	RateLimitUser = getint("RATE_LIMIT_USER", 600)
+	RateLimitAPIKey = getint("RATE_LIMIT_APIKEY", 1200)
+	GithubRateReserve = getint("GITHUB_RATE_RESERVE", 250)
 }
 
 func Port(def int) int {
@@ -42,6 +96,27 @@ func get(key string, def string) string {
 	return s
 }
 
+// list splits a comma separated value
+func list(key string) []string {
+	res := make([]string, 0)
+	for _, s := range strings.Split(os.Getenv(key), ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// appKey reads the pem private key of the github app either from
+// the environment, with escaped newlines allowed, or from a file
+func appKey() []byte {
+	if path := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE"); len(path) > 0 {
+		data, _ := os.ReadFile(path)
+		return data
+	}
+	return []byte(strings.ReplaceAll(os.Getenv("GITHUB_APP_PRIVATE_KEY"), `\n`, "\n"))
+}
+
 func getint(key string, def int) int {
 	i, err := strconv.Atoi(os.Getenv(key))
 	if err != nil {
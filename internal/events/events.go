@@ -0,0 +1,68 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// content event types
+const (
+	EntryCreate      = "entry.create"
+	EntryUpdate      = "entry.update"
+	EntryDelete      = "entry.delete"
+	EntryPublish     = "entry.publish"
+	EntryUnpublish   = "entry.unpublish"
+	CollectionCreate = "collection.create"
+	CollectionDelete = "collection.delete"
+	SchemaChange     = "schema.change"
+)
+
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Owner      string    `json:"owner"`
+	Repo       string    `json:"repo"`
+	Ref        string    `json:"ref"`
+	Collection string    `json:"collection,omitempty"`
+	Entry      string    `json:"entry,omitempty"`
+	Locales    []string  `json:"locales,omitempty"`
+	Commit     string    `json:"commit,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type Handler func(e *Event)
+
+var (
+	mu       sync.RWMutex
+	handlers []Handler
+)
+
+func New(typ, owner, repo, ref string) *Event {
+	return &Event{
+		ID:        xid.New().String(),
+		Type:      typ,
+		Owner:     owner,
+		Repo:      repo,
+		Ref:       ref,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// Subscribe registers a handler called for every published event,
+// handlers are called synchronously so they should not block
+func Subscribe(h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers = append(handlers, h)
+}
+
+func Publish(e *Event) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
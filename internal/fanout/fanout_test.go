@@ -0,0 +1,167 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	items := make([]int, 50)
+	for i := range items {
+		items[i] = i
+	}
+
+	var running, peak int32
+	res, err := Map(context.Background(), 4, items, func(ctx context.Context, i int) (int, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		// later items finish first
+		time.Sleep(time.Duration(50-i) * 50 * time.Microsecond)
+		atomic.AddInt32(&running, -1)
+		return i * i, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range res {
+		if r != i*i {
+			t.Fatalf("unordered results at %d: %d", i, r)
+		}
+	}
+	if peak > 4 {
+		t.Errorf("expected at most 4 calls at a time, got %d", peak)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	failure := errors.New("failure")
+	var calls int32
+	_, err := Map(context.Background(), 2, make([]int, 100), func(ctx context.Context, i int) (int, error) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			return 0, failure
+		}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the first error, got %v", err)
+	}
+	if calls > 3 {
+		t.Errorf("calls not stopped after the error, %d made", calls)
+	}
+}
+
+func TestMapCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Map(ctx, 2, []int{1, 2, 3}, func(ctx context.Context, i int) (int, error) {
+		return i, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := &Group[string]{}
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "blob", nil
+	}
+
+	var wg sync.WaitGroup
+	res := make([]string, 10)
+	for i := range res {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res[i], _ = g.Do(context.Background(), "sha", fn)
+		}(i)
+	}
+	// wait until every caller joined the call
+	for {
+		g.mu.Lock()
+		c := g.calls["sha"]
+		joined := c != nil && c.waiters == len(res)
+		g.mu.Unlock()
+		if joined {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected a single call, got %d", calls)
+	}
+	for _, r := range res {
+		if r != "blob" {
+			t.Errorf("unexpected result %q", r)
+		}
+	}
+
+	// finished calls are not kept
+	if v, _ := g.Do(context.Background(), "sha", func(ctx context.Context) (string, error) { return "again", nil }); v != "again" {
+		t.Errorf("finished call reused, got %q", v)
+	}
+}
+
+func TestGroupCancel(t *testing.T) {
+	g := &Group[int]{}
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return 0, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, err := g.Do(ctx1, "key", fn); errs <- err }()
+	<-started
+	go func() { _, err := g.Do(ctx2, "key", fn); errs <- err }()
+	for {
+		g.mu.Lock()
+		joined := g.calls["key"].waiters == 2
+		g.mu.Unlock()
+		if joined {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the call outlives the caller which started it
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the first caller cancelled, got %v", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("call cancelled while a caller still waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	<-errs
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("call not cancelled after every caller gave up")
+	}
+}
@@ -0,0 +1,275 @@
+package index
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/cms"
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+// File is a file of the repository with the sha git gives its content
+type File struct {
+	Path string
+	SHA  string
+	Data []byte
+}
+
+// Source reads the repository state an index is built from
+type Source interface {
+	// Resolve returns the commit the ref points to
+	Resolve(ctx context.Context) (string, error)
+	// Archive returns every file of the repository at the commit
+	Archive(ctx context.Context, commit string) ([]*File, error)
+	// Changes returns the paths changed between two commits, complete is false if the list is partial
+	Changes(ctx context.Context, base string, head string) (paths []string, complete bool, err error)
+	// Read returns the file at path, nil if it does not exist at the commit
+	Read(ctx context.Context, commit string, path string) (*File, error)
+}
+
+// Entry is an indexed entry with the content of each of its locales
+type Entry struct {
+	Collection string                          `json:"collection"`
+	ID         string                          `json:"id"`
+	Locales    map[string]*content.ContentData `json:"locales"`
+}
+
+// Index keeps the parsed content of a repository ref in memory
+type Index struct {
+	Owner     string                       `json:"owner"`
+	Repo      string                       `json:"repo"`
+	Ref       string                       `json:"ref"`
+	WorkDir   string                       `json:"workdir"`
+	Commit    string                       `json:"commit"`
+	UpdatedAt time.Time                    `json:"updatedAt"`
+	Files     map[string]string            `json:"files"`
+	Schemas   map[string]*content.Schema   `json:"schemas"`
+	Entries   map[string]map[string]*Entry `json:"entries"`
+
+	mu     sync.RWMutex
+	syncMu sync.Mutex
+	search searchState
+}
+
+func New(owner, repo, ref, workdir string) *Index {
+	idx := &Index{Owner: owner, Repo: repo, Ref: ref, WorkDir: workdir}
+	idx.reset()
+	return idx
+}
+
+func (idx *Index) reset() {
+	idx.Commit = ""
+	idx.Files = make(map[string]string)
+	idx.Schemas = make(map[string]*content.Schema)
+	idx.Entries = make(map[string]map[string]*Entry)
+}
+
+// Build replaces the index with the content of the commit the ref points to
+func (idx *Index) Build(ctx context.Context, src Source) error {
+	idx.syncMu.Lock()
+	defer idx.syncMu.Unlock()
+	return idx.build(ctx, src)
+}
+
+func (idx *Index) build(ctx context.Context, src Source) error {
+	commit, err := src.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	files, err := src.Archive(ctx, commit)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.reset()
+	for _, f := range files {
+		idx.put(f)
+	}
+	idx.Commit = commit
+	idx.UpdatedAt = time.Now().UTC()
+
+	return nil
+}
+
+// Sync brings the index up to the commit the ref points to by reading only the changed files,
+// the index is rebuilt when the changes can't be listed completely
+func (idx *Index) Sync(ctx context.Context, src Source) error {
+	idx.syncMu.Lock()
+	defer idx.syncMu.Unlock()
+
+	commit, err := src.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.RLock()
+	base := idx.Commit
+	idx.mu.RUnlock()
+
+	if len(base) == 0 {
+		return idx.build(ctx, src)
+	}
+	if base == commit {
+		return nil
+	}
+
+	paths, complete, err := src.Changes(ctx, base, commit)
+	if err != nil || !complete {
+		return idx.build(ctx, src)
+	}
+
+	files := make([]*File, 0)
+	removed := make([]string, 0)
+	for _, p := range paths {
+		if _, ok := cms.ParseContentPath(idx.WorkDir, p); !ok {
+			continue
+		}
+		f, err := src.Read(ctx, commit, p)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			removed = append(removed, p)
+		} else {
+			files = append(files, f)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, p := range removed {
+		idx.remove(p)
+	}
+	for _, f := range files {
+		idx.put(f)
+	}
+	idx.Commit = commit
+	idx.UpdatedAt = time.Now().UTC()
+
+	return nil
+}
+
+// put adds a schema or entry file, anything else is ignored
+func (idx *Index) put(f *File) {
+	cp, ok := cms.ParseContentPath(idx.WorkDir, f.Path)
+	if !ok || filepath.Ext(f.Path) != ".json" {
+		return
+	}
+
+	if len(cp.Entry) == 0 {
+		cs := &content.Schema{}
+		if json.Unmarshal(f.Data, cs) != nil {
+			return
+		}
+		idx.Schemas[cp.Collection] = cs
+	} else {
+		cd := &content.ContentData{}
+		if json.Unmarshal(f.Data, cd) != nil {
+			return
+		}
+		if idx.Entries[cp.Collection] == nil {
+			idx.Entries[cp.Collection] = make(map[string]*Entry)
+		}
+		e := idx.Entries[cp.Collection][cp.Entry].clone(cp.Collection, cp.Entry)
+		e.Locales[cp.Locale] = cd
+		idx.Entries[cp.Collection][cp.Entry] = e
+	}
+
+	sha := f.SHA
+	if len(sha) == 0 {
+		sha = BlobSHA(f.Data)
+	}
+	idx.Files[f.Path] = sha
+}
+
+func (idx *Index) remove(path string) {
+	delete(idx.Files, path)
+
+	cp, ok := cms.ParseContentPath(idx.WorkDir, path)
+	if !ok {
+		return
+	}
+
+	if len(cp.Entry) == 0 {
+		delete(idx.Schemas, cp.Collection)
+		return
+	}
+
+	if idx.Entries[cp.Collection][cp.Entry] == nil {
+		return
+	}
+	e := idx.Entries[cp.Collection][cp.Entry].clone(cp.Collection, cp.Entry)
+	delete(e.Locales, cp.Locale)
+	if len(e.Locales) == 0 {
+		delete(idx.Entries[cp.Collection], cp.Entry)
+	} else {
+		idx.Entries[cp.Collection][cp.Entry] = e
+	}
+}
+
+// clone returns a copy of the entry to be changed, a new entry if there is none yet
+func (e *Entry) clone(collection, id string) *Entry {
+	c := &Entry{Collection: collection, ID: id, Locales: make(map[string]*content.ContentData)}
+	if e != nil {
+		for l, cd := range e.Locales {
+			c.Locales[l] = cd
+		}
+	}
+	return c
+}
+
+// Schema returns the schema of the collection, nil if it's not indexed
+func (idx *Index) Schema(collection string) *content.Schema {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.Schemas[collection]
+}
+
+// Collections returns the indexed collections with the number of their entries
+func (idx *Index) Collections() map[string]int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	res := make(map[string]int)
+	for c := range idx.Schemas {
+		res[c] = len(idx.Entries[c])
+	}
+	for c, entries := range idx.Entries {
+		res[c] = len(entries)
+	}
+	return res
+}
+
+// CollectionEntries returns the entries of a collection ordered by id,
+// entries are never changed in place so they can be read without holding the lock
+func (idx *Index) CollectionEntries(collection string) []*Entry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	res := make([]*Entry, 0, len(idx.Entries[collection]))
+	for _, e := range idx.Entries[collection] {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// BlobSHA returns the sha git gives a blob with the given content
+func BlobSHA(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,149 @@
+package index
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	commit  string
+	files   map[string]map[string]string
+	changes []string
+	builds  int
+}
+
+func (s *fakeSource) Resolve(ctx context.Context) (string, error) {
+	return s.commit, nil
+}
+
+func (s *fakeSource) Archive(ctx context.Context, commit string) ([]*File, error) {
+	s.builds++
+	files := make([]*File, 0)
+	for p, data := range s.files[commit] {
+		files = append(files, &File{Path: p, Data: []byte(data)})
+	}
+	return files, nil
+}
+
+func (s *fakeSource) Changes(ctx context.Context, base string, head string) ([]string, bool, error) {
+	return s.changes, true, nil
+}
+
+func (s *fakeSource) Read(ctx context.Context, commit string, path string) (*File, error) {
+	data, ok := s.files[commit][path]
+	if !ok {
+		return nil, nil
+	}
+	return &File{Path: path, Data: []byte(data)}, nil
+}
+
+func TestSync(t *testing.T) {
+	src := &fakeSource{commit: "c1", files: map[string]map[string]string{
+		"c1": {
+			"content/posts/_schema.json":   `{"id":"posts"}`,
+			"content/posts/hello/en.json":  `{"id":"hello","fields":{"title":"Hello"}}`,
+			"content/posts/hello/de.json":  `{"id":"hello","fields":{"title":"Hallo"}}`,
+			"content/posts/second/en.json": `{"id":"second","fields":{"title":"Second"}}`,
+			"README.md":                    `readme`,
+		},
+		"c2": {
+			"content/posts/_schema.json":  `{"id":"posts"}`,
+			"content/posts/hello/en.json": `{"id":"hello","fields":{"title":"Hello again"}}`,
+			"content/posts/hello/de.json": `{"id":"hello","fields":{"title":"Hallo"}}`,
+			"content/posts/third/en.json": `{"id":"third","fields":{"title":"Third"}}`,
+		},
+	}}
+
+	idx := New("owner", "repo", "main", "content")
+	if err := idx.Sync(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Commit != "c1" || idx.Collections()["posts"] != 2 || idx.Schema("posts") == nil {
+		t.Fatalf("unexpected index after build: %v", idx.Collections())
+	}
+	if _, ok := idx.Files["README.md"]; ok {
+		t.Error("non content file indexed")
+	}
+
+	src.commit = "c2"
+	src.changes = []string{"content/posts/hello/en.json", "content/posts/second/en.json", "content/posts/third/en.json"}
+	if err := idx.Sync(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if src.builds != 1 {
+		t.Errorf("expected an incremental sync, got %d builds", src.builds)
+	}
+
+	entries := idx.CollectionEntries("posts")
+	if len(entries) != 2 || entries[0].ID != "hello" || entries[1].ID != "third" {
+		t.Fatalf("unexpected entries after sync: %v", entries)
+	}
+	if entries[0].Locales["en"].Fields["title"] != "Hello again" || entries[0].Locales["de"] == nil {
+		t.Error("entry not updated")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	files := map[string]map[string]string{
+		"c1": {"content/posts/hello/en.json": `{"id":"hello","fields":{"title":"Hello"}}`},
+		"c2": {"content/posts/hello/en.json": `{"id":"hello","fields":{"title":"Hello again"}}`},
+	}
+	m := NewManager("")
+	idx, err := m.Get(context.Background(), "owner", "repo", "main", "content", &fakeSource{commit: "c1", files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a source of its own the manager leaves the index to the next read
+	m.Refresh("owner", "repo", "main")
+
+	refs := make(chan string, 1)
+	m.SetSource(func(ctx context.Context, owner, repo, ref string) (Source, error) {
+		refs <- owner + "/" + repo + "@" + ref
+		return &fakeSource{commit: "c2", files: files, changes: []string{"content/posts/hello/en.json"}}, nil
+	})
+	m.Refresh("owner", "repo", "main")
+	if ref := <-refs; ref != "owner/repo@main" {
+		t.Errorf("unexpected source for %s", ref)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for idx.commit() != "c2" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if idx.commit() != "c2" {
+		t.Fatalf("expected the index refreshed to c2, got %s", idx.commit())
+	}
+}
+
+func TestFind(t *testing.T) {
+	src := &fakeSource{commit: "c1", files: map[string]map[string]string{
+		"c1": {
+			"content/posts/a/en.json": `{"id":"a","status":"published","fields":{"rank":3,"tags":["go","cms"]}}`,
+			"content/posts/a/de.json": `{"id":"a","status":"published","fields":{"rank":3,"title":"Deutsch"}}`,
+			"content/posts/b/en.json": `{"id":"b","status":"draft","fields":{"rank":1,"tags":["cms"]}}`,
+			"content/posts/c/en.json": `{"id":"c","status":"published","fields":{"rank":2}}`,
+		},
+	}}
+
+	idx := New("owner", "repo", "main", "content")
+	if err := idx.Build(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	res, total := idx.Find(Query{Collection: "posts", Filter: map[string]string{"status": "published"}, Order: []Order{{Field: "rank"}}})
+	if total != 2 || res[0].ID != "c" || res[1].ID != "a" {
+		t.Errorf("unexpected filter result: %d", total)
+	}
+
+	res, total = idx.Find(Query{Collection: "posts", Filter: map[string]string{"tags": "cms"}, Order: []Order{{Field: "rank", Desc: true}}, Offset: 1, Limit: 1})
+	if total != 2 || len(res) != 1 || res[0].ID != "b" {
+		t.Errorf("unexpected paged result: %d", total)
+	}
+
+	res, _ = idx.Find(Query{Collection: "posts", Locale: "de"})
+	if len(res) != 3 || res[0].Fields["title"] != "Deutsch" || res[1].ID != "b" {
+		t.Error("locale fallback failed")
+	}
+}
@@ -0,0 +1,170 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/log"
+)
+
+var Default = NewManager(env.IndexDir)
+
+// Manager keeps the indexes of every repository ref in use,
+// indexes are persisted to dir when it's set so restarts only need to catch up
+type Manager struct {
+	dir string
+
+	mu      sync.Mutex
+	indexes map[string]*Index
+	source  func(ctx context.Context, owner, repo, ref string) (Source, error)
+}
+
+func NewManager(dir string) *Manager {
+	return &Manager{
+		dir:     dir,
+		indexes: make(map[string]*Index),
+	}
+}
+
+// SetSource sets how indexes are read outside of requests, they are not refreshed in the background without one
+func (m *Manager) SetSource(fn func(ctx context.Context, owner, repo, ref string) (Source, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.source = fn
+}
+
+// Get returns the index of the ref synced to the commit the ref points to, it's built on first use
+func (m *Manager) Get(ctx context.Context, owner, repo, ref, workdir string, src Source) (*Index, error) {
+	idx := m.index(owner, repo, ref, workdir)
+
+	commit := idx.commit()
+	err := idx.Sync(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if idx.commit() != commit {
+		m.save(idx)
+	}
+
+	return idx, nil
+}
+
+// Rebuild builds the index of the ref from scratch
+func (m *Manager) Rebuild(ctx context.Context, owner, repo, ref, workdir string, src Source) (*Index, error) {
+	idx := m.index(owner, repo, ref, workdir)
+
+	err := idx.Build(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	m.save(idx)
+
+	return idx, nil
+}
+
+// Refresh syncs an index already in use in the background, read from the source of the manager
+// as the credentials of requests must not outlive them
+func (m *Manager) Refresh(owner, repo, ref string) {
+	m.mu.Lock()
+	idx := m.indexes[key(owner, repo, ref)]
+	source := m.source
+	m.mu.Unlock()
+
+	if idx == nil || source == nil {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+		src, err := source(ctx, owner, repo, ref)
+		if err == nil {
+			err = idx.Sync(ctx, src)
+		}
+		if err != nil {
+			log.Error(err).Str("owner", owner).Str("repo", repo).Str("ref", ref).Msg("index refresh failed")
+			return
+		}
+		m.save(idx)
+	}()
+}
+
+// index returns the index of the ref loading it from disk if needed, a new one when the work dir changed
+func (m *Manager) index(owner, repo, ref, workdir string) *Index {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key(owner, repo, ref)
+	idx := m.indexes[k]
+	if idx == nil {
+		idx = m.load(k)
+	}
+	if idx == nil || idx.WorkDir != workdir {
+		idx = New(owner, repo, ref, workdir)
+	}
+	m.indexes[k] = idx
+
+	return idx
+}
+
+func (m *Manager) load(k string) *Index {
+	if len(m.dir) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.path(k))
+	if err != nil {
+		return nil
+	}
+
+	idx := &Index{}
+	err = json.Unmarshal(data, idx)
+	if err != nil {
+		log.Error(err).Str("index", k).Msg("ignoring unreadable index")
+		return nil
+	}
+
+	return idx
+}
+
+func (m *Manager) save(idx *Index) {
+	if len(m.dir) == 0 {
+		return
+	}
+
+	idx.mu.RLock()
+	data, err := json.Marshal(idx)
+	idx.mu.RUnlock()
+	if err == nil {
+		err = os.MkdirAll(m.dir, 0o700)
+	}
+	if err == nil {
+		p := m.path(key(idx.Owner, idx.Repo, idx.Ref))
+		err = os.WriteFile(p+".tmp", data, 0o600)
+		if err == nil {
+			err = os.Rename(p+".tmp", p)
+		}
+	}
+	if err != nil {
+		log.Error(err).Str("owner", idx.Owner).Str("repo", idx.Repo).Str("ref", idx.Ref).Msg("failed to persist index")
+	}
+}
+
+func (m *Manager) path(k string) string {
+	return filepath.Join(m.dir, url.PathEscape(k)+".json")
+}
+
+func (idx *Index) commit() string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.Commit
+}
+
+func key(owner, repo, ref string) string {
+	return strings.ToLower(owner + "/" + repo + "@" + ref)
+}
@@ -0,0 +1,166 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+// Order sorts query results by a field, metadata like updatedAt can be used as well
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+type Query struct {
+	Collection string
+	Locale     string
+	// Fallback are the locales tried in order for entries without content in the locale,
+	// the default locale when nil
+	Fallback []string
+	Filter   map[string]string
+	Order    []Order
+	Offset   int
+	Limit    int
+}
+
+// Find returns the content of the matching entries in the locale of the query
+// together with the number of matches before paging
+func (idx *Index) Find(q Query) ([]*content.ContentData, int) {
+	locale := q.Locale
+	if len(locale) == 0 {
+		locale = content.DefaultLocale
+	}
+	locales := append([]string{locale}, q.Fallback...)
+	if q.Fallback == nil {
+		locales = append(locales, content.DefaultLocale)
+	}
+
+	matches := make([]*content.ContentData, 0)
+	for _, e := range idx.CollectionEntries(q.Collection) {
+		var cd *content.ContentData
+		for _, l := range locales {
+			if cd = e.Locales[l]; cd != nil {
+				break
+			}
+		}
+		if cd != nil && matchFilter(cd, q.Filter) {
+			matches = append(matches, cd)
+		}
+	}
+
+	if len(q.Order) > 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, o := range q.Order {
+				c := compare(Value(matches[i], o.Field), Value(matches[j], o.Field))
+				if c != 0 {
+					return (c < 0) != o.Desc
+				}
+			}
+			return false
+		})
+	}
+
+	total := len(matches)
+	if q.Offset > 0 {
+		if q.Offset >= len(matches) {
+			return make([]*content.ContentData, 0), total
+		}
+		matches = matches[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+
+	return matches, total
+}
+
+// Value returns a metadata value or a field value of the content
+func Value(cd *content.ContentData, field string) interface{} {
+	switch field {
+	case "id":
+		return cd.ID
+	case "createdAt":
+		return cd.CreatedAt
+	case "createdBy":
+		return cd.CreatedBy
+	case "updatedAt":
+		return cd.UpdatedAt
+	case "updatedBy":
+		return cd.UpdatedBy
+	case "publishedAt":
+		return cd.PublishedAt
+	case "publishedBy":
+		return cd.PublishedBy
+	case "version":
+		return cd.Version
+	case "status":
+		return cd.Status
+	}
+	return cd.Fields[field]
+}
+
+// matchFilter reports whether every filtered value equals the value of the content,
+// list values match when any of their items does
+func matchFilter(cd *content.ContentData, filter map[string]string) bool {
+	for field, want := range filter {
+		if !matchValue(Value(cd, field), want) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchValue(v interface{}, want string) bool {
+	if items, ok := v.([]interface{}); ok {
+		for _, i := range items {
+			if matchValue(i, want) {
+				return true
+			}
+		}
+		return false
+	}
+	if v == nil {
+		return len(want) == 0
+	}
+	return strings.EqualFold(fmt.Sprint(v), want)
+}
+
+// compare orders numbers numerically and anything else by its string form, missing values first
+func compare(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+
+	fa, aok := number(a)
+	fb, bok := number(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,462 @@
+package index
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/moonwalker/moonbase/pkg/content"
+)
+
+const (
+	highlightPre    = "<em>"
+	highlightPost   = "</em>"
+	snippetBefore   = 5
+	snippetWords    = 20
+	snippetEllipsis = "…"
+)
+
+const (
+	FacetCollection = "collection"
+	FacetStatus     = "status"
+	FacetAuthor     = "author"
+)
+
+// SearchQuery is a full-text query, every term of the text has to match
+type SearchQuery struct {
+	Text        string
+	Locale      string
+	Collections []string
+	Status      string
+	Author      string
+	Offset      int
+	Limit       int
+}
+
+// Hit is an entry locale matching a search
+type Hit struct {
+	Collection string               `json:"collection"`
+	ID         string               `json:"id"`
+	Locale     string               `json:"locale"`
+	Score      float64              `json:"score"`
+	Highlights map[string]string    `json:"highlights,omitempty"`
+	Content    *content.ContentData `json:"content"`
+}
+
+// Facets counts the matches by collection, status and author
+type Facets map[string]map[string]int
+
+type SearchResult struct {
+	Hits   []*Hit `json:"hits"`
+	Total  int    `json:"total"`
+	Facets Facets `json:"facets"`
+}
+
+// searchIndex is an inverted index of the entries of an index at a commit
+type searchIndex struct {
+	commit   string
+	locales  []string
+	docs     []*document
+	postings map[string][]int
+}
+
+type document struct {
+	collection string
+	id         string
+	locale     string
+	content    *content.ContentData
+	terms      map[string]int
+	length     int
+}
+
+type searchState struct {
+	mu    sync.Mutex
+	index *searchIndex
+}
+
+// Search runs a full-text query over the text fields of the entries
+func (idx *Index) Search(q SearchQuery) *SearchResult {
+	si := idx.searchIndex()
+
+	res := &SearchResult{Hits: make([]*Hit, 0), Facets: Facets{FacetCollection: {}, FacetStatus: {}, FacetAuthor: {}}}
+
+	// the query is analyzed like the documents of each locale it runs against
+	locales := si.locales
+	if len(q.Locale) > 0 {
+		locales = []string{q.Locale}
+	}
+
+	terms := make(map[string][]string)
+	for _, l := range locales {
+		lt := Analyze(q.Text, l)
+		if len(lt) == 0 {
+			continue
+		}
+		terms[l] = lt
+		for _, d := range si.match(lt) {
+			if d.locale != l || !q.matches(d) {
+				continue
+			}
+			res.Hits = append(res.Hits, &Hit{
+				Collection: d.collection,
+				ID:         d.id,
+				Locale:     d.locale,
+				Score:      si.score(d, lt),
+				Content:    d.content,
+			})
+			res.Facets.add(FacetCollection, d.collection)
+			res.Facets.add(FacetStatus, d.content.Status)
+			res.Facets.add(FacetAuthor, author(d.content))
+		}
+	}
+
+	sort.SliceStable(res.Hits, func(i, j int) bool { return res.Hits[i].Score > res.Hits[j].Score })
+
+	res.Total = len(res.Hits)
+	if q.Offset > 0 {
+		if q.Offset >= len(res.Hits) {
+			res.Hits = make([]*Hit, 0)
+		} else {
+			res.Hits = res.Hits[q.Offset:]
+		}
+	}
+	if q.Limit > 0 && q.Limit < len(res.Hits) {
+		res.Hits = res.Hits[:q.Limit]
+	}
+
+	// only the returned page gets highlighted
+	for _, h := range res.Hits {
+		h.Highlights = highlights(h.Content, h.Locale, terms[h.Locale])
+	}
+
+	return res
+}
+
+// searchIndex returns the inverted index of the current commit, building it when the commit changed
+func (idx *Index) searchIndex() *searchIndex {
+	idx.search.mu.Lock()
+	defer idx.search.mu.Unlock()
+
+	idx.mu.RLock()
+	commit := idx.Commit
+	collections := make([]string, 0, len(idx.Entries))
+	for c := range idx.Entries {
+		collections = append(collections, c)
+	}
+	idx.mu.RUnlock()
+
+	if idx.search.index != nil && idx.search.index.commit == commit {
+		return idx.search.index
+	}
+
+	si := &searchIndex{commit: commit, postings: make(map[string][]int)}
+	sort.Strings(collections)
+	for _, c := range collections {
+		for _, e := range idx.CollectionEntries(c) {
+			for l, cd := range e.Locales {
+				si.add(c, e.ID, l, cd)
+			}
+		}
+	}
+	sort.Strings(si.locales)
+	idx.search.index = si
+
+	return si
+}
+
+func (si *searchIndex) add(collection, id, locale string, cd *content.ContentData) {
+	d := &document{collection: collection, id: id, locale: locale, content: cd, terms: make(map[string]int)}
+	for _, t := range Analyze(id, locale) {
+		d.terms[t]++
+		d.length++
+	}
+	for _, v := range cd.Fields {
+		for _, s := range texts(v) {
+			for _, t := range Analyze(s, locale) {
+				d.terms[t]++
+				d.length++
+			}
+		}
+	}
+
+	if !containsString(si.locales, locale) {
+		si.locales = append(si.locales, locale)
+	}
+
+	n := len(si.docs)
+	si.docs = append(si.docs, d)
+	for t := range d.terms {
+		si.postings[t] = append(si.postings[t], n)
+	}
+}
+
+// match returns the documents containing every term, the last term also matches as a prefix
+func (si *searchIndex) match(terms []string) []*document {
+	var docs map[int]bool
+	for i, t := range terms {
+		found := make(map[int]bool)
+		for _, n := range si.postings[t] {
+			found[n] = true
+		}
+		if i == len(terms)-1 {
+			for pt, ns := range si.postings {
+				if strings.HasPrefix(pt, t) {
+					for _, n := range ns {
+						found[n] = true
+					}
+				}
+			}
+		}
+		if docs != nil {
+			for n := range docs {
+				if !found[n] {
+					delete(docs, n)
+				}
+			}
+		} else {
+			docs = found
+		}
+	}
+
+	ns := make([]int, 0, len(docs))
+	for n := range docs {
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+
+	res := make([]*document, 0, len(ns))
+	for _, n := range ns {
+		res = append(res, si.docs[n])
+	}
+	return res
+}
+
+// score is tf-idf normalised by the length of the document
+func (si *searchIndex) score(d *document, terms []string) float64 {
+	score := 0.0
+	for _, t := range terms {
+		tf := d.terms[t]
+		if tf == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(si.docs))/float64(len(si.postings[t])))
+		score += float64(tf) * idf
+	}
+	if d.length > 0 {
+		score /= math.Sqrt(float64(d.length))
+	}
+	return score
+}
+
+func (q SearchQuery) matches(d *document) bool {
+	if len(q.Collections) > 0 && !containsString(q.Collections, d.collection) {
+		return false
+	}
+	if len(q.Status) > 0 && !strings.EqualFold(d.content.Status, q.Status) {
+		return false
+	}
+	if len(q.Author) > 0 && !strings.EqualFold(author(d.content), q.Author) {
+		return false
+	}
+	return true
+}
+
+func (f Facets) add(facet, value string) {
+	if len(value) > 0 {
+		f[facet][value]++
+	}
+}
+
+// author is the last editor of the content
+func author(cd *content.ContentData) string {
+	if len(cd.UpdatedBy) > 0 {
+		return cd.UpdatedBy
+	}
+	return cd.CreatedBy
+}
+
+// texts returns the strings of a field value, nested lists and objects included
+func texts(v interface{}) []string {
+	switch tv := v.(type) {
+	case string:
+		return []string{tv}
+	case []interface{}:
+		res := make([]string, 0)
+		for _, i := range tv {
+			res = append(res, texts(i)...)
+		}
+		return res
+	case map[string]interface{}:
+		res := make([]string, 0)
+		for _, i := range tv {
+			res = append(res, texts(i)...)
+		}
+		return res
+	}
+	return nil
+}
+
+// highlights returns a snippet of each text field containing a term, with the matching words marked
+func highlights(cd *content.ContentData, locale string, terms []string) map[string]string {
+	res := make(map[string]string)
+	for field, v := range cd.Fields {
+		for _, s := range texts(v) {
+			if h, ok := highlight(s, locale, terms); ok {
+				res[field] = h
+				break
+			}
+		}
+	}
+	return res
+}
+
+func highlight(s string, locale string, terms []string) (string, bool) {
+	spans := words(s)
+
+	first := -1
+	marked := make([]bool, len(spans))
+	for i, sp := range spans {
+		for _, t := range Analyze(s[sp[0]:sp[1]], locale) {
+			if matchesTerm(t, terms) {
+				marked[i] = true
+			}
+		}
+		if marked[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	from := first - snippetBefore
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(spans) {
+		to = len(spans)
+	}
+
+	sb := strings.Builder{}
+	if from > 0 {
+		sb.WriteString(snippetEllipsis)
+	}
+	pos := spans[from][0]
+	for i := from; i < to; i++ {
+		sb.WriteString(s[pos:spans[i][0]])
+		if marked[i] {
+			sb.WriteString(highlightPre + s[spans[i][0]:spans[i][1]] + highlightPost)
+		} else {
+			sb.WriteString(s[spans[i][0]:spans[i][1]])
+		}
+		pos = spans[i][1]
+	}
+	if to < len(spans) {
+		sb.WriteString(snippetEllipsis)
+	}
+
+	return sb.String(), true
+}
+
+func matchesTerm(t string, terms []string) bool {
+	for i, qt := range terms {
+		if t == qt || (i == len(terms)-1 && strings.HasPrefix(t, qt)) {
+			return true
+		}
+	}
+	return false
+}
+
+// words returns the byte offsets of the words of s
+func words(s string) [][2]int {
+	res := make([][2]int, 0)
+	start := -1
+	for i, r := range s {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			res = append(res, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		res = append(res, [2]int{start, len(s)})
+	}
+	return res
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func containsString(items []string, s string) bool {
+	for _, i := range items {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Analyze splits text into lower case terms without diacritics, stemmed by the language of the locale
+func Analyze(s string, locale string) []string {
+	stem := stemmer(locale)
+	terms := make([]string, 0)
+	for _, sp := range words(s) {
+		t := fold(s[sp[0]:sp[1]])
+		if len(t) > 0 {
+			terms = append(terms, stem(t))
+		}
+	}
+	return terms
+}
+
+var foldings = map[rune]string{'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ı': "i"}
+
+// fold lower cases a word and removes its diacritics
+func fold(s string) string {
+	sb := strings.Builder{}
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if f, ok := foldings[r]; ok {
+			sb.WriteString(f)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// suffixes stripped by the light stemmers, longest first
+var suffixes = map[string][]string{
+	"en": {"ations", "ation", "ness", "ings", "ing", "ies", "ied", "ers", "ed", "er", "ly", "es", "s"},
+	"de": {"ungen", "heit", "keit", "ung", "ern", "em", "en", "er", "es", "e", "n", "s"},
+	"fr": {"ements", "ement", "euses", "euse", "eaux", "eux", "es", "s", "e"},
+	"es": {"amientos", "amiento", "aciones", "acion", "mente", "es", "os", "as", "s", "o", "a"},
+}
+
+const minStemLength = 3
+
+func stemmer(locale string) func(string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	list := suffixes[lang]
+
+	return func(t string) string {
+		for _, sfx := range list {
+			if strings.HasSuffix(t, sfx) && len([]rune(t))-len([]rune(sfx)) >= minStemLength {
+				return strings.TrimSuffix(t, sfx)
+			}
+		}
+		return t
+	}
+}
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	if terms := Analyze("Crème Brûlée, Straße!", "fr"); !reflect.DeepEqual(terms, []string{"crem", "brule", "strass"}) {
+		t.Errorf("unexpected terms: %v", terms)
+	}
+	if terms := Analyze("Running tests", "en-GB"); !reflect.DeepEqual(terms, []string{"runn", "test"}) {
+		t.Errorf("unexpected terms: %v", terms)
+	}
+	if terms := Analyze("Häuser", "de"); !reflect.DeepEqual(terms, []string{"haus"}) {
+		t.Errorf("unexpected terms: %v", terms)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	src := &fakeSource{commit: "c1", files: map[string]map[string]string{
+		"c1": {
+			"content/posts/a/en.json":    `{"id":"a","status":"published","updatedBy":"alice","fields":{"title":"Running the café","body":"A long story about coffee"}}`,
+			"content/posts/a/de.json":    `{"id":"a","status":"published","updatedBy":"alice","fields":{"title":"Das Café am Markt"}}`,
+			"content/posts/b/en.json":    `{"id":"b","status":"draft","createdBy":"bob","fields":{"title":"Coffee runs","tags":["cafe"]}}`,
+			"content/pages/c/en.json":    `{"id":"c","fields":{"title":"About us"}}`,
+			"content/pages/_schema.json": `{"id":"pages"}`,
+		},
+	}}
+
+	idx := New("owner", "repo", "main", "content")
+	if err := idx.Build(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	res := idx.Search(SearchQuery{Text: "cafe"})
+	if res.Total != 3 {
+		t.Fatalf("expected 3 hits, got %d", res.Total)
+	}
+	if res.Facets[FacetCollection]["posts"] != 3 || res.Facets[FacetStatus]["draft"] != 1 || res.Facets[FacetAuthor]["alice"] != 2 {
+		t.Errorf("unexpected facets: %v", res.Facets)
+	}
+
+	res = idx.Search(SearchQuery{Text: "cafe", Locale: "en", Status: "published"})
+	if res.Total != 1 || res.Hits[0].ID != "a" {
+		t.Fatalf("unexpected hits: %d", res.Total)
+	}
+	if h := res.Hits[0].Highlights["title"]; !strings.Contains(h, "<em>café</em>") {
+		t.Errorf("unexpected highlight: %s", h)
+	}
+
+	if res = idx.Search(SearchQuery{Text: "coffee ru", Locale: "en"}); res.Total != 2 {
+		t.Errorf("unexpected hits for prefix search: %d", res.Total)
+	}
+	if res = idx.Search(SearchQuery{Text: "story coffee", Locale: "en"}); res.Total != 1 || res.Hits[0].ID != "a" {
+		t.Errorf("unexpected hits: %d", res.Total)
+	}
+
+	if res = idx.Search(SearchQuery{Text: "about", Collections: []string{"posts"}}); res.Total != 1 {
+		t.Errorf("collection filter not applied: %d", res.Total)
+	}
+}
@@ -15,6 +15,11 @@ type AuthClaims struct {
 }
 
 func EncryptAndSign(encKey, sigKey []byte, data []byte, expires time.Duration) (string, error) {
+	return EncryptAndSignWithID(encKey, sigKey, data, "", expires)
+}
+
+// EncryptAndSignWithID is EncryptAndSign with the id claim set, to tie the token to a session
+func EncryptAndSignWithID(encKey, sigKey []byte, data []byte, id string, expires time.Duration) (string, error) {
 	encData, err := jwe.Encrypt(encKey, data)
 	if err != nil {
 		return "", err
@@ -23,6 +28,7 @@ func EncryptAndSign(encKey, sigKey []byte, data []byte, expires time.Duration) (
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, &AuthClaims{
 		Data: encData,
 		StandardClaims: jwt.StandardClaims{
+			Id:        id,
 			IssuedAt:  time.Now().Unix(),
 			NotBefore: time.Now().Unix(),
 			ExpiresAt: time.Now().Add(expires).Unix(),
@@ -51,3 +57,21 @@ func VerifyAndDecrypt(encKey, sigKey []byte, tokenString string) (*jwt.Token, er
 
 	return token, nil
 }
+
+// SignApp returns the RS256 signed token a github app authenticates with, backdated
+// a minute to allow for clock drift
+func SignApp(pemKey []byte, appID string, expires time.Duration) (string, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.StandardClaims{
+		Issuer:    appID,
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(expires).Unix(),
+	})
+
+	return token.SignedString(key)
+}
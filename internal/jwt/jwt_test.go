@@ -1,9 +1,16 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
 )
 
 var (
@@ -48,3 +55,27 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSignApp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	ts, err := SignApp(pemKey, "42", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &jwt.StandardClaims{}
+	_, err = jwt.ParseWithClaims(ts, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "42" {
+		t.Errorf("unexpected issuer: %s", claims.Issuer)
+	}
+}
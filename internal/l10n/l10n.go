@@ -0,0 +1,106 @@
+package l10n
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// exchange formats of translation catalogs
+const (
+	FormatXLIFF = "xliff"
+	FormatPO    = "po"
+)
+
+// Unit is a text translated from the source locale, identified by the collection,
+// entry and field it belongs to
+type Unit struct {
+	Collection string
+	Entry      string
+	Field      string
+	Source     string
+	Target     string
+	Notes      []string
+	// Fuzzy targets are drafts or need review, they are not imported
+	Fuzzy bool
+}
+
+// Key is the context of the unit in the catalog
+func (u *Unit) Key() string {
+	return u.Collection + "/" + u.Entry + "/" + u.Field
+}
+
+func (u *Unit) setKey(key string) error {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return fmt.Errorf("invalid unit context: %q", key)
+	}
+	u.Collection, u.Entry, u.Field = parts[0], parts[1], parts[2]
+	return nil
+}
+
+// Catalog is the set of units translated from the source to the target locale
+type Catalog struct {
+	SourceLocale string
+	TargetLocale string
+	Units        []*Unit
+}
+
+// Valid reports whether the format is a known one
+func Valid(format string) bool {
+	return format == FormatXLIFF || format == FormatPO
+}
+
+// Detect returns the format of catalog data, xliff being xml
+func Detect(data []byte) string {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "<") {
+		return FormatXLIFF
+	}
+	return FormatPO
+}
+
+// ContentType returns the media type of the format
+func ContentType(format string) string {
+	if format == FormatPO {
+		return "text/x-gettext-translation; charset=utf-8"
+	}
+	return "application/xliff+xml; charset=utf-8"
+}
+
+// Extension returns the file extension of the format
+func Extension(format string) string {
+	if format == FormatPO {
+		return ".po"
+	}
+	return ".xlf"
+}
+
+// Write encodes the catalog in the format
+func Write(w io.Writer, format string, c *Catalog) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case FormatXLIFF:
+		err = writeXLIFF(bw, c)
+	case FormatPO:
+		err = writePO(bw, c)
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Read decodes a catalog of the format
+func Read(r io.Reader, format string) (*Catalog, error) {
+	switch format {
+	case FormatXLIFF:
+		return readXLIFF(r)
+	case FormatPO:
+		return readPO(r)
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
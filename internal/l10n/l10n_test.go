@@ -0,0 +1,106 @@
+package l10n
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testCatalog = &Catalog{SourceLocale: "en", TargetLocale: "de", Units: []*Unit{
+	{Collection: "posts", Entry: "a", Field: "title", Source: "Hello", Target: "Hallo", Notes: []string{"field: Title"}},
+	{Collection: "posts", Entry: "a", Field: "body", Source: "Line \"one\"\nLine two\n", Notes: []string{"field: Body", "status: review"}},
+	{Collection: "pages", Entry: "home", Field: "title", Source: "Home & <away>", Target: "Zuhause", Fuzzy: true},
+}}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatXLIFF, FormatPO} {
+		buf := &bytes.Buffer{}
+		if err := Write(buf, format, testCatalog); err != nil {
+			t.Fatal(err)
+		}
+		if Detect(buf.Bytes()) != format {
+			t.Errorf("%s not detected", format)
+		}
+
+		c, err := Read(buf, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !reflect.DeepEqual(c, testCatalog) {
+			t.Errorf("%s: unexpected catalog %+v", format, c)
+		}
+	}
+}
+
+func TestReadPO(t *testing.T) {
+	data := `# translator comment
+msgid ""
+msgstr ""
+"Language: fr\n"
+
+#, fuzzy, c-format
+msgctxt "posts/a/title"
+msgid "Hello"
+msgstr "Bonjour"
+
+msgctxt "posts/a/body"
+msgid ""
+"Multi "
+"line"
+msgstr ""
+"Multi"
+"ligne"
+msgctxt "posts/b/items"
+msgid "item"
+msgid_plural "items"
+msgstr[0] "article"
+msgstr[1] "articles"
+
+#~ msgctxt "posts/c/title"
+#~ msgid "Gone"
+#~ msgstr "Parti"
+`
+	c, err := Read(strings.NewReader(data), FormatPO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TargetLocale != "fr" || len(c.Units) != 2 {
+		t.Fatalf("unexpected catalog %+v", c)
+	}
+	if u := c.Units[0]; !u.Fuzzy || u.Target != "Bonjour" || u.Key() != "posts/a/title" {
+		t.Errorf("unexpected unit %+v", u)
+	}
+	if u := c.Units[1]; u.Fuzzy || u.Source != "Multi line" || u.Target != "Multiligne" {
+		t.Errorf("unexpected unit %+v", u)
+	}
+
+	if _, err := Read(strings.NewReader("msgid \"x\"\nmsgstr \"y\"\n"), FormatPO); err == nil {
+		t.Error("expected an error for an entry without context")
+	}
+}
+
+func TestReadXLIFF(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="en" trgLang="de">
+  <file id="f1">
+    <unit id="u1" name="posts/a/title">
+      <segment state="final"><source>Hello</source><target>Hallo</target></segment>
+    </unit>
+    <unit id="u2" name="posts/a/body">
+      <segment><source>Text</source></segment>
+    </unit>
+  </file>
+</xliff>`
+	c, err := Read(strings.NewReader(data), FormatXLIFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Units) != 2 || c.Units[0].Target != "Hallo" || c.Units[0].Fuzzy || c.Units[1].Target != "" {
+		t.Errorf("unexpected catalog %+v", c)
+	}
+
+	if _, err := Read(strings.NewReader(strings.Replace(data, `version="2.0"`, `version="1.2"`, 1)), FormatXLIFF); err == nil {
+		t.Error("expected an error for xliff 1.2")
+	}
+}
@@ -0,0 +1,186 @@
+package l10n
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	poFuzzy          = "fuzzy"
+	poLanguage       = "Language"
+	poSourceLanguage = "X-Source-Language"
+)
+
+// writePO writes a header carrying the locales and an entry per unit, units are told
+// apart by their context as the same source text may appear in many fields
+func writePO(w io.Writer, c *Catalog) error {
+	b := &strings.Builder{}
+	b.WriteString("msgid \"\"\n")
+	writePOString(b, "msgstr", strings.Join([]string{
+		poLanguage + ": " + c.TargetLocale,
+		poSourceLanguage + ": " + c.SourceLocale,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: 8bit",
+	}, "\n")+"\n")
+
+	for _, u := range c.Units {
+		b.WriteString("\n")
+		for _, n := range u.Notes {
+			for _, l := range strings.Split(n, "\n") {
+				b.WriteString("#. " + l + "\n")
+			}
+		}
+		b.WriteString("#: " + u.Key() + "\n")
+		if u.Fuzzy {
+			b.WriteString("#, " + poFuzzy + "\n")
+		}
+		writePOString(b, "msgctxt", u.Key())
+		writePOString(b, "msgid", u.Source)
+		writePOString(b, "msgstr", u.Target)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writePOString writes a keyword with its quoted string, multi-line strings are split after newlines
+func writePOString(b *strings.Builder, keyword string, s string) {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 1 {
+		b.WriteString(keyword + " " + quotePO(s) + "\n")
+		return
+	}
+	b.WriteString(keyword + " \"\"\n")
+	for _, l := range lines {
+		b.WriteString(quotePO(l) + "\n")
+	}
+}
+
+func quotePO(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// poEntry is an entry of a po file being read
+type poEntry struct {
+	ctx, id, str *string
+	fuzzy        bool
+	plural       bool
+	comments     []string
+}
+
+// readPO reads the entries of a po file, obsolete and plural entries are skipped
+func readPO(r io.Reader) (*Catalog, error) {
+	c := &Catalog{Units: make([]*Unit, 0)}
+
+	entry := &poEntry{}
+	var last **string
+	flush := func() error {
+		defer func() { entry, last = &poEntry{}, nil }()
+		if entry.id == nil || entry.plural {
+			return nil
+		}
+		// the header is the entry of the empty id
+		if len(*entry.id) == 0 && entry.ctx == nil {
+			if entry.str != nil {
+				for _, l := range strings.Split(*entry.str, "\n") {
+					k, v, _ := strings.Cut(l, ":")
+					switch strings.TrimSpace(k) {
+					case poLanguage:
+						c.TargetLocale = strings.TrimSpace(v)
+					case poSourceLanguage:
+						c.SourceLocale = strings.TrimSpace(v)
+					}
+				}
+			}
+			return nil
+		}
+		if entry.ctx == nil {
+			return fmt.Errorf("entry without context: %q", *entry.id)
+		}
+
+		u := &Unit{Source: *entry.id, Fuzzy: entry.fuzzy, Notes: entry.comments}
+		if entry.str != nil {
+			u.Target = *entry.str
+		}
+		if err := u.setKey(*entry.ctx); err != nil {
+			return err
+		}
+		c.Units = append(c.Units, u)
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		var err error
+		switch {
+		case len(line) == 0:
+			err = flush()
+		case strings.HasPrefix(line, "#~"):
+			// obsolete entries are not part of the catalog
+		case strings.HasPrefix(line, "#,"):
+			for _, f := range strings.Split(line[2:], ",") {
+				if strings.TrimSpace(f) == poFuzzy {
+					entry.fuzzy = true
+				}
+			}
+		case strings.HasPrefix(line, "#."):
+			entry.comments = append(entry.comments, strings.TrimSpace(line[2:]))
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, `"`):
+			if last == nil || *last == nil {
+				return nil, fmt.Errorf("line %d: string without keyword", n)
+			}
+			var s string
+			s, err = strconv.Unquote(line)
+			**last += s
+		default:
+			keyword, value, _ := strings.Cut(line, " ")
+			// a keyword following a complete entry starts the next one
+			if entry.str != nil && (keyword == "msgctxt" || keyword == "msgid") {
+				if err := flush(); err != nil {
+					return nil, fmt.Errorf("line %d: %s", n, err)
+				}
+			}
+			switch {
+			case keyword == "msgctxt":
+				last = &entry.ctx
+			case keyword == "msgid":
+				last = &entry.id
+			case keyword == "msgid_plural":
+				entry.plural = true
+				last = new(*string)
+			case keyword == "msgstr":
+				last = &entry.str
+			case strings.HasPrefix(keyword, "msgstr["):
+				last = new(*string)
+			default:
+				return nil, fmt.Errorf("line %d: unknown keyword %q", n, keyword)
+			}
+			var s string
+			s, err = strconv.Unquote(strings.TrimSpace(value))
+			*last = &s
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,136 @@
+package l10n
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const (
+	xliffNamespace = "urn:oasis:names:tc:xliff:document:2.0"
+	xliffVersion   = "2.0"
+
+	xliffStateInitial    = "initial"
+	xliffStateTranslated = "translated"
+
+	xliffNoteContext = "context"
+)
+
+type xliffDoc struct {
+	XMLName xml.Name     `xml:"urn:oasis:names:tc:xliff:document:2.0 xliff"`
+	Version string       `xml:"version,attr"`
+	SrcLang string       `xml:"srcLang,attr"`
+	TrgLang string       `xml:"trgLang,attr,omitempty"`
+	Files   []*xliffFile `xml:"file"`
+}
+
+type xliffFile struct {
+	ID       string       `xml:"id,attr"`
+	Original string       `xml:"original,attr,omitempty"`
+	Units    []*xliffUnit `xml:"unit"`
+}
+
+type xliffUnit struct {
+	ID      string       `xml:"id,attr"`
+	Name    string       `xml:"name,attr,omitempty"`
+	Notes   *xliffNotes  `xml:"notes,omitempty"`
+	Segment xliffSegment `xml:"segment"`
+}
+
+type xliffNotes struct {
+	Notes []*xliffNote `xml:"note"`
+}
+
+type xliffNote struct {
+	Category string `xml:"category,attr,omitempty"`
+	Text     string `xml:",chardata"`
+}
+
+type xliffSegment struct {
+	State  string  `xml:"state,attr,omitempty"`
+	Source string  `xml:"source"`
+	Target *string `xml:"target"`
+}
+
+// writeXLIFF writes a file per collection, units are named by their context which is
+// repeated as a note, as unit ids are restricted to name tokens
+func writeXLIFF(w io.Writer, c *Catalog) error {
+	doc := &xliffDoc{Version: xliffVersion, SrcLang: c.SourceLocale, TrgLang: c.TargetLocale}
+
+	files := make(map[string]*xliffFile)
+	for _, u := range c.Units {
+		f := files[u.Collection]
+		if f == nil {
+			f = &xliffFile{ID: fmt.Sprintf("f%d", len(doc.Files)+1), Original: u.Collection}
+			files[u.Collection] = f
+			doc.Files = append(doc.Files, f)
+		}
+
+		xu := &xliffUnit{
+			ID:      fmt.Sprintf("u%d", len(f.Units)+1),
+			Name:    u.Key(),
+			Notes:   &xliffNotes{Notes: []*xliffNote{{Category: xliffNoteContext, Text: u.Key()}}},
+			Segment: xliffSegment{Source: u.Source},
+		}
+		for _, n := range u.Notes {
+			xu.Notes.Notes = append(xu.Notes.Notes, &xliffNote{Text: n})
+		}
+		if len(u.Target) > 0 {
+			target := u.Target
+			xu.Segment.Target = &target
+			xu.Segment.State = xliffStateTranslated
+			if u.Fuzzy {
+				xu.Segment.State = xliffStateInitial
+			}
+		}
+		f.Units = append(f.Units, xu)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// readXLIFF reads the units of every file, targets left in the initial state are fuzzy
+func readXLIFF(r io.Reader) (*Catalog, error) {
+	doc := &xliffDoc{}
+	if err := xml.NewDecoder(r).Decode(doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != xliffVersion {
+		return nil, fmt.Errorf("unsupported xliff version: %s", doc.Version)
+	}
+
+	c := &Catalog{SourceLocale: doc.SrcLang, TargetLocale: doc.TrgLang, Units: make([]*Unit, 0)}
+	for _, f := range doc.Files {
+		for _, xu := range f.Units {
+			u := &Unit{Source: xu.Segment.Source, Fuzzy: xu.Segment.State == xliffStateInitial}
+			if xu.Segment.Target != nil {
+				u.Target = *xu.Segment.Target
+			}
+
+			key := xu.Name
+			if xu.Notes != nil {
+				for _, n := range xu.Notes.Notes {
+					if n.Category == xliffNoteContext {
+						key = n.Text
+					} else {
+						u.Notes = append(u.Notes, n.Text)
+					}
+				}
+			}
+			if err := u.setKey(key); err != nil {
+				return nil, fmt.Errorf("unit %s of file %s: %s", xu.ID, f.ID, err)
+			}
+			c.Units = append(c.Units, u)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,315 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+
+	"github.com/moonwalker/moonbase/internal/env"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+var (
+	ErrNotConfigured = errors.New("oidc: login not configured")
+	ErrNonce         = errors.New("oidc: nonce mismatch")
+	ErrAudience      = errors.New("oidc: token not issued for this client")
+	ErrIssuer        = errors.New("oidc: token not issued by the provider")
+	ErrEmail         = errors.New("oidc: email missing or not verified")
+	ErrDomain        = errors.New("oidc: email domain not allowed")
+)
+
+// Config of an openid connect provider
+type Config struct {
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	GroupsClaim    string
+	AllowedDomains []string
+}
+
+// Identity is the verified user of an id token
+type Identity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Name    string   `json:"name"`
+	Picture string   `json:"picture,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// Provider logs users in with the authorization code flow and verifies their id tokens
+type Provider struct {
+	config Config
+	oauth  *oauth2.Config
+	client *http.Client
+	meta   discovery
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+var shared struct {
+	sync.Mutex
+	provider *Provider
+}
+
+// Enabled reports whether an oidc provider is configured
+func Enabled() bool {
+	return len(env.OIDCIssuer) > 0 && len(env.OIDCClientID) > 0
+}
+
+// Default returns the provider configured by the environment, discovery is retried until it succeeds
+func Default(ctx context.Context) (*Provider, error) {
+	if !Enabled() {
+		return nil, ErrNotConfigured
+	}
+
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.provider != nil {
+		return shared.provider, nil
+	}
+
+	p, err := New(ctx, Config{
+		Issuer:         env.OIDCIssuer,
+		ClientID:       env.OIDCClientID,
+		ClientSecret:   env.OIDCClientSecret,
+		RedirectURL:    env.OIDCRedirectURL,
+		GroupsClaim:    env.OIDCGroupsClaim,
+		AllowedDomains: env.OIDCAllowedDomains,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shared.provider = p
+	return p, nil
+}
+
+// New reads the configuration the issuer publishes
+func New(ctx context.Context, c Config) (*Provider, error) {
+	p := &Provider{config: c, client: http.DefaultClient, keys: make(map[string]*rsa.PublicKey)}
+
+	err := p.getJSON(ctx, strings.TrimSuffix(c.Issuer, "/")+discoveryPath, &p.meta)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.meta.Issuer, "/") != strings.TrimSuffix(c.Issuer, "/") {
+		return nil, fmt.Errorf("oidc: issuer %s does not match %s", p.meta.Issuer, c.Issuer)
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.meta.AuthorizationEndpoint,
+			TokenURL: p.meta.TokenEndpoint,
+		},
+	}
+
+	return p, nil
+}
+
+// AuthCodeURL returns the login page of the provider, the nonce ends up in the id token
+func (p *Provider) AuthCodeURL(state string, nonce string) string {
+	return p.oauth.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+}
+
+// Exchange redeems the authorization code and returns the identity of its id token
+func (p *Provider) Exchange(ctx context.Context, code string, nonce string) (*Identity, error) {
+	t, err := p.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := t.Extra("id_token").(string)
+	if !ok || len(raw) == 0 {
+		return nil, errors.New("oidc: id token missing")
+	}
+
+	return p.Verify(ctx, raw, nonce)
+}
+
+// Verify checks signature, issuer, audience, expiry and nonce of an id token
+func (p *Provider) Verify(ctx context.Context, raw string, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("oidc: unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(p.meta.Issuer, "/") {
+		return nil, ErrIssuer
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("oidc: token does not expire")
+	}
+	if !containsAudience(claims["aud"], p.config.ClientID) {
+		return nil, ErrAudience
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, ErrNonce
+	}
+
+	id := &Identity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	id.Picture, _ = claims["picture"].(string)
+	id.Groups = stringList(claims[p.groupsClaim()])
+
+	// providers which do not verify addresses say so, others leave the claim out
+	if verified, ok := claims["email_verified"].(bool); len(id.Email) == 0 || (ok && !verified) {
+		return nil, ErrEmail
+	}
+	id.Email = strings.ToLower(id.Email)
+	if !p.domainAllowed(id.Email) {
+		return nil, ErrDomain
+	}
+	if len(id.Name) == 0 {
+		id.Name = id.Email
+	}
+
+	return id, nil
+}
+
+func (p *Provider) groupsClaim() string {
+	if len(p.config.GroupsClaim) > 0 {
+		return p.config.GroupsClaim
+	}
+	return "groups"
+}
+
+func (p *Provider) domainAllowed(email string) bool {
+	if len(p.config.AllowedDomains) == 0 {
+		return true
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, d := range p.config.AllowedDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the signing key by id, keys are fetched again when the
+// provider rotated them
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	set := &jwks{}
+	err := p.getJSON(ctx, p.meta.JwksURI, set)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// the audience is either a single client or a list of them
+func containsAudience(aud interface{}, clientID string) bool {
+	for _, a := range stringList(aud) {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func stringList(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		res := make([]string, 0, len(t))
+		for _, i := range t {
+			if s, ok := i.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
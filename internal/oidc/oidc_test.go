@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// issuer is a local stand-in for an openid connect provider, the authorization
+// code is the claims of the id token it exchanges to
+type issuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &issuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.URL,
+			"authorization_endpoint": iss.URL + "/authorize",
+			"token_endpoint":         iss.URL + "/token",
+			"jwks_uri":               iss.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		claims := jwt.MapClaims{}
+		json.Unmarshal([]byte(r.Form.Get("code")), &claims)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     iss.sign(t, claims),
+		})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+
+	return iss
+}
+
+func (iss *issuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(iss.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (iss *issuer) code(claims jwt.MapClaims) string {
+	c := jwt.MapClaims{
+		"iss":   iss.URL,
+		"sub":   "u1",
+		"aud":   "moonbase",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "n1",
+		"email": "Jane@Example.com",
+		"name":  "Jane",
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+func TestExchange(t *testing.T) {
+	iss := newIssuer(t)
+	ctx := context.Background()
+
+	p, err := New(ctx, Config{Issuer: iss.URL, ClientID: "moonbase", AllowedDomains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := p.Exchange(ctx, iss.code(jwt.MapClaims{"groups": []string{"translators"}}), "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Email != "jane@example.com" || id.Name != "Jane" || len(id.Groups) != 1 || id.Groups[0] != "translators" {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    error
+	}{
+		{"nonce", jwt.MapClaims{"nonce": "n2"}, ErrNonce},
+		{"audience", jwt.MapClaims{"aud": []string{"other"}}, ErrAudience},
+		{"issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, ErrIssuer},
+		{"unverified", jwt.MapClaims{"email_verified": false}, ErrEmail},
+		{"domain", jwt.MapClaims{"email": "jane@other.com"}, ErrDomain},
+	}
+	for _, tt := range tests {
+		if _, err := p.Exchange(ctx, iss.code(tt.claims), "n1"); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := p.Exchange(ctx, iss.code(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), "n1"); err == nil {
+		t.Error("accepted expired token")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	iss := newIssuer(t)
+	other := newIssuer(t)
+	ctx := context.Background()
+
+	p, err := New(ctx, Config{Issuer: iss.URL, ClientID: "moonbase"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	json.Unmarshal([]byte(iss.code(nil)), &claims)
+	if _, err = p.Verify(ctx, iss.sign(t, claims), "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Verify(ctx, other.sign(t, claims), "n1"); err == nil {
+		t.Error("accepted token signed by another key")
+	}
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/env"
+)
+
+const (
+	headerLimit     = "X-RateLimit-Limit"
+	headerRemaining = "X-RateLimit-Remaining"
+	headerUsed      = "X-RateLimit-Used"
+	headerReset     = "X-RateLimit-Reset"
+	headerResource  = "X-RateLimit-Resource"
+
+	// ResourceCore is the budget of the rest api, the one requests are checked against
+	ResourceCore = "core"
+)
+
+// GitHub tracks the rate limits github reports for the tokens moonbase calls it with
+var GitHub = NewBudget(env.GithubRateReserve)
+
+// Quota is the last known rate limit of a token on one github resource
+type Quota struct {
+	Token     string    `json:"token"`
+	User      string    `json:"user,omitempty"`
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Used      int       `json:"used"`
+	Reset     time.Time `json:"reset"`
+	Requests  int64     `json:"requests"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Budget keeps the quotas of tokens, tokens are only kept as a hash
+type Budget struct {
+	reserve int
+
+	mu     sync.Mutex
+	quotas map[string]*Quota
+	now    func() time.Time
+}
+
+// NewBudget returns a budget which holds requests back when less than reserve calls are left
+func NewBudget(reserve int) *Budget {
+	return &Budget{
+		reserve: reserve,
+		quotas:  make(map[string]*Quota),
+		now:     time.Now,
+	}
+}
+
+// Reserve returns the number of calls kept back for requests already running
+func (b *Budget) Reserve() int {
+	return b.reserve
+}
+
+// Observe records the rate limit headers of a github response made with the token
+func (b *Budget) Observe(token string, user string, h http.Header) {
+	limit, err := strconv.Atoi(h.Get(headerLimit))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(h.Get(headerRemaining))
+	used, _ := strconv.Atoi(h.Get(headerUsed))
+	reset, _ := strconv.ParseInt(h.Get(headerReset), 10, 64)
+	resource := h.Get(headerResource)
+	if len(resource) == 0 {
+		resource = ResourceCore
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := tokenID(token)
+	q := b.quotas[id+":"+resource]
+	if q == nil {
+		q = &Quota{Token: id, Resource: resource}
+		b.quotas[id+":"+resource] = q
+	}
+	if len(user) > 0 {
+		q.User = user
+	}
+	q.Limit = limit
+	q.Remaining = remaining
+	q.Used = used
+	q.Reset = time.Unix(reset, 0).UTC()
+	q.Requests++
+	q.UpdatedAt = b.now().UTC()
+}
+
+// Check reports whether the token has core calls left beyond the reserve,
+// when it has not it returns how long until github resets the quota
+func (b *Budget) Check(token string) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.quotas[tokenID(token)+":"+ResourceCore]
+	if q == nil || q.Remaining > b.reserve {
+		return true, 0
+	}
+	wait := q.Reset.Sub(b.now())
+	if wait <= 0 {
+		return true, 0
+	}
+	return false, wait
+}
+
+// Quotas returns the quotas not reset yet, the ones closest to exhaustion first
+func (b *Budget) Quotas() []*Quota {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	res := make([]*Quota, 0, len(b.quotas))
+	for key, q := range b.quotas {
+		if q.Reset.Before(now) {
+			delete(b.quotas, key)
+			continue
+		}
+		c := *q
+		res = append(res, &c)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Remaining != res[j].Remaining {
+			return res[i].Remaining < res[j].Remaining
+		}
+		return res[i].Token+res[i].Resource < res[j].Token+res[j].Resource
+	})
+	return res
+}
+
+func tokenID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/env"
+)
+
+const (
+	// idle buckets are dropped once in a while, a dropped bucket is full anyway
+	sweepInterval = time.Minute
+)
+
+var (
+	// Users limits the requests of logged in users, keyed by login
+	Users = NewLimiter(env.RateLimitUser)
+	// APIKeys limits the requests of api keys, keyed by key id
+	APIKeys = NewLimiter(env.RateLimitAPIKey)
+)
+
+// Limiter is a token bucket per key, every key may do perMinute requests
+// in a burst and gets them back evenly over the minute, limits are per instance
+type Limiter struct {
+	perMinute int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter, a limit of zero or less allows everything
+func NewLimiter(perMinute int) *Limiter {
+	return &Limiter{
+		perMinute: perMinute,
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+// PerMinute returns the configured limit
+func (l *Limiter) PerMinute() int {
+	return l.perMinute
+}
+
+// Allow takes a request of the key, when none is left it returns how long until the next one
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	capacity := float64(l.perMinute)
+	rate := capacity / time.Minute.Seconds()
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
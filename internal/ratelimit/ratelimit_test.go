@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(60)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 60; i++ {
+		if ok, _ := l.Allow("octocat"); !ok {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	ok, wait := l.Allow("octocat")
+	if ok || wait != time.Second {
+		t.Errorf("expected rejection for a second, got %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow("hubot"); !ok {
+		t.Error("keys share a bucket")
+	}
+
+	now = now.Add(2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("octocat"); !ok {
+			t.Fatalf("refilled request %d rejected", i)
+		}
+	}
+	if ok, _ := l.Allow("octocat"); ok {
+		t.Error("bucket refilled too much")
+	}
+
+	now = now.Add(2 * time.Minute)
+	l.Allow("hubot")
+	if _, found := l.buckets["octocat"]; found {
+		t.Error("idle bucket not swept")
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(0)
+	for i := 0; i < 1000; i++ {
+		if ok, _ := l.Allow("octocat"); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBudget(100)
+	b.now = func() time.Time { return now }
+
+	if ok, _ := b.Check("gh-token"); !ok {
+		t.Error("unknown token rejected")
+	}
+
+	reset := now.Add(10 * time.Minute)
+	h := func(remaining int, resource string) http.Header {
+		h := http.Header{}
+		h.Set(headerLimit, "5000")
+		h.Set(headerRemaining, strconv.Itoa(remaining))
+		h.Set(headerUsed, strconv.Itoa(5000-remaining))
+		h.Set(headerReset, strconv.FormatInt(reset.Unix(), 10))
+		h.Set(headerResource, resource)
+		return h
+	}
+
+	b.Observe("gh-token", "octocat", h(4000, ResourceCore))
+	b.Observe("gh-token", "", h(10, "search"))
+	b.Observe("gh-token", "", http.Header{})
+	if ok, _ := b.Check("gh-token"); !ok {
+		t.Error("token with budget left rejected")
+	}
+
+	b.Observe("gh-token", "", h(100, ResourceCore))
+	ok, wait := b.Check("gh-token")
+	if ok || wait != 10*time.Minute {
+		t.Errorf("expected rejection until reset, got %v %v", ok, wait)
+	}
+	if ok, _ := b.Check("other-token"); !ok {
+		t.Error("tokens share a budget")
+	}
+
+	quotas := b.Quotas()
+	if len(quotas) != 2 || quotas[0].Resource != "search" || quotas[1].User != "octocat" || quotas[1].Requests != 2 {
+		t.Errorf("unexpected quotas %+v", quotas)
+	}
+	if quotas[0].Token == "gh-token" {
+		t.Error("token exposed")
+	}
+
+	now = reset.Add(time.Second)
+	if ok, _ := b.Check("gh-token"); !ok {
+		t.Error("token rejected after reset")
+	}
+	if len(b.Quotas()) != 0 {
+		t.Error("reset quotas kept")
+	}
+}
@@ -10,9 +10,18 @@ import (
 	"github.com/go-chi/cors"
 
 	"github.com/moonwalker/moonbase/internal/api"
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/index"
+	"github.com/moonwalker/moonbase/internal/store"
+	"github.com/moonwalker/moonbase/internal/webhooks"
 )
 
 func Listen(port int) error {
+	// api keys and sessions need the persistent store
+	if _, err := store.Open(); err != nil {
+		return err
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.StripSlashes)
@@ -25,6 +34,11 @@ func Listen(port int) error {
 
 	r.Mount("/", api.Routes())
 
+	events.Subscribe(webhooks.Default.Dispatch)
+	events.Subscribe(func(e *events.Event) {
+		index.Default.Refresh(e.Owner, e.Repo, e.Ref)
+	})
+
 	addr := fmt.Sprintf(":%d", port)
 	return http.ListenAndServe(addr, r)
 }
@@ -43,5 +57,6 @@ var corsOptions = cors.Options{
 		http.MethodDelete,
 	},
 	AllowedHeaders:   []string{"*"},
+	ExposedHeaders:   []string{"ETag", "Last-Modified"},
 	AllowCredentials: true,
 }
@@ -0,0 +1,348 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/moonwalker/moonbase/internal/cache"
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/jwe"
+	"github.com/moonwalker/moonbase/internal/store"
+)
+
+const (
+	// Prefix tells refresh tokens apart from access tokens
+	Prefix = "mbr_"
+
+	ProviderGitHub = "github"
+	ProviderOIDC   = "oidc"
+
+	// Lifetime is how long a session lives without being refreshed
+	Lifetime = 30 * 24 * time.Hour
+
+	storeKind = "sessions"
+	topic     = "sessions"
+
+	// active sessions are checked on every request, revocations reach
+	// other replicas through cache invalidation or after this at the latest
+	activeTTL = 30 * time.Second
+)
+
+var (
+	ErrInvalid = errors.New("invalid session")
+	ErrExpired = errors.New("session expired")
+	ErrRevoked = errors.New("session revoked")
+)
+
+// Session is a login of a user on a device, refreshed with a rotating refresh token.
+// Users of the oidc provider are identified by their email.
+type Session struct {
+	ID         string     `json:"id"`
+	Provider   string     `json:"provider"`
+	Login      string     `json:"login"`
+	Name       string     `json:"name,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	Image      string     `json:"image,omitempty"`
+	Groups     []string   `json:"groups,omitempty"`
+	UserAgent  string     `json:"userAgent,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// record is how a session is stored, the hash of the previous refresh token
+// is kept to detect the reuse of a rotated token
+type record struct {
+	Session
+	Hash     string `json:"hash"`
+	PrevHash string `json:"prevHash,omitempty"`
+	Token    string `json:"token"`
+}
+
+// Manager creates, refreshes and revokes sessions
+type Manager struct {
+	store  store.Store
+	mu     sync.Mutex
+	active sync.Map
+}
+
+type activeSession struct {
+	session *Session
+	until   time.Time
+}
+
+var Default = &Manager{}
+
+func init() {
+	cache.OnInvalidate(topic, Default.forget)
+}
+
+func NewManager(s store.Store) *Manager {
+	return &Manager{store: s}
+}
+
+func (m *Manager) st() store.Store {
+	if m.store == nil {
+		return store.Default()
+	}
+	return m.store
+}
+
+// Create starts the session of the user holding the access token, which is empty for
+// users without a github account. The returned refresh token is the only time it is available.
+func (m *Manager) Create(s *Session, accessToken string) (string, error) {
+	if len(s.Login) == 0 {
+		return "", errors.New("sessions need a login")
+	}
+	if len(s.Provider) == 0 {
+		s.Provider = ProviderGitHub
+	}
+
+	token, err := jwe.Encrypt(env.JweKey, []byte(accessToken))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	s.ID = xid.New().String()
+	s.CreatedAt = now
+	s.LastUsedAt = now
+	s.ExpiresAt = now.Add(Lifetime)
+
+	plain, err := newRefreshToken(s.ID)
+	if err != nil {
+		return "", err
+	}
+
+	rec := &record{Session: *s, Hash: hash(plain), Token: base64.RawURLEncoding.EncodeToString(token)}
+	err = store.PutJSON(m.st(), storeKind, s.ID, rec)
+	if err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+// Refresh rotates the refresh token and extends the session, it returns the new
+// refresh token and the github access token of the session. Presenting a token
+// which was already rotated revokes the session, as it has leaked.
+func (m *Manager) Refresh(plain string) (*Session, string, string, error) {
+	id, ok := parse(plain)
+	if !ok {
+		return nil, "", "", ErrInvalid
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, err := m.get(id)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	h := hash(plain)
+	if subtle.ConstantTimeCompare([]byte(rec.Hash), []byte(h)) != 1 {
+		if len(rec.PrevHash) > 0 && subtle.ConstantTimeCompare([]byte(rec.PrevHash), []byte(h)) == 1 && rec.RevokedAt == nil {
+			m.revoke(rec)
+			return nil, "", "", ErrRevoked
+		}
+		return nil, "", "", ErrInvalid
+	}
+	if err = rec.check(time.Now()); err != nil {
+		return nil, "", "", err
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(rec.Token)
+	if err != nil {
+		return nil, "", "", err
+	}
+	token, err := jwe.Decrypt(env.JweKey, wrapped)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	next, err := newRefreshToken(id)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	now := time.Now().UTC()
+	rec.PrevHash, rec.Hash = rec.Hash, hash(next)
+	rec.LastUsedAt = now
+	rec.ExpiresAt = now.Add(Lifetime)
+	err = store.PutJSON(m.st(), storeKind, id, rec)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return &rec.Session, next, string(token), nil
+}
+
+// Active returns the session unless it is revoked or expired, active sessions
+// are cached for a short time
+func (m *Manager) Active(id string) (*Session, error) {
+	if a, ok := m.active.Load(id); ok && time.Now().Before(a.(*activeSession).until) {
+		return a.(*activeSession).session, nil
+	}
+
+	rec, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = rec.check(time.Now()); err != nil {
+		return nil, err
+	}
+
+	m.active.Store(id, &activeSession{&rec.Session, time.Now().Add(activeTTL)})
+	return &rec.Session, nil
+}
+
+// Get returns a session by id
+func (m *Manager) Get(id string) (*Session, error) {
+	rec, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Session, nil
+}
+
+// List returns the active sessions, most recently used first, of the user
+// or of every user when login is empty. Expired sessions are removed.
+func (m *Manager) List(login string) ([]*Session, error) {
+	recs, err := store.ListJSON[record](m.st(), storeKind)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res := make([]*Session, 0)
+	for _, rec := range recs {
+		if now.After(rec.ExpiresAt) {
+			m.st().Delete(storeKind, rec.ID)
+			continue
+		}
+		if rec.RevokedAt != nil || (len(login) > 0 && !strings.EqualFold(rec.Login, login)) {
+			continue
+		}
+		s := rec.Session
+		res = append(res, &s)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastUsedAt.After(res[j].LastUsedAt)
+	})
+	return res, nil
+}
+
+// Revoke ends a session for good
+func (m *Manager) Revoke(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if rec.RevokedAt == nil {
+		err = m.revoke(rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &rec.Session, nil
+}
+
+// RevokeAll ends every active session of the user and returns them
+func (m *Manager) RevokeAll(login string) ([]*Session, error) {
+	if len(login) == 0 {
+		return nil, errors.New("login missing")
+	}
+
+	active, err := m.List(login)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Session, 0, len(active))
+	for _, s := range active {
+		rs, err := m.Revoke(s.ID)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, rs)
+	}
+	return res, nil
+}
+
+func (m *Manager) get(id string) (*record, error) {
+	rec := &record{}
+	err := store.GetJSON(m.st(), storeKind, id, rec)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (m *Manager) revoke(rec *record) error {
+	now := time.Now().UTC()
+	rec.RevokedAt = &now
+	err := store.PutJSON(m.st(), storeKind, rec.ID, rec)
+	if err != nil {
+		return err
+	}
+
+	m.forget(rec.ID)
+	cache.Invalidate(topic, rec.ID)
+	return nil
+}
+
+func (m *Manager) forget(id string) {
+	m.active.Delete(id)
+}
+
+func (rec *record) check(now time.Time) error {
+	if rec.RevokedAt != nil {
+		return ErrRevoked
+	}
+	if now.After(rec.ExpiresAt) {
+		return ErrExpired
+	}
+	return nil
+}
+
+func newRefreshToken(id string) (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func parse(plain string) (string, bool) {
+	if !strings.HasPrefix(plain, Prefix) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(plain, Prefix), "_")
+	return id, ok
+}
+
+func hash(plain string) string {
+	h := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,73 @@
+package sessions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/store"
+)
+
+func TestSessions(t *testing.T) {
+	env.JweKey = []byte("0123456789abcdef0123456789abcdef")
+	m := NewManager(store.NewFile(t.TempDir()))
+
+	s := &Session{Login: "octocat", UserAgent: "test", IP: "127.0.0.1"}
+	refresh, err := m.Create(s, "gh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active, err := m.Active(s.ID); err != nil || active.Provider != ProviderGitHub {
+		t.Fatal("session not active", err)
+	}
+
+	_, next, token, err := m.Refresh(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "gh-token" || next == refresh {
+		t.Error("refresh token not rotated")
+	}
+	if _, _, _, err = m.Refresh(next + "x"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected invalid token, got %v", err)
+	}
+
+	// the rotated token leaked, the session is over
+	if _, _, _, err = m.Refresh(refresh); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected revoked session, got %v", err)
+	}
+	if _, _, _, err = m.Refresh(next); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected revoked session, got %v", err)
+	}
+	if _, err = m.Active(s.ID); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected revoked session, got %v", err)
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	env.JweKey = []byte("0123456789abcdef0123456789abcdef")
+	m := NewManager(store.NewFile(t.TempDir()))
+
+	a := &Session{Login: "octocat"}
+	m.Create(a, "gh-token")
+	m.Create(&Session{Login: "octocat"}, "gh-token")
+	m.Create(&Session{Login: "jane@example.com", Provider: ProviderOIDC}, "")
+
+	list, _ := m.List("OctoCat")
+	if len(list) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(list))
+	}
+
+	m.Revoke(a.ID)
+	if list, _ = m.List("octocat"); len(list) != 1 {
+		t.Errorf("expected 1 session, got %d", len(list))
+	}
+
+	revoked, err := m.RevokeAll("octocat")
+	if err != nil || len(revoked) != 1 {
+		t.Errorf("expected 1 revoked session, got %d %v", len(revoked), err)
+	}
+	if list, _ = m.List(""); len(list) != 1 || list[0].Login != "jane@example.com" {
+		t.Error("unexpected sessions left")
+	}
+}
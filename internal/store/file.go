@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// File stores every record in its own file under a folder per kind
+type File struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+func (f *File) Get(kind string, id string) ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	data, err := os.ReadFile(f.path(kind, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (f *File) Put(kind string, id string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.MkdirAll(filepath.Join(f.dir, kind), 0o700)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so readers never see partial records
+	tmp := f.path(kind, id) + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(kind, id))
+}
+
+func (f *File) Delete(kind string, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.path(kind, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f *File) List(kind string) ([][]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	names, err := filepath.Glob(filepath.Join(f.dir, kind, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	res := make([][]byte, 0, len(names))
+	for _, n := range names {
+		data, err := os.ReadFile(n)
+		if err != nil {
+			continue
+		}
+		res = append(res, data)
+	}
+	return res, nil
+}
+
+func (f *File) path(kind string, id string) string {
+	return filepath.Join(f.dir, kind, url.PathEscape(id)+".json")
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"github.com/moonwalker/moonbase/internal/cache"
+)
+
+const redisPrefix = "moonbase:store:"
+
+// Redis keeps the records of a kind in a hash, so every instance sees the same records
+type Redis struct {
+	client *cache.Redis
+}
+
+func NewRedis(rawURL string) (*Redis, error) {
+	client, err := cache.NewRedis(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{client}, nil
+}
+
+func (r *Redis) Get(kind string, id string) ([]byte, error) {
+	v, err := r.client.Do("HGET", redisPrefix+kind, id)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	return v.([]byte), nil
+}
+
+func (r *Redis) Put(kind string, id string, data []byte) error {
+	_, err := r.client.Do("HSET", redisPrefix+kind, id, string(data))
+	return err
+}
+
+func (r *Redis) Delete(kind string, id string) error {
+	_, err := r.client.Do("HDEL", redisPrefix+kind, id)
+	return err
+}
+
+func (r *Redis) List(kind string) ([][]byte, error) {
+	v, err := r.client.Do("HVALS", redisPrefix+kind)
+	if err != nil {
+		return nil, err
+	}
+
+	items, _ := v.([]interface{})
+	res := make([][]byte, 0, len(items))
+	for _, i := range items {
+		if data, ok := i.([]byte); ok {
+			res = append(res, data)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/moonwalker/moonbase/internal/env"
+)
+
+const (
+	BackendFile  = "file"
+	BackendRedis = "redis"
+)
+
+var ErrNotFound = errors.New("store: record not found")
+
+// Store persists records grouped by kind, unlike the cache nothing is ever evicted
+type Store interface {
+	Get(kind string, id string) ([]byte, error)
+	Put(kind string, id string, data []byte) error
+	Delete(kind string, id string) error
+	List(kind string) ([][]byte, error)
+}
+
+var shared struct {
+	sync.Mutex
+	store Store
+}
+
+// Open sets up the store configured by the environment, it fails when the configured
+// backend is unavailable instead of keeping records where other instances can't see them
+func Open() (Store, error) {
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.store != nil {
+		return shared.store, nil
+	}
+
+	switch env.StoreBackend {
+	case BackendRedis:
+		s, err := NewRedis(env.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("store backend %s unavailable: %w", env.StoreBackend, err)
+		}
+		shared.store = s
+	case BackendFile:
+		shared.store = NewFile(env.StoreDir)
+	default:
+		return nil, fmt.Errorf("unknown store backend: %s", env.StoreBackend)
+	}
+
+	return shared.store, nil
+}
+
+// Default returns the store opened at startup
+func Default() Store {
+	s, err := Open()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func GetJSON(s Store, kind string, id string, v any) error {
+	data, err := s.Get(kind, id)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func PutJSON(s Store, kind string, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(kind, id, data)
+}
+
+// ListJSON decodes every record of a kind, records which fail to decode are skipped
+func ListJSON[T any](s Store, kind string) ([]*T, error) {
+	items, err := s.List(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*T, 0, len(items))
+	for _, data := range items {
+		v := new(T)
+		if json.Unmarshal(data, v) == nil {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker opens after a number of consecutive failures and rejects requests until the
+// cooldown passed, then lets a single request per cooldown probe whether upstream recovered
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a request may be sent
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	now := b.now()
+	if now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	// a probe which never reports back doesn't keep the breaker shut past the next cooldown
+	b.openedAt = now
+	return true
+}
+
+// Open reports whether requests are rejected
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.threshold
+}
+
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+func (b *Breaker) Failure() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+	b.mu.Unlock()
+}
@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// read of error bodies telling secondary rate limits apart from other 403s
+	maxErrorBody = 64 << 10
+	// github asks to wait at least a minute when a secondary limit gives no hint
+	secondaryRateLimitWait = time.Minute
+)
+
+var ErrCircuitOpen = errors.New("transport: circuit open, upstream failing")
+
+// Config of a transport, zero values fall back to the defaults
+type Config struct {
+	// Timeout of one attempt, response body included
+	Timeout time.Duration
+	// Retries of a failed attempt, reads are retried on server errors, every request on rate limits
+	Retries int
+	// BaseDelay is doubled with every retry up to MaxDelay, jitter is added
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest wait a rate limited request is retried after,
+	// responses asking for longer waits are returned to the caller
+	MaxRetryAfter time.Duration
+}
+
+// Transport retries failed and rate limited requests and fails fast while its breaker is open
+type Transport struct {
+	base    http.RoundTripper
+	config  Config
+	breaker *Breaker
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:       30 * time.Second,
+		Retries:       3,
+		BaseDelay:     200 * time.Millisecond,
+		MaxDelay:      5 * time.Second,
+		MaxRetryAfter: 20 * time.Second,
+	}
+}
+
+// New returns a transport on base, a nil breaker never opens
+func New(base http.RoundTripper, config Config, breaker *Breaker) *Transport {
+	def := DefaultConfig()
+	if config.Timeout <= 0 {
+		config.Timeout = def.Timeout
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = def.BaseDelay
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = def.MaxDelay
+	}
+	if config.MaxRetryAfter <= 0 {
+		config.MaxRetryAfter = def.MaxRetryAfter
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{base, config, breaker, sleep}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if !t.breaker.Allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		resp, err := t.try(req)
+
+		// the caller gave up, which says nothing about upstream
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+
+		var wait time.Duration
+		var retry bool
+		switch {
+		case err != nil:
+			t.breaker.Failure()
+			wait, retry = t.backoff(attempt), idempotent(req)
+		case resp.StatusCode >= 500:
+			t.breaker.Failure()
+			wait, retry = t.backoff(attempt), idempotent(req)
+		default:
+			// a rate limited request has not been processed, so it is safe to send again
+			t.breaker.Success()
+			var limited bool
+			if wait, limited = rateLimited(resp); limited {
+				wait = maxDuration(wait, t.backoff(attempt))
+				retry = wait <= t.config.MaxRetryAfter
+			}
+		}
+
+		if !retry || attempt >= t.config.Retries || !rewindable(req) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+			resp.Body.Close()
+		}
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// try sends the request once, the deadline of the attempt ends when the body is closed
+func (t *Transport) try(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.config.Timeout)
+
+	r := req.Clone(ctx)
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// backoff returns the exponential delay of the attempt, half of it jittered
+func (t *Transport) backoff(attempt int) time.Duration {
+	d := t.config.BaseDelay << attempt
+	if d > t.config.MaxDelay || d <= 0 {
+		d = t.config.MaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// rateLimited reports whether github rejected the request for exceeding a rate limit and how long to wait
+func rateLimited(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if s := resp.Header.Get("Retry-After"); len(s) > 0 {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(s); err == nil {
+			return time.Until(t), true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)), true
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return secondaryRateLimitWait, true
+	}
+
+	// other 403s are permission errors, only the message tells them apart
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body = &readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if strings.Contains(strings.ToLower(string(body)), "secondary rate limit") {
+		return secondaryRateLimitWait, true
+	}
+	return 0, false
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// rewindable reports whether the body of the request can be sent again
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// cancelBody ends the attempt of a response once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
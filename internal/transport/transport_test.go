@@ -0,0 +1,253 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fake answers with the injected failures in order, then succeeds
+type fake struct {
+	mu       sync.Mutex
+	failures []func(w http.ResponseWriter)
+	calls    int
+	bodies   []string
+}
+
+func (f *fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.calls++
+	f.bodies = append(f.bodies, string(body))
+	var fail func(w http.ResponseWriter)
+	if len(f.failures) > 0 {
+		fail, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mu.Unlock()
+
+	if fail != nil {
+		fail(w)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func (f *fake) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func status(code int, header ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(`{"message":"failure"}`))
+	}
+}
+
+func message(code int, msg string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(code)
+		w.Write([]byte(`{"message":"` + msg + `"}`))
+	}
+}
+
+func stall(d time.Duration) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		time.Sleep(d)
+	}
+}
+
+type testClient struct {
+	*http.Client
+	waits []time.Duration
+}
+
+func newClient(config Config, breaker *Breaker) *testClient {
+	c := &testClient{}
+	t := New(nil, config, breaker)
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		c.waits = append(c.waits, d)
+		return ctx.Err()
+	}
+	c.Client = &http.Client{Transport: t}
+	return c
+}
+
+func testConfig() Config {
+	return Config{Timeout: time.Second, Retries: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond, MaxRetryAfter: 5 * time.Second}
+}
+
+func send(t *testing.T, c *testClient, method string, url string, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){status(502), status(500)}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := newClient(testConfig(), nil)
+	code, body := send(t, c, http.MethodGet, srv.URL, "")
+	if code != http.StatusOK || body != "ok" || f.count() != 3 {
+		t.Errorf("expected success after 3 calls, got %d %q after %d", code, body, f.count())
+	}
+	if len(c.waits) != 2 || c.waits[0] < 5*time.Millisecond || c.waits[0] > 10*time.Millisecond || c.waits[1] < 10*time.Millisecond || c.waits[1] > 20*time.Millisecond {
+		t.Errorf("unexpected backoff %v", c.waits)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){status(503), status(503), status(503), status(503), status(503)}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	code, _ := send(t, newClient(testConfig(), nil), http.MethodGet, srv.URL, "")
+	if code != http.StatusServiceUnavailable || f.count() != 4 {
+		t.Errorf("expected the last failure after 4 calls, got %d after %d", code, f.count())
+	}
+}
+
+func TestWritesNotRetriedOnServerErrors(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){status(500)}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	code, _ := send(t, newClient(testConfig(), nil), http.MethodPost, srv.URL, "data")
+	if code != http.StatusInternalServerError || f.count() != 1 {
+		t.Errorf("expected a single call, got %d after %d", code, f.count())
+	}
+}
+
+func TestSecondaryRateLimit(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){
+		status(403, "Retry-After", "2"),
+		status(429, "Retry-After", "1"),
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := newClient(testConfig(), nil)
+	code, _ := send(t, c, http.MethodPost, srv.URL, "data")
+	if code != http.StatusOK || f.count() != 3 {
+		t.Errorf("expected success after 3 calls, got %d after %d", code, f.count())
+	}
+	if len(c.waits) != 2 || c.waits[0] != 2*time.Second || c.waits[1] != time.Second {
+		t.Errorf("retry-after not honored %v", c.waits)
+	}
+	for _, b := range f.bodies {
+		if b != "data" {
+			t.Errorf("body not replayed: %q", f.bodies)
+		}
+	}
+}
+
+func TestRateLimitTooLong(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	f := &fake{failures: []func(http.ResponseWriter){
+		status(403, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", strconv.FormatInt(reset, 10)),
+		message(403, "You have exceeded a secondary rate limit"),
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := newClient(testConfig(), nil)
+	if code, _ := send(t, c, http.MethodGet, srv.URL, ""); code != http.StatusForbidden || f.count() != 1 {
+		t.Errorf("expected the exhausted limit returned, got %d after %d", code, f.count())
+	}
+	code, body := send(t, c, http.MethodGet, srv.URL, "")
+	if code != http.StatusForbidden || f.count() != 2 || !strings.Contains(body, "secondary rate limit") {
+		t.Errorf("expected the secondary limit returned, got %d %q after %d", code, body, f.count())
+	}
+}
+
+func TestForbiddenNotRetried(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){message(403, "Resource not accessible by integration")}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	code, body := send(t, newClient(testConfig(), nil), http.MethodGet, srv.URL, "")
+	if code != http.StatusForbidden || f.count() != 1 || !strings.Contains(body, "not accessible") {
+		t.Errorf("expected a single call with the body intact, got %d %q after %d", code, body, f.count())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){stall(200 * time.Millisecond)}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	config := testConfig()
+	config.Timeout = 50 * time.Millisecond
+	code, _ := send(t, newClient(config, nil), http.MethodGet, srv.URL, "")
+	if code != http.StatusOK || f.count() != 2 {
+		t.Errorf("expected success after the stalled call, got %d after %d", code, f.count())
+	}
+}
+
+func TestCallerCancel(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){stall(200 * time.Millisecond), status(500)}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	breaker := NewBreaker(1, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	_, err := newClient(testConfig(), breaker).Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) || f.count() != 1 {
+		t.Errorf("expected the deadline of the caller after 1 call, got %v after %d", err, f.count())
+	}
+	if breaker.Open() {
+		t.Error("cancelled request opened the breaker")
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	f := &fake{failures: []func(http.ResponseWriter){status(500), status(500), status(500)}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	c := newClient(testConfig(), breaker)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := c.Do(req); !errors.Is(err, ErrCircuitOpen) || f.count() != 2 {
+		t.Errorf("expected the breaker to open after 2 calls, got %v after %d", err, f.count())
+	}
+	if _, err := c.Do(req); !errors.Is(err, ErrCircuitOpen) || f.count() != 2 {
+		t.Errorf("open breaker let a request through, got %v after %d", err, f.count())
+	}
+
+	// the probe fails and the breaker opens again
+	now = now.Add(time.Minute)
+	if _, err := c.Do(req); !errors.Is(err, ErrCircuitOpen) || f.count() != 3 {
+		t.Errorf("expected a single probe, got %v after %d", err, f.count())
+	}
+
+	now = now.Add(time.Minute)
+	if code, _ := send(t, c, http.MethodGet, srv.URL, ""); code != http.StatusOK || breaker.Open() {
+		t.Errorf("expected the breaker to close after a successful probe, got %d", code)
+	}
+}
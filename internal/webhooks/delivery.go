@@ -0,0 +1,270 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/log"
+	"github.com/moonwalker/moonbase/internal/runtime"
+	"github.com/moonwalker/moonbase/internal/store"
+)
+
+const (
+	SignatureHeader = "X-Moonbase-Signature-256"
+	EventHeader     = "X-Moonbase-Event"
+	DeliveryHeader  = "X-Moonbase-Delivery"
+
+	storeKind = "deliveries"
+	// number of deliveries kept, older ones are pruned
+	logSize = 500
+	// deliveries recorded between prunes, so the store is not listed for every delivery
+	pruneEvery = 50
+)
+
+var (
+	Default = NewDispatcher(&http.Client{Timeout: 10 * time.Second}, 5, time.Second, nil)
+
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrHookNotFound     = errors.New("hook not found")
+)
+
+// Delivery is a record of sending one event to one hook
+type Delivery struct {
+	ID           string        `json:"id"`
+	HookID       string        `json:"hookId"`
+	URL          string        `json:"url"`
+	Event        *events.Event `json:"event"`
+	Attempts     int           `json:"attempts"`
+	StatusCode   int           `json:"statusCode,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Delivered    bool          `json:"delivered"`
+	RedeliveryOf string        `json:"redeliveryOf,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	DeliveredAt  *time.Time    `json:"deliveredAt,omitempty"`
+
+	hook *Hook
+}
+
+// Dispatcher sends events to hooks and keeps the deliveries in the store, so every replica
+// lists them and they survive restarts
+type Dispatcher struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	store   store.Store
+
+	mu       sync.Mutex
+	recorded int
+}
+
+// NewDispatcher returns a dispatcher keeping deliveries in the store, the default store when nil
+func NewDispatcher(client *http.Client, retries int, backoff time.Duration, s store.Store) *Dispatcher {
+	return &Dispatcher{client: client, retries: retries, backoff: backoff, store: s}
+}
+
+func (d *Dispatcher) st() store.Store {
+	if d.store == nil {
+		return store.Default()
+	}
+	return d.store
+}
+
+// Sign returns the hmac signature of the body in the same format github uses
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatch delivers the event in the background to every hook subscribed to it
+func (d *Dispatcher) Dispatch(e *events.Event) {
+	for _, h := range HooksFor(e.Owner, e.Repo, e.Ref) {
+		if h.Matches(e) {
+			go d.send(d.record(h, e, ""))
+		}
+	}
+}
+
+// Deliveries returns the delivery log of a repository, latest first
+func (d *Dispatcher) Deliveries(owner, repo string) ([]*Delivery, error) {
+	all, err := d.list()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Delivery, 0)
+	for i := len(all) - 1; i >= 0; i-- {
+		dl := all[i]
+		if strings.EqualFold(dl.Event.Owner, owner) && strings.EqualFold(dl.Event.Repo, repo) {
+			res = append(res, dl)
+		}
+	}
+	return res, nil
+}
+
+// Redeliver sends the event of a previous delivery again to the hook, as it is configured now
+func (d *Dispatcher) Redeliver(id string) (*Delivery, error) {
+	prev := &Delivery{}
+	err := store.GetJSON(d.st(), storeKind, id, prev)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hook *Hook
+	for _, h := range HooksFor(prev.Event.Owner, prev.Event.Repo, prev.Event.Ref) {
+		if h.ID == prev.HookID {
+			hook = h
+		}
+	}
+	if hook == nil {
+		return nil, ErrHookNotFound
+	}
+
+	dl := d.record(hook, prev.Event, prev.ID)
+	c := *dl
+	go d.send(dl)
+
+	return &c, nil
+}
+
+// list returns every delivery kept, oldest first
+func (d *Dispatcher) list() ([]*Delivery, error) {
+	all, err := store.ListJSON[Delivery](d.st(), storeKind)
+	if err != nil {
+		return nil, err
+	}
+	// ids are xids, ordered by creation
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, nil
+}
+
+func (d *Dispatcher) record(h *Hook, e *events.Event, redeliveryOf string) *Delivery {
+	dl := &Delivery{
+		ID:           xid.New().String(),
+		HookID:       h.ID,
+		URL:          h.URL,
+		Event:        e,
+		RedeliveryOf: redeliveryOf,
+		CreatedAt:    time.Now().UTC(),
+		hook:         h,
+	}
+
+	d.mu.Lock()
+	d.save(dl)
+	d.recorded++
+	prune := d.recorded%pruneEvery == 0
+	d.mu.Unlock()
+
+	if prune {
+		d.prune()
+	}
+	return dl
+}
+
+// save writes the delivery to the store, failures only cost the record of the delivery
+func (d *Dispatcher) save(dl *Delivery) {
+	if err := store.PutJSON(d.st(), storeKind, dl.ID, dl); err != nil {
+		log.Error(err).Str("delivery", dl.ID).Msg("failed to store webhook delivery")
+	}
+}
+
+// prune deletes the oldest deliveries beyond the size of the log, the log grows
+// by the deliveries recorded until the next prune at most
+func (d *Dispatcher) prune() {
+	all, err := d.list()
+	if err != nil || len(all) <= logSize {
+		return
+	}
+	for _, dl := range all[:len(all)-logSize] {
+		d.st().Delete(storeKind, dl.ID)
+	}
+}
+
+// send posts the delivery retrying failed attempts with exponential backoff and jitter
+func (d *Dispatcher) send(dl *Delivery) {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		d.update(dl, 0, err)
+		return
+	}
+
+	for attempt := 0; attempt <= d.retries; attempt++ {
+		if attempt > 0 {
+			backoff := d.backoff << (attempt - 1)
+			time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)))
+		}
+
+		statusCode, err := d.post(dl, body)
+		if d.update(dl, statusCode, err) {
+			return
+		}
+	}
+
+	log.Error(errors.New(dl.Error)).Str("hook", dl.HookID).Str("delivery", dl.ID).Msg("webhook delivery failed")
+}
+
+func (d *Dispatcher) post(dl *Delivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("%s/%s", runtime.Name, runtime.ShortRev()))
+	req.Header.Set(EventHeader, dl.Event.Type)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	if len(dl.hook.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(dl.hook.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// update records the outcome of an attempt and reports whether the delivery succeeded
+func (d *Dispatcher) update(dl *Delivery, statusCode int, err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl.Attempts++
+	dl.StatusCode = statusCode
+	if err != nil {
+		dl.Error = err.Error()
+		d.save(dl)
+		return false
+	}
+
+	now := time.Now().UTC()
+	dl.Error = ""
+	dl.Delivered = true
+	dl.DeliveredAt = &now
+	d.save(dl)
+	return true
+}
@@ -0,0 +1,107 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/log"
+)
+
+// Hook is an outbound webhook configured in the settings of a repository
+type Hook struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret,omitempty"`
+	Events      []string `json:"events,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+	Disabled    bool     `json:"disabled,omitempty"`
+}
+
+var registry = struct {
+	sync.RWMutex
+	hooks  map[string][]*Hook
+	loader func(owner, repo, ref string) ([]*Hook, error)
+}{hooks: make(map[string][]*Hook)}
+
+func ParseHooks(data []byte) ([]*Hook, error) {
+	hooks := make([]*Hook, 0)
+	err := json.Unmarshal(data, &hooks)
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// Redact removes the secrets from a hook configuration
+func Redact(data []byte) ([]byte, error) {
+	hooks, err := ParseHooks(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hooks {
+		h.Secret = ""
+	}
+	return json.MarshalIndent(hooks, "", "  ")
+}
+
+// Matches reports whether the hook subscribed to the event, empty filters match everything
+func (h *Hook) Matches(e *events.Event) bool {
+	if h.Disabled || len(h.URL) == 0 {
+		return false
+	}
+	return matchAny(h.Events, e.Type) && (len(e.Collection) == 0 || matchAny(h.Collections, e.Collection))
+}
+
+func matchAny(filter []string, v string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == v || f == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHooks stores the last known hook configuration of a repository ref,
+// events not originating from moonbase are delivered with this configuration
+func SetHooks(owner, repo, ref string, hooks []*Hook) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.hooks[registryKey(owner, repo, ref)] = hooks
+}
+
+// SetLoader sets how the hook configuration of a ref is read when no configuration is known of it yet
+func SetLoader(fn func(owner, repo, ref string) ([]*Hook, error)) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.loader = fn
+}
+
+// HooksFor returns the hooks of a ref, loading them on first use,
+// refs of which loading failed are loaded again on the next use
+func HooksFor(owner, repo, ref string) []*Hook {
+	registry.RLock()
+	hooks, ok := registry.hooks[registryKey(owner, repo, ref)]
+	load := registry.loader
+	registry.RUnlock()
+
+	if ok || load == nil {
+		return hooks
+	}
+
+	hooks, err := load(owner, repo, ref)
+	if err != nil {
+		log.Error(err).Str("ref", registryKey(owner, repo, ref)).Msg("failed to load webhooks")
+		return nil
+	}
+	SetHooks(owner, repo, ref, hooks)
+	return hooks
+}
+
+func registryKey(owner, repo, ref string) string {
+	return strings.ToLower(owner + "/" + repo + "@" + ref)
+}
@@ -0,0 +1,173 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moonwalker/moonbase/internal/events"
+	"github.com/moonwalker/moonbase/internal/store"
+)
+
+func TestHookMatches(t *testing.T) {
+	h := &Hook{URL: "http://localhost", Events: []string{events.EntryPublish}, Collections: []string{"posts"}}
+
+	e := events.New(events.EntryPublish, "foo", "bar", "main")
+	e.Collection = "posts"
+	if !h.Matches(e) {
+		t.Fail()
+	}
+
+	e.Collection = "pages"
+	if h.Matches(e) {
+		t.Fail()
+	}
+
+	if h.Matches(events.New(events.EntryDelete, "foo", "bar", "main")) {
+		t.Fail()
+	}
+}
+
+func TestDispatchRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Error("invalid signature")
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	SetHooks("foo", "bar", "main", []*Hook{{ID: "h1", URL: srv.URL, Secret: "secret"}})
+	d := NewDispatcher(srv.Client(), 3, time.Millisecond, store.NewFile(t.TempDir()))
+	d.Dispatch(events.New(events.EntryCreate, "foo", "bar", "main"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		dls, err := d.Deliveries("foo", "bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dls) == 1 && dls[0].Delivered {
+			if dls[0].Attempts != 3 {
+				t.Errorf("expected 3 attempts, got %d", dls[0].Attempts)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("event not delivered")
+}
+
+func TestDeliveriesPersisted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	registry.Lock()
+	delete(registry.hooks, registryKey("foo", "lazy", "main"))
+	registry.Unlock()
+
+	loads := 0
+	SetLoader(func(owner, repo, ref string) ([]*Hook, error) {
+		loads++
+		return []*Hook{{ID: "h1", URL: srv.URL}}, nil
+	})
+	defer SetLoader(nil)
+
+	s := store.NewFile(t.TempDir())
+	d := NewDispatcher(srv.Client(), 0, time.Millisecond, s)
+	d.Dispatch(events.New(events.EntryCreate, "foo", "lazy", "main"))
+	d.Dispatch(events.New(events.EntryUpdate, "foo", "lazy", "main"))
+	if loads != 1 {
+		t.Errorf("expected the hooks loaded once, got %d loads", loads)
+	}
+
+	// another replica sharing the store sees the deliveries and redelivers them
+	other := NewDispatcher(srv.Client(), 0, time.Millisecond, s)
+	dls, err := other.Deliveries("foo", "lazy")
+	if err != nil || len(dls) != 2 || dls[0].Event.Type != events.EntryUpdate {
+		t.Fatalf("unexpected deliveries %v %v", dls, err)
+	}
+	dl, err := other.Redeliver(dls[1].ID)
+	if err != nil || dl.RedeliveryOf != dls[1].ID {
+		t.Errorf("unexpected redelivery %v %v", dl, err)
+	}
+	if _, err := other.Redeliver("missing"); err != ErrDeliveryNotFound {
+		t.Errorf("expected delivery not found, got %v", err)
+	}
+
+	// the store is removed with the test once every delivery is done
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		dls, _ := other.Deliveries("foo", "lazy")
+		done := 0
+		for _, dl := range dls {
+			if dl.Delivered {
+				done++
+			}
+		}
+		if done == 3 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("events not delivered")
+}
+
+func TestPrune(t *testing.T) {
+	s := store.NewFile(t.TempDir())
+	d := NewDispatcher(http.DefaultClient, 0, time.Millisecond, s)
+	h := &Hook{ID: "h1", URL: "http://localhost"}
+	e := events.New(events.EntryCreate, "foo", "bar", "main")
+
+	for i := 0; i < logSize; i++ {
+		d.record(h, e, "")
+	}
+	count := func() int {
+		all, err := d.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(all)
+	}
+
+	oldest, _ := d.list()
+	for i := 0; i < pruneEvery-1; i++ {
+		d.record(h, e, "")
+	}
+	if n := count(); n != logSize+pruneEvery-1 {
+		t.Errorf("expected no prune before %d deliveries, got %d kept", pruneEvery, n)
+	}
+
+	d.record(h, e, "")
+	if n := count(); n != logSize {
+		t.Errorf("expected %d deliveries kept, got %d", logSize, n)
+	}
+	if _, err := s.Get(storeKind, oldest[0].ID); err == nil {
+		t.Error("expected the oldest delivery pruned")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	data, err := Redact([]byte(`[{"id":"h1","url":"http://localhost","secret":"s3cret","events":["entry.publish"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks, err := ParseHooks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].Secret != "" || hooks[0].URL != "http://localhost" || len(hooks[0].Events) != 1 {
+		t.Errorf("unexpected redacted hooks %s", data)
+	}
+}
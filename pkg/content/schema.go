@@ -82,28 +82,44 @@ type Schema struct {
 	Status       string     `json:"status,omitempty"`
 }
 
+// statuses of the translation of a localized field
+const (
+	TranslationUntranslated = "untranslated"
+	TranslationTranslated   = "translated"
+	TranslationReview       = "review"
+)
+
+// Translation is the status of a localized field in a locale, Source is the hash
+// of the default locale value the translation was made from
+type Translation struct {
+	Status string `json:"status"`
+	Source string `json:"source,omitempty"`
+}
+
 type ContentData struct {
-	ID          string                 `json:"id,omitempty"`
-	Fields      map[string]interface{} `json:"fields,omitempty"`
-	CreatedAt   string                 `json:"createdAt,omitempty"`
-	CreatedBy   string                 `json:"createdBy,omitempty"`
-	UpdatedAt   string                 `json:"updatedAt,omitempty"`
-	UpdatedBy   string                 `json:"updatedBy,omitempty"`
-	PublishedAt string                 `json:"publishedAt,omitempty"`
-	PublishedBy string                 `json:"publishedBy,omitempty"`
-	Version     int                    `json:"version,omitempty"`
-	Status      string                 `json:"status,omitempty"`
+	ID           string                  `json:"id,omitempty"`
+	Fields       map[string]interface{}  `json:"fields,omitempty"`
+	Translations map[string]*Translation `json:"translations,omitempty"`
+	CreatedAt    string                  `json:"createdAt,omitempty"`
+	CreatedBy    string                  `json:"createdBy,omitempty"`
+	UpdatedAt    string                  `json:"updatedAt,omitempty"`
+	UpdatedBy    string                  `json:"updatedBy,omitempty"`
+	PublishedAt  string                  `json:"publishedAt,omitempty"`
+	PublishedBy  string                  `json:"publishedBy,omitempty"`
+	Version      int                     `json:"version,omitempty"`
+	Status       string                  `json:"status,omitempty"`
 }
 
 type MergedContentData struct {
-	ID          string                            `json:"id,omitempty"`
-	Fields      map[string]map[string]interface{} `json:"fields,omitempty"`
-	CreatedAt   *time.Time                        `json:"createdAt,omitempty"`
-	CreatedBy   string                            `json:"createdBy,omitempty"`
-	UpdatedAt   *time.Time                        `json:"updatedAt,omitempty"`
-	UpdatedBy   string                            `json:"updatedBy,omitempty"`
-	PublishedAt *time.Time                        `json:"publishedAt,omitempty"`
-	PublishedBy string                            `json:"publishedBy,omitempty"`
-	Version     int                               `json:"version,omitempty"`
-	Status      string                            `json:"status,omitempty"`
+	ID           string                             `json:"id,omitempty"`
+	Fields       map[string]map[string]interface{}  `json:"fields,omitempty"`
+	Translations map[string]map[string]*Translation `json:"translations,omitempty"`
+	CreatedAt    *time.Time                         `json:"createdAt,omitempty"`
+	CreatedBy    string                             `json:"createdBy,omitempty"`
+	UpdatedAt    *time.Time                         `json:"updatedAt,omitempty"`
+	UpdatedBy    string                             `json:"updatedBy,omitempty"`
+	PublishedAt  *time.Time                         `json:"publishedAt,omitempty"`
+	PublishedBy  string                             `json:"publishedBy,omitempty"`
+	Version      int                                `json:"version,omitempty"`
+	Status       string                             `json:"status,omitempty"`
 }
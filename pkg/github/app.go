@@ -0,0 +1,211 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/jwt"
+)
+
+const (
+	appTokenExpires = 9 * time.Minute
+	// installation tokens live an hour, they are renewed well before
+	installationTokenMargin = 5 * time.Minute
+	permissionCacheTTL      = time.Minute
+
+	PermissionAdmin    = "admin"
+	PermissionMaintain = "maintain"
+	PermissionWrite    = "write"
+	PermissionTriage   = "triage"
+	PermissionRead     = "read"
+	PermissionNone     = "none"
+)
+
+var (
+	// users are only identified when running as an app, repository access comes from the installation
+	appScopes = []string{"user:email", "read:org"}
+
+	appTokens = struct {
+		sync.Mutex
+		token   string
+		expires time.Time
+		repos   map[string]*expiringToken
+	}{repos: make(map[string]*expiringToken)}
+
+	permissionCache = struct {
+		sync.Mutex
+		items map[string]*expiringToken
+	}{items: make(map[string]*expiringToken)}
+)
+
+type expiringToken struct {
+	value   string
+	expires time.Time
+}
+
+// AppEnabled reports whether moonbase runs as a github app
+func AppEnabled() bool {
+	return len(env.GithubAppID) > 0 && len(env.GithubAppKey) > 0
+}
+
+func scopes() []string {
+	if AppEnabled() {
+		return appScopes
+	}
+	return ghScopes
+}
+
+// appToken returns the token authenticating as the app itself
+func appToken() (string, error) {
+	appTokens.Lock()
+	defer appTokens.Unlock()
+
+	if len(appTokens.token) > 0 && time.Now().Before(appTokens.expires) {
+		return appTokens.token, nil
+	}
+
+	token, err := jwt.SignApp(env.GithubAppKey, env.GithubAppID, appTokenExpires)
+	if err != nil {
+		return "", err
+	}
+
+	appTokens.token, appTokens.expires = token, time.Now().Add(appTokenExpires-time.Minute)
+	return token, nil
+}
+
+// InstallationToken returns a token of the app installation on the repository,
+// tokens are cached and renewed before they expire
+func InstallationToken(ctx context.Context, owner string, repo string) (_ string, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	key := strings.ToLower(owner + "/" + repo)
+
+	appTokens.Lock()
+	it := appTokens.repos[key]
+	appTokens.Unlock()
+	if it != nil && time.Now().Before(it.expires) {
+		return it.value, nil, nil
+	}
+
+	token, err := appToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	githubClient := ghClient(ctx, token)
+	installation, resp, err := githubClient.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return "", resp, err
+	}
+
+	// the token is limited to the repository it is used for
+	itoken, resp, err := githubClient.Apps.CreateInstallationToken(ctx, installation.GetID(), &github.InstallationTokenOptions{
+		Repositories: []string{repo},
+	})
+	if err != nil {
+		return "", resp, err
+	}
+
+	appTokens.Lock()
+	appTokens.repos[key] = &expiringToken{itoken.GetToken(), itoken.GetExpiresAt().Add(-installationTokenMargin)}
+	appTokens.Unlock()
+
+	return itoken.GetToken(), resp, nil
+}
+
+// GetPermission returns the permission of the user on the repository, read with an installation token
+func GetPermission(ctx context.Context, installationToken string, owner string, repo string, login string) (_ string, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	key := strings.ToLower(owner + "/" + repo + ":" + login)
+
+	permissionCache.Lock()
+	p := permissionCache.items[key]
+	permissionCache.Unlock()
+	if p != nil && time.Now().Before(p.expires) {
+		return p.value, nil, nil
+	}
+
+	level, resp, err := ghClient(ctx, installationToken).Repositories.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		return PermissionNone, resp, err
+	}
+
+	permissionCache.Lock()
+	permissionCache.items[key] = &expiringToken{level.GetPermission(), time.Now().Add(permissionCacheTTL)}
+	permissionCache.Unlock()
+
+	return level.GetPermission(), resp, nil
+}
+
+// CanWrite reports whether the repository permission allows pushing
+func CanWrite(permission string) bool {
+	switch permission {
+	case PermissionAdmin, PermissionMaintain, PermissionWrite:
+		return true
+	}
+	return false
+}
+
+// CanRead reports whether the repository permission allows reading
+func CanRead(permission string) bool {
+	return CanWrite(permission) || permission == PermissionTriage || permission == PermissionRead
+}
+
+// listInstallationRepositories returns the repositories of the app installations the user can access
+func listInstallationRepositories(ctx context.Context, accessToken string, page, perPage int) ([]*github.Repository, *github.Response, error) {
+	githubClient := ghClient(ctx, accessToken)
+
+	repos := make([]*github.Repository, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		installations, resp, err := githubClient.Apps.ListUserInstallations(ctx, opt)
+		if err != nil {
+			return nil, resp, err
+		}
+		for _, i := range installations {
+			ropt := &github.ListOptions{PerPage: 100}
+			for {
+				lr, resp, err := githubClient.Apps.ListUserRepos(ctx, i.GetID(), ropt)
+				if err != nil {
+					return nil, resp, err
+				}
+				repos = append(repos, lr.Repositories...)
+				if resp.NextPage == 0 {
+					break
+				}
+				ropt.Page = resp.NextPage
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	// paged the same way as the repositories of a user
+	if perPage <= 0 {
+		perPage = 30
+	}
+	if page <= 0 {
+		page = 1
+	}
+	resp := cachedResponse(http.StatusOK)
+	resp.LastPage = (len(repos) + perPage - 1) / perPage
+	from := (page - 1) * perPage
+	if from >= len(repos) {
+		return make([]*github.Repository, 0), resp, nil
+	}
+	to := from + perPage
+	if to > len(repos) {
+		to = len(repos)
+	}
+
+	return repos[from:to], resp, nil
+}
@@ -3,6 +3,7 @@ package github
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -10,8 +11,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/go-github/v48/github"
+
 	"github.com/moonwalker/moonbase/internal/env"
 	"github.com/moonwalker/moonbase/internal/jwt"
+	"github.com/moonwalker/moonbase/internal/sessions"
 )
 
 type ctxKey int
@@ -19,19 +23,35 @@ type ctxKey int
 const (
 	ctxKeyAccessToken ctxKey = iota
 	ctxKeyUser        ctxKey = iota
-
-	RetUrlCodePath     = 0
-	RetUrlCodeQuery    = 1
-	oauthStateSep      = "|"
-	codeTokenExpires   = time.Minute
-	accessTokenExpires = time.Hour * 24
+	ctxKeyUserToken   ctxKey = iota
+	ctxKeyEditor      ctxKey = iota
+	ctxKeySession     ctxKey = iota
+
+	RetUrlCodePath   = 0
+	RetUrlCodeQuery  = 1
+	oauthStateSep    = "|"
+	codeTokenExpires = time.Minute
 )
 
+// AccessTokenExpires is kept short as tokens are renewed with the refresh token of the session
+const AccessTokenExpires = time.Minute * 15
+
 type User struct {
-	Login *string `json:"login"`
-	Email *string `json:"email"`
-	Image *string `json:"image"`
-	Token string  `json:"token"`
+	Login        *string `json:"login"`
+	Email        *string `json:"email"`
+	Image        *string `json:"image"`
+	Token        string  `json:"token"`
+	RefreshToken string  `json:"refreshToken"`
+	ExpiresIn    int     `json:"expiresIn"`
+}
+
+// Editor is who commits are attributed to, delegated editors have no github
+// account and their changes are pushed with a service credential
+type Editor struct {
+	Login     string
+	Name      string
+	Email     string
+	Delegated bool
 }
 
 func WithUser(next http.Handler) http.Handler {
@@ -55,11 +75,32 @@ func WithUser(next http.Handler) http.Handler {
 		}
 
 		authClaims, ok := token.Claims.(*jwt.AuthClaims)
-		if !ok {
+		if !ok || len(authClaims.Id) == 0 {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
 
+		// tokens are only valid as long as their session is
+		session, err := sessions.Default.Active(authClaims.Id)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if !errors.Is(err, sessions.ErrInvalid) && !errors.Is(err, sessions.ErrRevoked) && !errors.Is(err, sessions.ErrExpired) {
+				status = http.StatusInternalServerError
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		// users without a github account act with the service credential,
+		// when running as an app it is replaced by the installation token
+		if session.Provider == sessions.ProviderOIDC {
+			ctx := WithAccessToken(r.Context(), env.OIDCServiceToken, session.Login)
+			ctx = WithEditor(ctx, &Editor{Login: session.Login, Name: session.Name, Email: session.Email, Delegated: true})
+			ctx = context.WithValue(ctx, ctxKeySession, session)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		ghUser, err := GetUser(r.Context(), string(authClaims.Data))
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -67,22 +108,78 @@ func WithUser(next http.Handler) http.Handler {
 		}
 
 		// add auth claims to context
-		ctx := context.WithValue(context.WithValue(r.Context(), ctxKeyAccessToken, string(authClaims.Data)), ctxKeyUser, *ghUser.Login)
+		ctx := WithAccessToken(r.Context(), string(authClaims.Data), *ghUser.Login)
+		ctx = WithEditor(ctx, editorOf(ghUser))
+		ctx = context.WithValue(ctx, ctxKeySession, session)
 		// authenticated, pass it through
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// WithAccessToken returns a context acting as the user with the access token
+func WithAccessToken(ctx context.Context, accessToken string, user string) context.Context {
+	return context.WithValue(context.WithValue(ctx, ctxKeyAccessToken, accessToken), ctxKeyUser, user)
+}
+
+// WithRepoToken returns a context accessing repositories with the token, while
+// the token of the user is kept for calls on behalf of the user
+func WithRepoToken(ctx context.Context, repoToken string) context.Context {
+	return context.WithValue(context.WithValue(ctx, ctxKeyUserToken, UserTokenFromContext(ctx)), ctxKeyAccessToken, repoToken)
+}
+
+// UserTokenFromContext returns the token of the user, which differs from the
+// access token when repositories are accessed as an app installation
+func UserTokenFromContext(ctx context.Context) string {
+	if token, ok := ctx.Value(ctxKeyUserToken).(string); ok {
+		return token
+	}
+	return AccessTokenFromContext(ctx)
+}
+
+func WithEditor(ctx context.Context, ed *Editor) context.Context {
+	return context.WithValue(ctx, ctxKeyEditor, ed)
+}
+
+func EditorFromContext(ctx context.Context) *Editor {
+	ed, _ := ctx.Value(ctxKeyEditor).(*Editor)
+	return ed
+}
+
+// editorOf falls back to the noreply address of the user when the email is private
+func editorOf(u *github.User) *Editor {
+	ed := &Editor{Login: u.GetLogin(), Name: u.GetName(), Email: u.GetEmail()}
+	if len(ed.Name) == 0 {
+		ed.Name = ed.Login
+	}
+	if len(ed.Email) == 0 {
+		ed.Email = fmt.Sprintf("%d+%s@users.noreply.github.com", u.GetID(), u.GetLogin())
+	}
+	return ed
+}
+
+// SessionFromContext returns the session the request is authenticated with, nil for api keys
+func SessionFromContext(ctx context.Context) *sessions.Session {
+	s, _ := ctx.Value(ctxKeySession).(*sessions.Session)
+	return s
+}
+
+// Delegated reports whether the user acts with a service credential instead of a github account
+func Delegated(ctx context.Context) bool {
+	ed := EditorFromContext(ctx)
+	return ed != nil && ed.Delegated
+}
+
 func AccessTokenFromContext(ctx context.Context) string {
 	return ctx.Value(ctxKeyAccessToken).(string)
 }
 
 func UserFromContext(ctx context.Context) string {
-	return ctx.Value(ctxKeyUser).(string)
+	user, _ := ctx.Value(ctxKeyUser).(string)
+	return user
 }
 
-func EncryptAccessToken(accessToken string) (string, error) {
-	te, err := jwt.EncryptAndSign(env.JweKey, env.JwtKey, []byte(accessToken), accessTokenExpires)
+func EncryptAccessToken(accessToken string, sessionID string) (string, error) {
+	te, err := jwt.EncryptAndSignWithID(env.JweKey, env.JwtKey, []byte(accessToken), sessionID, AccessTokenExpires)
 	if err != nil {
 		return "", err
 	}
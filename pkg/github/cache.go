@@ -0,0 +1,426 @@
+package github
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/cache"
+	"github.com/moonwalker/moonbase/internal/fanout"
+)
+
+const (
+	// refs move, everything else is addressed by immutable object sha
+	refCacheTTL    = 10 * time.Second
+	objectCacheTTL = 24 * time.Hour
+	teamsCacheTTL  = 5 * time.Minute
+	userCacheTTL   = time.Minute
+
+	refTopic = "refs"
+)
+
+var (
+	refCache    = cache.NewGeneric[string]("refs", refCacheTTL)
+	treeCache   = cache.NewGeneric[[]*treeEntry]("trees", objectCacheTTL)
+	objectCache = cache.New("objects", objectCacheTTL)
+	teamsCache  = cache.NewGeneric[[]string]("teams", teamsCacheTTL)
+	userCache   = cache.NewGeneric[*github.User]("users", userCacheTTL)
+
+	blobFlight fanout.Group[[]byte]
+
+	// bumped on every change of a ref so cached resolutions are not used anymore
+	refGenerations sync.Map
+
+	errTreeTruncated = errors.New("tree truncated")
+)
+
+type treeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int    `json:"size,omitempty"`
+}
+
+func init() {
+	cache.OnInvalidate(refTopic, bumpRefGeneration)
+}
+
+// InvalidateRef makes the next read of the ref resolve it again on every replica
+func InvalidateRef(owner string, repo string, ref string) {
+	cache.Invalidate(refTopic, refKey(owner, repo, ref))
+}
+
+func bumpRefGeneration(key string) {
+	gen, _ := refGenerations.LoadOrStore(key, new(int64))
+	atomic.AddInt64(gen.(*int64), 1)
+}
+
+func refKey(owner string, repo string, ref string) string {
+	return strings.ToLower(owner + "/" + repo + "@" + strings.TrimPrefix(ref, "refs/heads/"))
+}
+
+// tokenHash identifies an access token in cache keys without storing it
+func tokenHash(accessToken string) string {
+	token := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(token[:8])
+}
+
+// resolveRef returns the commit sha the ref points to, resolutions are cached per access token
+// for a short time, so users without access to the repository never hit the shared object cache,
+// commit shas are resolved too, which tells whether the token may read the commit
+func resolveRef(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string) (string, *github.Response, error) {
+	gen := int64(0)
+	if v, ok := refGenerations.Load(refKey(owner, repo, ref)); ok {
+		gen = atomic.LoadInt64(v.(*int64))
+	}
+	key := fmt.Sprintf("%s#%d:%s", refKey(owner, repo, ref), gen, tokenHash(accessToken))
+
+	if sha, err := refCache.Get(key); err == nil {
+		return sha, cachedResponse(http.StatusOK), nil
+	}
+
+	sha, resp, err := githubClient.Repositories.GetCommitSHA1(ctx, owner, repo, ref, "")
+	if err != nil {
+		return "", resp, err
+	}
+
+	refCache.Set(key, sha)
+	return sha, resp, nil
+}
+
+// refTree returns every entry of the tree of the commit the ref points to
+func refTree(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string) ([]*treeEntry, *github.Response, error) {
+	sha, resp, err := resolveRef(ctx, githubClient, accessToken, owner, repo, ref)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	key := strings.ToLower(owner+"/"+repo) + ":" + sha
+	if entries, err := treeCache.Get(key); err == nil {
+		// a null entry marks a tree too large to be listed at once
+		if entries == nil {
+			return nil, cachedResponse(http.StatusOK), errTreeTruncated
+		}
+		return entries, cachedResponse(http.StatusOK), nil
+	}
+
+	tree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, sha, true)
+	if err != nil {
+		return nil, resp, err
+	}
+	if tree.GetTruncated() {
+		treeCache.Set(key, nil)
+		return nil, resp, errTreeTruncated
+	}
+
+	entries := treeEntries(tree)
+	treeCache.Set(key, entries)
+	return entries, resp, nil
+}
+
+func treeEntries(tree *github.Tree) []*treeEntry {
+	entries := make([]*treeEntry, 0, len(tree.Entries))
+	for _, te := range tree.Entries {
+		entries = append(entries, &treeEntry{Path: te.GetPath(), Type: te.GetType(), SHA: te.GetSHA(), Size: te.GetSize()})
+	}
+	return entries
+}
+
+// levelTree returns the entries directly in the tree with the sha, paths are prefixed with the path of the tree
+func levelTree(ctx context.Context, githubClient *github.Client, owner string, repo string, sha string, prefix string) ([]*treeEntry, *github.Response, error) {
+	key := strings.ToLower(owner+"/"+repo) + ":" + sha + ":level"
+	if entries, err := treeCache.Get(key); err == nil {
+		return prefixed(entries, prefix), cachedResponse(http.StatusOK), nil
+	}
+
+	tree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, sha, false)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	entries := treeEntries(tree)
+	treeCache.Set(key, entries)
+	return prefixed(entries, prefix), resp, nil
+}
+
+// subtree returns every entry below the tree with the sha in one call, trees too large
+// to be listed at once are walked level by level, paths are prefixed with the path of the tree
+func subtree(ctx context.Context, githubClient *github.Client, owner string, repo string, sha string, prefix string) ([]*treeEntry, *github.Response, error) {
+	key := strings.ToLower(owner+"/"+repo) + ":" + sha
+	entries, err := treeCache.Get(key)
+	if err == nil && entries != nil {
+		return prefixed(entries, prefix), cachedResponse(http.StatusOK), nil
+	}
+
+	// known to be truncated when the null entry is cached
+	if err != nil {
+		tree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, sha, true)
+		if err != nil {
+			return nil, resp, err
+		}
+		if !tree.GetTruncated() {
+			entries := treeEntries(tree)
+			treeCache.Set(key, entries)
+			return prefixed(entries, prefix), resp, nil
+		}
+	}
+
+	// the level lists every child, so only its folders have to be walked
+	level, resp, err := levelTree(ctx, githubClient, owner, repo, sha, "")
+	if err != nil {
+		return nil, resp, err
+	}
+	nested, err := fanout.Map(ctx, fetchParallelism, level, func(ctx context.Context, te *treeEntry) ([]*treeEntry, error) {
+		if te.Type != "tree" {
+			return nil, nil
+		}
+		entries, resp, err := subtree(ctx, githubClient, owner, repo, te.SHA, te.Path)
+		if err != nil {
+			return nil, classify(resp, err)
+		}
+		return entries, nil
+	})
+	if err != nil {
+		return nil, resp, err
+	}
+
+	entries = make([]*treeEntry, 0, len(level))
+	for i, te := range level {
+		entries = append(entries, te)
+		entries = append(entries, nested[i]...)
+	}
+	treeCache.Set(key, entries)
+	return prefixed(entries, prefix), resp, nil
+}
+
+func prefixed(entries []*treeEntry, prefix string) []*treeEntry {
+	if len(prefix) == 0 {
+		return entries
+	}
+
+	res := make([]*treeEntry, 0, len(entries))
+	for _, te := range entries {
+		res = append(res, &treeEntry{Path: path.Join(prefix, te.Path), Type: te.Type, SHA: te.SHA, Size: te.Size})
+	}
+	return res
+}
+
+// lookupPath finds the entry at path walking down from the root tree of the ref one level at a time,
+// so it works for trees too large to be listed at once, the root is returned for an empty path
+func lookupPath(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string, p string) (*treeEntry, *github.Response, error) {
+	sha, resp, err := resolveRef(ctx, githubClient, accessToken, owner, repo, ref)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	// a commit sha stands for its tree
+	te := &treeEntry{Type: "tree", SHA: sha}
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+		if te.Type != "tree" {
+			resp, err := notFound(p)
+			return nil, resp, err
+		}
+
+		level, r, err := levelTree(ctx, githubClient, owner, repo, te.SHA, te.Path)
+		if err != nil {
+			return nil, r, err
+		}
+		resp = r
+
+		child := findTreeEntry(level, path.Join(te.Path, name))
+		if child == nil {
+			resp, err := notFound(p)
+			return nil, resp, err
+		}
+		te = child
+	}
+
+	return te, resp, nil
+}
+
+// pathTree returns every entry below the folder at path, read from the tree of the ref,
+// or from the tree of the folder when the tree of the ref is too large
+func pathTree(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string, p string) ([]*treeEntry, *github.Response, error) {
+	dir := strings.Trim(p, "/")
+
+	entries, resp, err := refTree(ctx, githubClient, accessToken, owner, repo, ref)
+	if errors.Is(err, errTreeTruncated) {
+		te, resp, err := lookupPath(ctx, githubClient, accessToken, owner, repo, ref, dir)
+		if err != nil {
+			return nil, resp, err
+		}
+		if te.Type != "tree" {
+			resp, err := notFound(p)
+			return nil, resp, err
+		}
+		return subtree(ctx, githubClient, owner, repo, te.SHA, dir)
+	}
+	if err != nil {
+		return nil, resp, err
+	}
+
+	if len(dir) == 0 {
+		return entries, resp, nil
+	}
+	if te := findTreeEntry(entries, dir); te == nil || te.Type != "tree" {
+		resp, err := notFound(p)
+		return nil, resp, err
+	}
+
+	res := make([]*treeEntry, 0)
+	for _, te := range entries {
+		if strings.HasPrefix(te.Path, dir+"/") {
+			res = append(res, te)
+		}
+	}
+	return res, resp, nil
+}
+
+func getBlobCached(ctx context.Context, githubClient *github.Client, owner string, repo string, sha string) ([]byte, *github.Response, error) {
+	key := strings.ToLower(owner+"/"+repo) + ":" + sha
+	if data, err := objectCache.Get(key); err == nil {
+		return data, cachedResponse(http.StatusOK), nil
+	}
+
+	// blobs are addressed by sha, so concurrent reads of any user share one request
+	data, err := blobFlight.Do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		data, resp, err := githubClient.Git.GetBlobRaw(ctx, owner, repo, sha)
+		if err != nil {
+			return nil, classify(resp, err)
+		}
+		objectCache.Set(key, data)
+		return data, nil
+	})
+	if err != nil {
+		return nil, cachedResponse(classify(nil, err).StatusCode), err
+	}
+
+	return data, cachedResponse(http.StatusOK), nil
+}
+
+func findTreeEntry(entries []*treeEntry, path string) *treeEntry {
+	path = strings.Trim(path, "/")
+	for _, te := range entries {
+		if te.Path == path {
+			return te
+		}
+	}
+	return nil
+}
+
+// treeChildren returns the entries directly under path as repository contents
+func treeChildren(entries []*treeEntry, path string) []*github.RepositoryContent {
+	rcs := make([]*github.RepositoryContent, 0)
+	for _, te := range entries {
+		if isChild(te.Path, path) {
+			rcs = append(rcs, te.content())
+		}
+	}
+	return rcs
+}
+
+// isChild reports whether p is directly in the folder at dir
+func isChild(p string, dir string) bool {
+	dir = strings.Trim(dir, "/")
+	if len(dir) == 0 {
+		dir = "."
+	}
+	return filepath.Dir(p) == dir
+}
+
+// content converts the tree entry to the shape the contents api returns
+func (te *treeEntry) content() *github.RepositoryContent {
+	typ := "file"
+	switch te.Type {
+	case "tree":
+		typ = "dir"
+	case "commit":
+		typ = "submodule"
+	}
+
+	return &github.RepositoryContent{
+		Type: github.String(typ),
+		Name: github.String(filepath.Base(te.Path)),
+		Path: github.String(te.Path),
+		SHA:  github.String(te.SHA),
+		Size: github.Int(te.Size),
+	}
+}
+
+func cachedResponse(statusCode int) *github.Response {
+	return &github.Response{Response: &http.Response{StatusCode: statusCode}}
+}
+
+// GetPathSHA returns the sha of the git object at path, the commit sha for the repository root
+func GetPathSHA(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ string, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	if len(strings.Trim(path, "/")) == 0 {
+		return resolveRef(ctx, githubClient, accessToken, owner, repo, ref)
+	}
+
+	var te *treeEntry
+	entries, resp, err := refTree(ctx, githubClient, accessToken, owner, repo, ref)
+	if errors.Is(err, errTreeTruncated) {
+		te, resp, err = lookupPath(ctx, githubClient, accessToken, owner, repo, ref, path)
+	} else if err == nil {
+		te = findTreeEntry(entries, path)
+	}
+	if err != nil {
+		return "", resp, err
+	}
+
+	if te == nil {
+		resp, err := notFound(path)
+		return "", resp, err
+	}
+
+	return te.SHA, resp, nil
+}
+
+// GetCommitDate returns the committer date of the commit the ref points to, no path of the
+// ref changed later, cached by commit so every path of the ref shares one call
+func GetCommitDate(ctx context.Context, accessToken string, owner string, repo string, ref string) (_ time.Time, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	sha, resp, err := resolveRef(ctx, githubClient, accessToken, owner, repo, ref)
+	if err != nil {
+		return time.Time{}, resp, err
+	}
+
+	var date time.Time
+	key := strings.ToLower(owner+"/"+repo) + ":" + sha + ":date"
+	if data, err := objectCache.Get(key); err == nil && date.UnmarshalText(data) == nil {
+		return date, cachedResponse(http.StatusOK), nil
+	}
+
+	commit, resp, err := githubClient.Git.GetCommit(ctx, owner, repo, sha)
+	if err != nil {
+		return time.Time{}, resp, err
+	}
+
+	date = commit.GetCommitter().GetDate()
+	if data, err := date.MarshalText(); err == nil {
+		objectCache.Set(key, data)
+	}
+
+	return date, resp, nil
+}
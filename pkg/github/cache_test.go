@@ -0,0 +1,294 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeGit answers the ref, tree and blob calls of the cache layer for any repository,
+// trees map the sha of a tree to its entries, named by their path in the tree
+type fakeGit struct {
+	sync.Mutex
+	refs  map[string]string
+	trees map[string]map[string]string
+	blobs map[string]string
+	// trees github lists truncated when read recursively
+	truncated map[string]bool
+	// tokens denied access to the repository
+	denied map[string]bool
+	calls  map[string]int
+}
+
+func (f *fakeGit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	// the owner and repo are ignored, tests use their own repository to keep the caches apart
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/repos/"), "/", 3)
+	if len(parts) < 3 || f.denied[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+		return
+	}
+	p := parts[2]
+	f.calls[p]++
+
+	switch {
+	case strings.HasPrefix(p, "commits/"):
+		ref := strings.TrimPrefix(p, "commits/refs/heads/")
+		ref = strings.TrimPrefix(ref, "commits/")
+		sha, ok := f.refs[ref]
+		if !ok {
+			// commits are their own sha
+			if _, ok = f.trees[ref]; !ok {
+				http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+				return
+			}
+			sha = ref
+		}
+		fmt.Fprint(w, sha)
+	case strings.HasPrefix(p, "git/trees/"):
+		sha := strings.TrimPrefix(p, "git/trees/")
+		if _, ok := f.trees[sha]; !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		recursive := r.URL.Query().Get("recursive") != ""
+		entries := f.list(sha, "", recursive)
+		truncated := recursive && f.truncated[sha]
+		if truncated {
+			entries = entries[:len(entries)/2]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"sha":%q,"tree":[%s],"truncated":%t}`, sha, strings.Join(entries, ","), truncated)
+	case strings.HasPrefix(p, "git/blobs/"):
+		data, ok := f.blobs[strings.TrimPrefix(p, "git/blobs/")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, data)
+	default:
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func (f *fakeGit) list(sha string, prefix string, recursive bool) []string {
+	names := make([]string, 0)
+	for name := range f.trees[sha] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]string, 0)
+	for _, name := range names {
+		child := f.trees[sha][name]
+		typ := "blob"
+		if _, ok := f.trees[child]; ok {
+			typ = "tree"
+		}
+		entries = append(entries, fmt.Sprintf(`{"path":%q,"type":%q,"sha":%q}`, path.Join(prefix, name), typ, child))
+		if typ == "tree" && recursive {
+			entries = append(entries, f.list(child, path.Join(prefix, name), true)...)
+		}
+	}
+	return entries
+}
+
+func (f *fakeGit) count(p string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls[p]
+}
+
+// newFakeGit serves a repository with main at commit c1:
+// README.md, content/posts/{a,b}/en.json and content/pages/home/en.json
+func newFakeGit(t *testing.T) *fakeGit {
+	f := &fakeGit{
+		refs: map[string]string{"main": "c1"},
+		trees: map[string]map[string]string{
+			"c1":        {"README.md": "b-readme", "content": "t-content"},
+			"c2":        {"README.md": "b-readme2", "content": "t-content"},
+			"t-content": {"posts": "t-posts", "pages": "t-pages"},
+			"t-posts":   {"a": "t-a", "b": "t-b"},
+			"t-pages":   {"home": "t-home"},
+			"t-a":       {"en.json": "b-a"},
+			"t-b":       {"en.json": "b-b"},
+			"t-home":    {"en.json": "b-home"},
+		},
+		blobs: map[string]string{
+			"b-readme":  "# readme",
+			"b-readme2": "# readme v2",
+			"b-a":       `{"id":"a"}`,
+			"b-b":       `{"id":"b"}`,
+			"b-home":    `{"id":"home"}`,
+		},
+		truncated: map[string]bool{},
+		denied:    map[string]bool{},
+	}
+	fakeGitHub(t, f)
+	return f
+}
+
+var testRepos int64
+
+// testRepo names a new repository for every run of a test, so cached trees and refs of other runs are not used
+func testRepo(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", strings.ReplaceAll(t.Name(), "/", "-"), atomic.AddInt64(&testRepos, 1))
+}
+
+func entryPaths(entries []*treeEntry) string {
+	paths := make([]string, 0, len(entries))
+	for _, te := range entries {
+		paths = append(paths, te.Path)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ",")
+}
+
+func TestResolveRef(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, f *fakeGit, repo string)
+	}{
+		{"cached per token", func(t *testing.T, f *fakeGit, repo string) {
+			for _, token := range []string{"alice", "alice", "bob"} {
+				sha, _, err := resolveRef(context.Background(), ghClient(context.Background(), token), token, "o", repo, "main")
+				if err != nil || sha != "c1" {
+					t.Fatalf("expected c1, got %s %v", sha, err)
+				}
+			}
+			if n := f.count("commits/main"); n != 2 {
+				t.Errorf("expected one resolution per token, got %d", n)
+			}
+		}},
+		{"new generation after invalidation", func(t *testing.T, f *fakeGit, repo string) {
+			resolve := func() string {
+				sha, _, err := resolveRef(context.Background(), ghClient(context.Background(), "alice"), "alice", "o", repo, "refs/heads/main")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sha
+			}
+
+			resolve()
+			f.Lock()
+			f.refs["main"] = "c2"
+			f.Unlock()
+			if sha := resolve(); sha != "c1" {
+				t.Errorf("expected the cached resolution, got %s", sha)
+			}
+			InvalidateRef("o", repo, "main")
+			if sha := resolve(); sha != "c2" {
+				t.Errorf("expected the moved ref after invalidation, got %s", sha)
+			}
+		}},
+		{"commit sha checked per token", func(t *testing.T, f *fakeGit, repo string) {
+			// alice fills the shared tree cache
+			if _, _, err := refTree(context.Background(), ghClient(context.Background(), "alice"), "alice", "o", repo, "c1"); err != nil {
+				t.Fatal(err)
+			}
+			f.denied["mallory"] = true
+			_, resp, err := refTree(context.Background(), ghClient(context.Background(), "mallory"), "mallory", "o", repo, "c1")
+			if !errors.Is(classify(resp, err), ErrNotFound) {
+				t.Errorf("expected the commit not to be found without access, got %v", err)
+			}
+		}},
+		{"unknown ref", func(t *testing.T, f *fakeGit, repo string) {
+			_, resp, err := resolveRef(context.Background(), ghClient(context.Background(), "alice"), "alice", "o", repo, "missing")
+			if !errors.Is(classify(resp, err), ErrNotFound) {
+				t.Errorf("expected not found, got %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newFakeGit(t), testRepo(t))
+		})
+	}
+}
+
+func TestRefTree(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, f *fakeGit, repo string)
+	}{
+		{"shared between tokens", func(t *testing.T, f *fakeGit, repo string) {
+			for _, token := range []string{"alice", "bob"} {
+				entries, _, err := refTree(context.Background(), ghClient(context.Background(), token), token, "o", repo, "main")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != 10 {
+					t.Errorf("expected every entry of the tree, got %s", entryPaths(entries))
+				}
+			}
+			if n := f.count("git/trees/c1"); n != 1 {
+				t.Errorf("expected the tree read once, got %d", n)
+			}
+		}},
+		{"truncated", func(t *testing.T, f *fakeGit, repo string) {
+			f.truncated["c1"] = true
+			_, _, err := refTree(context.Background(), ghClient(context.Background(), "alice"), "alice", "o", repo, "main")
+			if !errors.Is(err, errTreeTruncated) {
+				t.Errorf("expected a truncated tree, got %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newFakeGit(t), testRepo(t))
+		})
+	}
+}
+
+func TestPathTree(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		truncated bool
+		want      string
+		err       error
+	}{
+		{"root", "", false, "README.md,content,content/pages,content/pages/home,content/pages/home/en.json,content/posts,content/posts/a,content/posts/a/en.json,content/posts/b,content/posts/b/en.json", nil},
+		{"nested", "content/posts", false, "content/posts/a,content/posts/a/en.json,content/posts/b,content/posts/b/en.json", nil},
+		{"slashes", "/content/pages/", false, "content/pages/home,content/pages/home/en.json", nil},
+		{"missing folder", "content/missing", false, "", ErrNotFound},
+		{"file", "README.md", false, "", ErrNotFound},
+		{"truncated nested", "content/posts", true, "content/posts/a,content/posts/a/en.json,content/posts/b,content/posts/b/en.json", nil},
+		{"truncated deep", "content/posts/a", true, "content/posts/a/en.json", nil},
+		{"truncated missing folder", "content/missing", true, "", ErrNotFound},
+		{"truncated below file", "README.md/x", true, "", ErrNotFound},
+		{"truncated file", "README.md", true, "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeGit(t)
+			f.truncated["c1"] = tt.truncated
+
+			entries, resp, err := pathTree(context.Background(), ghClient(context.Background(), "alice"), "alice", "o", testRepo(t), "main", tt.path)
+			if tt.err != nil {
+				if !errors.Is(classify(resp, err), tt.err) || resp.StatusCode != http.StatusNotFound {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryPaths(entries); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
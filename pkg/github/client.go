@@ -7,37 +7,90 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v48/github"
 	"golang.org/x/oauth2"
 	githuboauth "golang.org/x/oauth2/github"
 
 	"github.com/moonwalker/moonbase/internal/env"
+	"github.com/moonwalker/moonbase/internal/fanout"
+	"github.com/moonwalker/moonbase/internal/ratelimit"
+	"github.com/moonwalker/moonbase/internal/transport"
 	"github.com/moonwalker/moonbase/pkg/content"
 )
 
+const (
+	// number of times a commit is rebased onto a moved branch head before giving up
+	commitRetries = 3
+	// the most files github lists when comparing commits
+	compareFilesLimit = 300
+
+	// consecutive failures after which calls fail fast until the cooldown passed
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+	// archives and raw files may be large, their attempts get more time
+	downloadTimeout = 2 * time.Minute
+	// files of one read fetched at a time
+	fetchParallelism = 8
+
+	// commit trailers naming the authenticated user and, for
+	// commits pushed with a service credential, the editor
+	userTrailer       = "Moonbase-User"
+	onBehalfOfTrailer = "On-behalf-of"
+	// marks every commit made by moonbase, so push webhooks of any replica can tell them apart
+	commitTrailer = "Moonbase-Commit: true"
+)
+
 var (
 	ghScopes = []string{"user:email", "read:org", "repo"}
+
+	// every api call shares one breaker, rate limits are tracked below the retries so each attempt counts
+	ghTransport = transport.New(&budgetTransport{}, transport.DefaultConfig(), transport.NewBreaker(breakerThreshold, breakerCooldown))
+	// archives and raw files are served by other hosts than the api
+	downloadClient = &http.Client{Transport: transport.New(nil, transport.Config{Timeout: downloadTimeout}, transport.NewBreaker(breakerThreshold, breakerCooldown))}
 )
 
 func ghConfig() *oauth2.Config {
 	return &oauth2.Config{
-		Scopes:       ghScopes,
+		Scopes:       scopes(),
 		Endpoint:     githuboauth.Endpoint,
 		ClientID:     env.GithubClientID,
 		ClientSecret: env.GithubClientSecret,
 	}
 }
 
+// ghClient returns a client calling github through the shared transport, the
+// context of a call bounds its retries
 func ghClient(ctx context.Context, accessToken string) *github.Client {
-	oauthClient := ghConfig().Client(ctx, &oauth2.Token{AccessToken: accessToken})
-	return github.NewClient(oauthClient)
+	return github.NewClient(&http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: accessToken}),
+		Base:   ghTransport,
+	}})
+}
+
+// budgetTransport records the rate limit github reports with every response,
+// without a base the default transport at the time of the call is used
+type budgetTransport struct {
+	base http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err == nil {
+		accessToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		ratelimit.GitHub.Observe(accessToken, UserFromContext(req.Context()), resp.Header)
+	}
+	return resp, err
 }
 
 func AuthCodeURL(state string) string {
@@ -52,12 +105,60 @@ func Exchange(code string) (string, error) {
 	return t.AccessToken, nil
 }
 
-func GetUser(ctx context.Context, accessToken string) (*github.User, error) {
+// GetUser returns the authenticated user, cached for a short time
+func GetUser(ctx context.Context, accessToken string) (_ *github.User, err error) {
+	defer typed(nil, &err)
+
+	key := tokenHash(accessToken)
+	if user, err := userCache.Get(key); err == nil && user != nil {
+		return user, nil
+	}
+
 	user, _, err := ghClient(ctx, accessToken).Users.Get(ctx, "")
-	return user, err
+	if err != nil {
+		return nil, err
+	}
+
+	userCache.Set(key, user)
+	return user, nil
 }
 
-func ListRepositories(ctx context.Context, accessToken string, page, perPage int, sort, direction string) ([]*github.Repository, *github.Response, error) {
+// GetUserTeams returns the teams of the authenticated user as org/team-slug, cached for a short time
+func GetUserTeams(ctx context.Context, accessToken string) (_ []string, err error) {
+	defer typed(nil, &err)
+
+	key := tokenHash(accessToken)
+	if teams, err := teamsCache.Get(key); err == nil {
+		return teams, nil
+	}
+
+	teams := make([]string, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := ghClient(ctx, accessToken).Teams.ListUserTeams(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page {
+			teams = append(teams, t.GetOrganization().GetLogin()+"/"+t.GetSlug())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	teamsCache.Set(key, teams)
+	return teams, nil
+}
+
+func ListRepositories(ctx context.Context, accessToken string, page, perPage int, sort, direction string) (_ []*github.Repository, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	if AppEnabled() {
+		return listInstallationRepositories(ctx, accessToken, page, perPage)
+	}
+
 	repos, resp, err := ghClient(ctx, accessToken).Repositories.List(ctx, "", &github.RepositoryListOptions{
 		Sort:      sort,
 		Direction: direction,
@@ -69,24 +170,68 @@ func ListRepositories(ctx context.Context, accessToken string, page, perPage int
 	return repos, resp, err
 }
 
-func ListBranches(ctx context.Context, accessToken string, owner, repo string) ([]*github.Branch, *github.Response, error) {
+func ListBranches(ctx context.Context, accessToken string, owner, repo string) (_ []*github.Branch, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	branches, resp, err := ghClient(ctx, accessToken).Repositories.ListBranches(ctx, owner, repo, &github.BranchListOptions{})
 	return branches, resp, err
 }
 
-func GetTree(ctx context.Context, accessToken string, owner string, repo string, branch string, path string) ([]*github.RepositoryContent, *github.Response, error) {
-	_, rc, resp, err := ghClient(ctx, accessToken).Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
-		Ref: branch,
-	})
+func GetTree(ctx context.Context, accessToken string, owner string, repo string, branch string, path string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	entries, resp, err := refTree(ctx, githubClient, accessToken, owner, repo, branch)
+	if errors.Is(err, errTreeTruncated) {
+		return getLevelContents(ctx, githubClient, accessToken, owner, repo, branch, path)
+	}
 	if err != nil {
 		return nil, resp, err
 	}
-	return rc, resp, nil
+
+	if len(strings.Trim(path, "/")) > 0 {
+		te := findTreeEntry(entries, path)
+		if te == nil {
+			resp, err := notFound(path)
+			return nil, resp, err
+		}
+		if te.Type != "tree" {
+			return nil, resp, nil
+		}
+	}
+
+	return treeChildren(entries, path), resp, nil
+}
+
+// getLevelContents lists the folder at path from its own tree, which isn't capped like the contents api
+func getLevelContents(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string, path string) ([]*github.RepositoryContent, *github.Response, error) {
+	te, resp, err := lookupPath(ctx, githubClient, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return nil, resp, err
+	}
+	if te.Type != "tree" {
+		return nil, resp, nil
+	}
+
+	level, resp, err := levelTree(ctx, githubClient, owner, repo, te.SHA, te.Path)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	rcs := make([]*github.RepositoryContent, 0, len(level))
+	for _, te := range level {
+		rcs = append(rcs, te.content())
+	}
+	return rcs, resp, nil
 }
 
-func GetFileContent(ctx context.Context, accessToken string, owner string, repo string, ref, path string) (*github.RepositoryContent, *github.Response, error) {
+func GetFileContent(ctx context.Context, accessToken string, owner string, repo string, ref, path string) (_ *github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	if len(path) == 0 {
-		return nil, nil, errors.New("path not provided")
+		resp, err := invalid("path not provided")
+		return nil, resp, err
 	}
 
 	fc, _, resp, err := ghClient(ctx, accessToken).Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
@@ -99,21 +244,34 @@ func GetFileContent(ctx context.Context, accessToken string, owner string, repo
 	return fc, resp, nil
 }
 
-func GetBlob(ctx context.Context, accessToken string, owner string, repo string, ref, path string) ([]byte, *github.Response, error) {
-	fc, resp, err := GetFileContent(ctx, accessToken, owner, repo, ref, path)
+// GetBlob returns the contents of the file at path, served from the object cache when possible
+func GetBlob(ctx context.Context, accessToken string, owner string, repo string, ref, path string) (_ []byte, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	var te *treeEntry
+	entries, resp, err := refTree(ctx, githubClient, accessToken, owner, repo, ref)
+	if errors.Is(err, errTreeTruncated) {
+		te, resp, err = lookupPath(ctx, githubClient, accessToken, owner, repo, ref, path)
+	} else if err == nil {
+		te = findTreeEntry(entries, path)
+	}
 	if err != nil {
 		return nil, resp, err
 	}
 
-	decodedBlob, err := fc.GetContent()
-	if err != nil {
+	if te == nil || te.Type != "blob" {
+		resp, err := notFound(path)
 		return nil, resp, err
 	}
 
-	return []byte(decodedBlob), resp, nil
+	return getBlobCached(ctx, githubClient, owner, repo, te.SHA)
 }
 
-func CreateBlob(ctx context.Context, accessToken string, owner string, repo string, ref string, content *string, encoding *string) (*github.Blob, *github.Response, error) {
+func CreateBlob(ctx context.Context, accessToken string, owner string, repo string, ref string, content *string, encoding *string) (_ *github.Blob, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
 	blob, resp, err := githubClient.Git.CreateBlob(ctx, owner, repo, &github.Blob{
@@ -124,29 +282,14 @@ func CreateBlob(ctx context.Context, accessToken string, owner string, repo stri
 	return blob, resp, err
 }
 
-func CommitBlob(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, content *string, commitMessage string) (*github.Response, error) {
-	githubClient := ghClient(ctx, accessToken)
+func CommitBlob(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, content *string, commitMessage string) (_ *github.Commit, resp *github.Response, err error) {
+	defer typed(&resp, &err)
 
-	reference, resp, err := githubClient.Git.GetRef(ctx, owner, repo, "refs/heads/"+ref)
-	if err != nil {
-		return resp, err
-	}
-
-	tree, resp, err := getCommitTree(ctx, githubClient, owner, repo, *reference.Object.SHA, []BlobEntry{
+	return CommitBlobs(ctx, accessToken, owner, repo, ref, []BlobEntry{
 		{
 			Path:    path,
 			Content: content,
-		}})
-	if err != nil {
-		return resp, err
-	}
-
-	resp, err = pushCommit(ctx, githubClient, reference, tree, owner, repo, commitMessage)
-	if err != nil {
-		return resp, err
-	}
-
-	return resp, nil
+		}}, commitMessage)
 }
 
 type BlobEntry struct {
@@ -171,48 +314,134 @@ func getCommitTree(ctx context.Context, githubClient *github.Client, owner strin
 	return githubClient.Git.CreateTree(ctx, owner, repo, sha, entries)
 }
 
-func CommitBlobs(ctx context.Context, accessToken string, owner string, repo string, ref string, items []BlobEntry, commitMessage string) (*github.Response, error) {
+// CommitBlobs commits the items on top of the branch head, when the head moves while committing
+// the commit is rebuilt on the new head as long as the concurrent changes touched other files
+func CommitBlobs(ctx context.Context, accessToken string, owner string, repo string, ref string, items []BlobEntry, commitMessage string) (*github.Commit, *github.Response, error) {
+	return CommitBlobsAt(ctx, accessToken, owner, repo, ref, "", items, commitMessage)
+}
+
+// CommitBlobsAt commits the items prepared from the base commit on top of the branch head,
+// commits landing after the base are kept as long as they touched other files, otherwise
+// it fails with ErrConflict. Without a base the head at the time of the call is the base.
+func CommitBlobsAt(ctx context.Context, accessToken string, owner string, repo string, ref string, base string, items []BlobEntry, commitMessage string) (_ *github.Commit, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
 	reference, resp, err := githubClient.Git.GetRef(ctx, owner, repo, "refs/heads/"+ref)
 	if err != nil {
-		return resp, err
+		return nil, resp, err
+	}
+	if len(base) == 0 {
+		base = reference.Object.GetSHA()
 	}
 
-	tree, resp, err := getCommitTree(ctx, githubClient, owner, repo, *reference.Object.SHA, items)
-	if err != nil {
-		return resp, err
+	for attempt := 0; ; attempt++ {
+		head := reference.Object.GetSHA()
+		if head != base {
+			// the branch head moved, check whether the concurrent changes touched our files
+			cmp, resp, err := githubClient.Repositories.CompareCommits(ctx, owner, repo, base, head, nil)
+			if err != nil {
+				return nil, resp, err
+			}
+			if conflicting(cmp, items) {
+				return nil, nil, ErrConflict
+			}
+			base = head
+		}
+
+		tree, resp, err := getCommitTree(ctx, githubClient, owner, repo, head, items)
+		if err != nil {
+			return nil, resp, err
+		}
+
+		commit, resp, err := pushCommit(ctx, githubClient, reference, tree, owner, repo, commitMessage)
+		if err == nil {
+			return commit, resp, nil
+		}
+		if !isNonFastForward(err) || attempt == commitRetries {
+			return nil, resp, err
+		}
+
+		reference, resp, err = githubClient.Git.GetRef(ctx, owner, repo, "refs/heads/"+ref)
+		if err != nil {
+			return nil, resp, err
+		}
 	}
+}
 
-	resp, err = pushCommit(ctx, githubClient, reference, tree, owner, repo, commitMessage)
-	if err != nil {
-		return resp, err
+// isNonFastForward reports whether a ref update failed because the ref moved,
+// github answers other invalid updates with the same status
+func isNonFastForward(err error) bool {
+	var e *github.ErrorResponse
+	if !errors.As(err, &e) || e.Response == nil || e.Response.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	return strings.Contains(strings.ToLower(e.Message), "fast forward")
+}
+
+// conflicting reports whether the commits of a comparison may have touched the items, a head which
+// is not ahead of the base or a file list capped by github can't tell, so both count as conflicts
+func conflicting(cmp *github.CommitsComparison, items []BlobEntry) bool {
+	if cmp.GetStatus() != "ahead" || len(cmp.Files) >= compareFilesLimit {
+		return true
+	}
+	return touchesItems(cmp.Files, items)
+}
+
+func touchesItems(files []*github.CommitFile, items []BlobEntry) bool {
+	paths := make(map[string]bool)
+	for _, i := range items {
+		paths[i.Path] = true
+	}
+
+	for _, f := range files {
+		if paths[f.GetFilename()] || paths[f.GetPreviousFilename()] {
+			return true
+		}
 	}
 
-	return resp, nil
+	return false
+}
+
+// withTrailers appends the moonbase marker and the identity of the request to the commit message
+func withTrailers(ctx context.Context, commitMessage string) string {
+	trailers := []string{commitTrailer}
+	if user, ok := ctx.Value(ctxKeyUser).(string); ok && len(user) > 0 {
+		trailers = append(trailers, fmt.Sprintf("%s: %s", userTrailer, user))
+	}
+	if ed := EditorFromContext(ctx); ed != nil && ed.Delegated {
+		trailers = append(trailers, fmt.Sprintf("%s: %s <%s>", onBehalfOfTrailer, ed.Name, ed.Email))
+	}
+	return commitMessage + "\n\n" + strings.Join(trailers, "\n")
 }
 
 // pushCommit creates the commit in the given reference using the given tree
-func pushCommit(ctx context.Context, githubClient *github.Client, ref *github.Reference, tree *github.Tree, owner string, repo string, commitMessage string) (*github.Response, error) {
+func pushCommit(ctx context.Context, githubClient *github.Client, ref *github.Reference, tree *github.Tree, owner string, repo string, commitMessage string) (*github.Commit, *github.Response, error) {
 	// Get the parent commit
 	parent, resp, err := githubClient.Repositories.GetCommit(ctx, owner, repo, *ref.Object.SHA, nil)
 	if err != nil {
-		return resp, err
+		return nil, resp, err
 	}
 	parent.Commit.SHA = parent.SHA
 
+	// commits are authored by the editor who made the change, whichever credential pushes them
+	commitMessage = withTrailers(ctx, commitMessage)
 	commit := &github.Commit{Message: &commitMessage, Tree: tree, Parents: []*github.Commit{parent.Commit}}
+	if ed := EditorFromContext(ctx); ed != nil {
+		commit.Author = &github.CommitAuthor{Name: &ed.Name, Email: &ed.Email}
+	}
 	newCommit, resp, err := githubClient.Git.CreateCommit(ctx, owner, repo, commit)
 	if err != nil {
-		return resp, err
+		return nil, resp, err
 	}
-
 	// Attach the commit to the branch
-	ref.Object.SHA = newCommit.SHA
-	_, resp, err = githubClient.Git.UpdateRef(ctx, owner, repo, ref, false)
+	_, resp, err = githubClient.Git.UpdateRef(ctx, owner, repo, &github.Reference{Ref: ref.Ref, Object: &github.GitObject{SHA: newCommit.SHA}}, false)
 	if err != nil {
-		return resp, err
+		return nil, resp, err
 	}
+	ref.Object.SHA = newCommit.SHA
+	InvalidateRef(owner, repo, ref.GetRef())
 
 	// Crreate pull request if needed
 	/*rep, resp, err := githubClient.Repositories.Get(ctx, owner, repo)
@@ -228,7 +457,7 @@ func pushCommit(ctx context.Context, githubClient *github.Client, ref *github.Re
 		}
 	}*/
 
-	return resp, err
+	return newCommit, resp, err
 }
 
 // createPR creates a pull request
@@ -249,70 +478,123 @@ func createPR(ctx context.Context, githubClient *github.Client, owner string, re
 	return resp, err
 }
 
-func DeleteFolder(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, commitMessage string) (*github.Response, error) {
+func DeleteFolder(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, commitMessage string) (resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
-	resp, items, err := getFolderContentRecursive(ctx, githubClient, owner, repo, ref, path)
+	resp, items, err := getFolderContentRecursive(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return resp, err
 	}
 
 	if len(items) > 0 {
-		resp, err = CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage)
+		_, resp, err = CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage)
 	}
 
-	return resp, nil
+	return resp, err
 }
 
-func DeleteFolders(ctx context.Context, accessToken string, owner string, repo string, ref string, paths []string, commitMessage string) (*github.Response, error) {
+func DeleteFolders(ctx context.Context, accessToken string, owner string, repo string, ref string, paths []string, commitMessage string) (resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
 	delItems := make([]BlobEntry, 0)
 	for _, path := range paths {
-		resp, items, err := getFolderContentRecursive(ctx, githubClient, owner, repo, ref, path)
+		resp, items, err := getFolderContentRecursive(ctx, githubClient, accessToken, owner, repo, ref, path)
 		if err != nil {
 			return resp, err
 		}
 		delItems = append(delItems, items...)
 	}
 
-	var resp *github.Response
 	if len(delItems) > 0 {
-		resp, _ = CommitBlobs(ctx, accessToken, owner, repo, ref, delItems, commitMessage)
+		_, resp, err = CommitBlobs(ctx, accessToken, owner, repo, ref, delItems, commitMessage)
 	}
 
-	return resp, nil
+	return resp, err
 }
 
-func getFolderContentRecursive(ctx context.Context, githubClient *github.Client, owner string, repo string, ref string, path string) (*github.Response, []BlobEntry, error) {
-	_, rc, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
-		Ref: ref,
-	})
+func getFolderContentRecursive(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string, path string) (*github.Response, []BlobEntry, error) {
+	resp, files, err := getFolderFilesRecursive(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return resp, nil, err
 	}
 
 	items := make([]BlobEntry, 0)
-	for _, c := range rc {
-		if *c.Type == "dir" {
-			var folderItems []BlobEntry
-			resp, folderItems, err = getFolderContentRecursive(ctx, githubClient, owner, repo, ref, *c.Path)
-			items = append(items, folderItems...)
-		} else {
-			items = append(items, BlobEntry{
-				Path:    *c.Path,
-				Content: nil,
-			})
+	for _, f := range files {
+		items = append(items, BlobEntry{
+			Path:    *f.Path,
+			Content: nil,
+		})
+	}
+
+	return resp, items, nil
+}
+
+// getFolderFilesRecursive returns every file under path in the order of a depth first walk
+func getFolderFilesRecursive(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string, path string) (*github.Response, []*github.RepositoryContent, error) {
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return resp, nil, err
+	}
+
+	files := make([]*github.RepositoryContent, 0)
+	for _, te := range entries {
+		if te.Type == "blob" {
+			files = append(files, te.content())
 		}
+	}
+
+	return resp, files, nil
+}
+
+// GetMoveFolderEntries returns the entries moving every file under src to dst by reusing the existing blobs
+func GetMoveFolderEntries(ctx context.Context, accessToken string, owner string, repo string, ref string, src string, dst string) (_ []BlobEntry, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	resp, files, err := getFolderFilesRecursive(ctx, githubClient, accessToken, owner, repo, ref, src)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	items := make([]BlobEntry, 0)
+	for _, f := range files {
+		rel, err := filepath.Rel(src, *f.Path)
 		if err != nil {
-			return resp, nil, err
+			return nil, resp, err
 		}
+		items = append(items, BlobEntry{
+			Path: filepath.Join(dst, rel),
+			SHA:  f.SHA,
+		}, BlobEntry{
+			Path:    *f.Path,
+			Content: nil,
+		})
 	}
 
-	return resp, items, nil
+	return items, resp, nil
+}
+
+// MoveFolder moves every file under src to dst, extra items are committed in the same commit
+func MoveFolder(ctx context.Context, accessToken string, owner string, repo string, ref string, src string, dst string, extra []BlobEntry, commitMessage string) (_ *github.Commit, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	items, resp, err := GetMoveFolderEntries(ctx, accessToken, owner, repo, ref, src, dst)
+	if err != nil {
+		return nil, resp, err
+	}
+	items = append(items, extra...)
+
+	return CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage)
 }
 
-func DeleteFiles(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, commitMessage string, fileNames []string) (*github.Response, error) {
+func DeleteFiles(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, commitMessage string, fileNames []string) (resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
 	_, rc, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
@@ -339,7 +621,7 @@ func DeleteFiles(ctx context.Context, accessToken string, owner string, repo str
 	}
 
 	if len(items) > 0 {
-		resp, err = CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage)
+		_, resp, err = CommitBlobs(ctx, accessToken, owner, repo, ref, items, commitMessage)
 	}
 	return resp, err
 }
@@ -368,7 +650,9 @@ func GetDeleteFileEntries(ctx context.Context, accessToken string, owner string,
 	return items, nil
 }
 
-func GetCommits(ctx context.Context, accessToken string, owner string, repo string, ref string) ([]*github.RepositoryCommit, *github.Response, error) {
+func GetCommits(ctx context.Context, accessToken string, owner string, repo string, ref string) (_ []*github.RepositoryCommit, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	rc, resp, err := ghClient(ctx, accessToken).Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
 		SHA: ref,
 		ListOptions: github.ListOptions{
@@ -385,92 +669,96 @@ func GetCommits(ctx context.Context, accessToken string, owner string, repo stri
 
 func GetDirectorySha(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) string {
 	githubClient := ghClient(ctx, accessToken)
-	sha, _, _ := getDirectorySha(ctx, githubClient, owner, repo, ref, path)
+	sha, _, _ := getDirectorySha(ctx, githubClient, accessToken, owner, repo, ref, path)
 	return sha
 }
 
-func getDirectorySha(ctx context.Context, githubClient *github.Client, owner string, repo string, ref string, path string) (string, *github.Response, error) {
-	if len(path) > 0 {
-		parentPath := filepath.Dir(path)
-		_, dc, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, parentPath, &github.RepositoryContentGetOptions{
-			Ref: ref,
-		})
-		if err != nil {
-			return "", resp, err
-		}
-
-		for _, te := range dc {
-			if *te.Type == "dir" && *te.Path == path {
-				return *te.SHA, resp, err
-			}
-		}
-		return "", resp, err
+// getDirectorySha returns the tree sha of the folder at path, the commit sha for the repository root
+// and an empty sha when there is no folder at path
+func getDirectorySha(ctx context.Context, githubClient *github.Client, accessToken string, owner string, repo string, ref string, path string) (string, *github.Response, error) {
+	te, resp, err := lookupPath(ctx, githubClient, accessToken, owner, repo, ref, path)
+	if errors.Is(err, ErrNotFound) {
+		return "", resp, nil
 	}
-
-	br, resp, err := githubClient.Repositories.GetBranch(ctx, owner, repo, ref, false)
 	if err != nil {
 		return "", resp, err
 	}
-
-	if br.Commit != nil {
-		return *br.Commit.SHA, resp, err
+	if te.Type != "tree" {
+		return "", resp, nil
 	}
-	return "", resp, err
+
+	return te.SHA, resp, nil
 }
 
-func GetContentsRecursive_old(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) ([]*github.RepositoryContent, *github.Response, error) {
+func GetArchivedContents(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
-	sha, resp, err := getDirectorySha(ctx, githubClient, owner, repo, ref, path)
-	if err != nil {
-		return nil, resp, err
+	var opt *github.RepositoryContentGetOptions
+	if len(path) > 0 {
+		dirSha, resp, err := getDirectorySha(ctx, githubClient, accessToken, owner, repo, ref, path)
+		if err != nil {
+			return nil, resp, err
+		}
+		opt = &github.RepositoryContentGetOptions{
+			Ref: dirSha,
+		}
 	}
+	return readArchive(ctx, githubClient, owner, repo, opt)
+}
 
-	if sha == "" {
-		sha = "main"
-	}
-	tree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, sha, true)
+// GetArchive returns every file of the repository at ref read from a single tarball,
+// paths are relative to the repository root
+func GetArchive(ctx context.Context, accessToken string, owner string, repo string, ref string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	rcs, resp, err := readArchive(ctx, githubClient, owner, repo, &github.RepositoryContentGetOptions{Ref: ref})
 	if err != nil {
 		return nil, resp, err
 	}
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, te := range tree.Entries {
-		if *te.Type == "blob" {
-			rc, _, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, filepath.Join(path, *te.Path), &github.RepositoryContentGetOptions{})
-			if err != nil {
-				return nil, resp, err
-			}
-			rcs = append(rcs, rc)
-		}
+	for _, rc := range rcs {
+		p := strings.TrimPrefix(rc.GetPath(), "/")
+		rc.Path = &p
 	}
 
 	return rcs, resp, nil
 }
 
-func GetArchivedContents(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) ([]*github.RepositoryContent, *github.Response, error) {
-	githubClient := ghClient(ctx, accessToken)
+// GetChangedFiles returns the files changed between two commits, complete is false
+// when github truncated the list and the changes have to be found otherwise
+func GetChangedFiles(ctx context.Context, accessToken string, owner string, repo string, base string, head string) (_ []*github.CommitFile, _ bool, resp *github.Response, err error) {
+	defer typed(&resp, &err)
 
-	var opt *github.RepositoryContentGetOptions
-	if len(path) > 0 {
-		dirSha, resp, err := getDirectorySha(ctx, githubClient, owner, repo, ref, path)
-		if err != nil {
-			return nil, resp, err
-		}
-		opt = &github.RepositoryContentGetOptions{
-			Ref: dirSha,
-		}
+	cmp, resp, err := ghClient(ctx, accessToken).Repositories.CompareCommits(ctx, owner, repo, base, head, nil)
+	if err != nil {
+		return nil, false, resp, err
 	}
+
+	return cmp.Files, len(cmp.Files) < compareFilesLimit, resp, nil
+}
+
+func readArchive(ctx context.Context, githubClient *github.Client, owner string, repo string, opt *github.RepositoryContentGetOptions) ([]*github.RepositoryContent, *github.Response, error) {
 	url, resp, err := githubClient.Repositories.GetArchiveLink(ctx, owner, repo, github.Tarball, opt, false)
 	if err != nil {
 		return nil, resp, err
 	}
 
-	res, err := http.Get(url.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return nil, resp, err
+	}
+	res, err := downloadClient.Do(req)
 	if err != nil {
 		return nil, resp, err
 	}
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, resp, statusError(res.StatusCode, fmt.Errorf("archive download failed: %s", res.Status))
+	}
 
 	dir, err := os.MkdirTemp("", "git-archive")
 	if err != nil {
@@ -519,133 +807,159 @@ func GetArchivedContents(ctx context.Context, accessToken string, owner string,
 	return rcs, resp, nil
 }
 
-func GetContentsRecursive(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) ([]*github.RepositoryContent, *github.Response, error) {
+// GetContentsRecursive returns every file under path including its content, listed from a single
+// tree and read as blobs, so folders of any size are read without the limits of the contents api
+func GetContentsRecursive(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
-	_, rc, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
-		Ref: ref,
-	})
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return nil, resp, err
 	}
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, c := range rc {
-		switch *c.Type {
-		case "file":
-			b, err := downloadFile(*c.DownloadURL)
-			if err != nil {
-				return nil, resp, err
-			}
-			content := string(b)
-			if strings.Contains(content, "Not found") {
-				log.Fatal("couldn't download file")
-			}
-			c.Content = &content
-			rcs = append(rcs, c)
-		case "dir":
-			rcr, _, err := GetContentsRecursive(ctx, accessToken, owner, repo, ref, *c.Path)
-			if err != nil {
-				return nil, resp, err
-			}
-			rcs = append(rcs, rcr...)
+	files := make([]*treeEntry, 0)
+	for _, te := range entries {
+		if te.Type == "blob" {
+			files = append(files, te)
 		}
 	}
 
+	rcs, err := blobContents(ctx, githubClient, owner, repo, files)
+	if err != nil {
+		return nil, resp, err
+	}
+
 	return rcs, resp, nil
 }
 
-func GetAllLocaleContents(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) ([]*github.RepositoryContent, *github.Response, error) {
+// ListFiles returns every file under path without content, listed from a single tree
+func ListFiles(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
-	_, rc, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
-		Ref: ref,
-	})
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return nil, resp, err
 	}
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, c := range rc {
-		if *c.Type == "file" && (*c.Name == content.JsonSchemaName || filepath.Ext(*c.Name) == ".json") {
-			b, err := downloadFile(*c.DownloadURL)
-			if err != nil {
-				return nil, resp, err
-			}
-			content := string(b)
-			if strings.Contains(content, "Not found") {
-				log.Fatal("couldn't download file")
-			}
-			c.Content = &content
-			rcs = append(rcs, c)
+	files := make([]*github.RepositoryContent, 0)
+	for _, te := range entries {
+		if te.Type == "blob" {
+			files = append(files, te.content())
 		}
 	}
 
-	return rcs, resp, nil
+	return files, resp, nil
 }
 
-func GetAllLocaleContentsWithTree(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, prefix string) ([]*github.RepositoryContent, *github.Response, error) {
+// GetAllLocaleContents returns every json file of the folder at path including its content
+func GetAllLocaleContents(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
-	sha, resp, err := getDirectorySha(ctx, githubClient, owner, repo, ref, path)
+
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return nil, resp, err
 	}
-	if sha == "" {
-		sha = "main"
+
+	files := make([]*treeEntry, 0)
+	for _, te := range entries {
+		if te.Type == "blob" && isChild(te.Path, path) && filepath.Ext(te.Path) == ".json" {
+			files = append(files, te)
+		}
 	}
-	tree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, sha, false)
+
+	rcs, err := blobContents(ctx, githubClient, owner, repo, files)
 	if err != nil {
 		return nil, resp, err
 	}
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, te := range tree.Entries {
-		if *te.Type == "blob" && (strings.HasPrefix(*te.Path, prefix) || strings.HasSuffix(*te.Path, content.JsonSchemaName)) {
-			rc, _, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, filepath.Join(path, *te.Path), &github.RepositoryContentGetOptions{})
-			if err != nil {
-				return nil, resp, err
-			}
-			c, err := rc.GetContent()
-			if err != nil {
-				return nil, resp, err
-			}
-			rc.Content = &c
-			rcs = append(rcs, rc)
+	return rcs, resp, nil
+}
+
+// blobContents returns the files with their content, blobs are fetched concurrently by sha
+func blobContents(ctx context.Context, githubClient *github.Client, owner string, repo string, files []*treeEntry) ([]*github.RepositoryContent, error) {
+	return fanout.Map(ctx, fetchParallelism, files, func(ctx context.Context, te *treeEntry) (*github.RepositoryContent, error) {
+		b, _, err := getBlobCached(ctx, githubClient, owner, repo, te.SHA)
+		if err != nil {
+			return nil, err
+		}
+		c := te.content()
+		content := string(b)
+		c.Content = &content
+		return c, nil
+	})
+}
+
+func GetAllLocaleContentsWithTree(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, prefix string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
+	githubClient := ghClient(ctx, accessToken)
+
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	files := make([]*treeEntry, 0)
+	for _, te := range entries {
+		name := filepath.Base(te.Path)
+		if te.Type == "blob" && isChild(te.Path, path) && (strings.HasPrefix(name, prefix) || name == content.JsonSchemaName) {
+			files = append(files, te)
 		}
 	}
 
+	rcs, err := blobContents(ctx, githubClient, owner, repo, files)
+	if err != nil {
+		return nil, resp, err
+	}
+
 	return rcs, resp, nil
 }
 
-func GetFilesContent(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, files []string) ([]*github.RepositoryContent, *github.Response, error) {
+func GetFilesContent(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, files []string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
-	var resp *github.Response
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, fn := range files {
-		fnWithPath := filepath.Join(path, fn)
-		rc, _, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, fnWithPath, &github.RepositoryContentGetOptions{})
-		if err != nil {
-			return nil, resp, err
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	blobs := make(map[string]*treeEntry)
+	for _, te := range entries {
+		if te.Type == "blob" && isChild(te.Path, path) {
+			blobs[filepath.Base(te.Path)] = te
 		}
+	}
 
-		//when using the object media type, the content field will be an empty string and the encoding field will be "none"
-		if rc.Encoding == nil || *rc.Encoding != "none" {
-			c, err := rc.GetContent()
-			if err != nil {
-				return nil, resp, err
-			}
-			rc.Content = &c
+	selected := make([]*treeEntry, 0, len(files))
+	for _, fn := range files {
+		te, ok := blobs[fn]
+		if !ok {
+			resp, err := notFound(filepath.Join(path, fn))
+			return nil, resp, err
 		}
+		selected = append(selected, te)
+	}
 
-		rcs = append(rcs, rc)
+	rcs, err := blobContents(ctx, githubClient, owner, repo, selected)
+	if err != nil {
+		return nil, resp, err
 	}
 
 	return rcs, resp, nil
 }
 
 // "https://api.github.com/search/code?q=1LH4vkAWCVPt56aKmJZIxW+in:file+filename:en.json+path:_content/currency+repo:moonwalker/cms-instaslots"
-func SearchByID(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, id string, locale string) (*github.RepositoryContent, *github.Response, error) {
+func SearchByID(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, id string, locale string) (_ *github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 	q := fmt.Sprintf("%s+in:file+filename:%s.json+path:%s+repo:%s/%s", id, locale, path, owner, repo)
 	query, err := url.QueryUnescape(q)
@@ -665,56 +979,45 @@ func SearchByID(ctx context.Context, accessToken string, owner string, repo stri
 		return rc, resp, err
 	}
 
-	return nil, resp, errors.New("not found")
+	resp, err = notFound(path + "/" + locale + ".json#" + id)
+	return nil, resp, err
 }
 
-func SearchContentsByID(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, id string) ([]*github.RepositoryContent, *github.Response, error) {
+func SearchContentsByID(ctx context.Context, accessToken string, owner string, repo string, ref string, path string, id string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
-	_, rc, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
-		Ref: ref,
-	})
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return nil, resp, err
 	}
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, c := range rc {
-		if *c.Type == "file" && *c.Name != content.JsonSchemaName {
-			b, err := downloadFile(*c.DownloadURL)
-			if err != nil {
-				return nil, resp, err
-			}
-			content := string(b)
-			if strings.Contains(content, "Not found") {
-				log.Fatal("couldn't download file")
-			}
-			if strings.HasPrefix(content, fmt.Sprintf(`{"id":"%s",`, id)) {
-				c.Content = &content
-				rcs = append(rcs, c)
-			}
+	blobs := make([]*treeEntry, 0)
+	for _, te := range entries {
+		if te.Type == "blob" && isChild(te.Path, path) && filepath.Base(te.Path) != content.JsonSchemaName {
+			blobs = append(blobs, te)
 		}
 	}
 
-	return rcs, resp, nil
-}
-
-func downloadFile(downloadURL string) ([]byte, error) {
-	resp, err := http.Get(downloadURL)
+	files, err := blobContents(ctx, githubClient, owner, repo, blobs)
 	if err != nil {
-		return nil, err
+		return nil, resp, err
 	}
-	defer resp.Body.Close()
 
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	rcs := make([]*github.RepositoryContent, 0)
+	for _, c := range files {
+		if strings.HasPrefix(*c.Content, fmt.Sprintf(`{"id":"%s",`, id)) {
+			rcs = append(rcs, c)
+		}
 	}
 
-	return b, nil
+	return rcs, resp, nil
 }
 
-func GetSchema(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (*github.RepositoryContent, *github.Response, error) {
+func GetSchema(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ *github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
 	schemaPath := filepath.Join(path, content.JsonSchemaName)
@@ -728,37 +1031,27 @@ func GetSchema(ctx context.Context, accessToken string, owner string, repo strin
 	return fc, resp, nil
 }
 
-func GetSchemasRecursive(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) ([]*github.RepositoryContent, *github.Response, error) {
+func GetSchemasRecursive(ctx context.Context, accessToken string, owner string, repo string, ref string, path string) (_ []*github.RepositoryContent, resp *github.Response, err error) {
+	defer typed(&resp, &err)
+
 	githubClient := ghClient(ctx, accessToken)
 
-	sha, resp, err := getDirectorySha(ctx, githubClient, owner, repo, ref, path)
+	entries, resp, err := pathTree(ctx, githubClient, accessToken, owner, repo, ref, path)
 	if err != nil {
 		return nil, resp, err
 	}
-	if sha == "" {
-		sha = "main"
+
+	schemas := make([]*treeEntry, 0)
+	for _, te := range entries {
+		if te.Type == "blob" && filepath.Base(te.Path) == content.JsonSchemaName {
+			schemas = append(schemas, te)
+		}
 	}
 
-	tree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, sha, false)
+	rcs, err := blobContents(ctx, githubClient, owner, repo, schemas)
 	if err != nil {
 		return nil, resp, err
 	}
 
-	rcs := make([]*github.RepositoryContent, 0)
-	for _, te := range tree.Entries {
-		if *te.Type == "tree" {
-			subTree, resp, err := githubClient.Git.GetTree(ctx, owner, repo, *te.SHA, false)
-			if err != nil {
-				return nil, resp, err
-			}
-			tree.Entries = append(tree.Entries, subTree.Entries...)
-		} else if *te.Type == "blob" && strings.HasSuffix(*te.Path, content.JsonSchemaName) {
-			rc, _, resp, err := githubClient.Repositories.GetContents(ctx, owner, repo, filepath.Join(path, *te.Path), &github.RepositoryContentGetOptions{})
-			if err != nil {
-				return nil, resp, err
-			}
-			rcs = append(rcs, rc)
-		}
-	}
 	return rcs, resp, nil
 }
@@ -0,0 +1,226 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v48/github"
+)
+
+// fakeRepo answers the git api calls of a commit on a branch,
+// changed lists the files each commit of other writers touched
+type fakeRepo struct {
+	sync.Mutex
+	head    string
+	changed map[string][]string
+	// commits of other writers landing right before the next ref updates
+	race []string
+	// comparisons are filled up to this many files
+	listed  int
+	commits int
+}
+
+func (f *fakeRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	reply := func(status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/repos/o/r/")
+	switch {
+	case r.Method == http.MethodGet && p == "git/ref/heads/main":
+		reply(200, map[string]any{"ref": "refs/heads/main", "object": map[string]any{"sha": f.head}})
+	case r.Method == http.MethodPost && p == "git/trees":
+		reply(201, map[string]any{"sha": "tree"})
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "commits/"):
+		sha := strings.TrimPrefix(p, "commits/")
+		reply(200, map[string]any{"sha": sha, "commit": map[string]any{"sha": sha}})
+	case r.Method == http.MethodPost && p == "git/commits":
+		f.commits++
+		reply(201, map[string]any{"sha": fmt.Sprintf("own%d", f.commits)})
+	case r.Method == http.MethodPatch && p == "git/refs/heads/main":
+		if len(f.race) > 0 {
+			f.head, f.race = f.race[0], f.race[1:]
+			reply(422, map[string]any{"message": "Update is not a fast forward"})
+			return
+		}
+		var body struct{ SHA string }
+		json.NewDecoder(r.Body).Decode(&body)
+		f.head = body.SHA
+		reply(200, map[string]any{"ref": "refs/heads/main", "object": map[string]any{"sha": f.head}})
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "compare/"):
+		base, head, _ := strings.Cut(strings.TrimPrefix(p, "compare/"), "...")
+		files := make([]map[string]any, 0)
+		for _, name := range f.changed[head] {
+			files = append(files, map[string]any{"filename": name})
+		}
+		for i := len(files); i < f.listed; i++ {
+			files = append(files, map[string]any{"filename": fmt.Sprintf("other/%d.json", i)})
+		}
+		status := "ahead"
+		if base == head {
+			status = "identical"
+		}
+		reply(200, map[string]any{"status": status, "files": files})
+	default:
+		reply(404, map[string]any{"message": "Not Found"})
+	}
+}
+
+func fakeGitHub(t *testing.T, h http.Handler) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	prev := http.DefaultTransport
+	http.DefaultTransport = rewriteTransport{u, prev}
+	t.Cleanup(func() { http.DefaultTransport = prev })
+}
+
+// rewriteTransport sends the requests of the github client to the fake
+type rewriteTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (rt rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return rt.base.RoundTrip(r)
+}
+
+func TestCommitBlobsRebase(t *testing.T) {
+	items := []BlobEntry{{Path: "content/posts/a/en.json", Content: github.String("{}")}}
+
+	f := &fakeRepo{head: "c2", changed: map[string][]string{
+		"c2": {"content/posts/b/en.json"},
+		"c3": {"content/posts/c/en.json"},
+	}, race: []string{"c3"}}
+	fakeGitHub(t, f)
+
+	// read at c1, c2 landed before the commit and c3 while pushing it
+	commit, _, err := CommitBlobsAt(context.Background(), "token", "o", "r", "main", "c1", items, "update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.GetSHA() != "own2" || f.head != "own2" {
+		t.Errorf("expected the commit rebased on c3, got %s at head %s", commit.GetSHA(), f.head)
+	}
+}
+
+func TestCommitBlobsConflict(t *testing.T) {
+	items := []BlobEntry{{Path: "content/posts/a/en.json", Content: github.String("{}")}}
+
+	tests := []struct {
+		name string
+		repo *fakeRepo
+	}{
+		{"changed since read", &fakeRepo{head: "c2", changed: map[string][]string{"c2": {"content/posts/a/en.json"}}}},
+		{"changed while pushing", &fakeRepo{head: "c1", changed: map[string][]string{"c2": {"content/posts/a/en.json"}}, race: []string{"c2"}}},
+		{"capped comparison", &fakeRepo{head: "c2", changed: map[string][]string{}, listed: compareFilesLimit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeGitHub(t, tt.repo)
+
+			_, resp, err := CommitBlobsAt(context.Background(), "token", "o", "r", "main", "c1", items, "update")
+			if !errors.Is(err, ErrConflict) || resp.StatusCode != http.StatusConflict {
+				t.Errorf("expected a conflict, got %v", err)
+			}
+			if tt.repo.head == "own1" {
+				t.Error("conflicting commit pushed")
+			}
+		})
+	}
+}
+
+func TestIsNonFastForward(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusUnprocessableEntity}
+	if !isNonFastForward(&github.ErrorResponse{Response: resp, Message: "Update is not a fast forward"}) {
+		t.Error("expected a non fast forward update")
+	}
+	if isNonFastForward(&github.ErrorResponse{Response: resp, Message: "Invalid request"}) {
+		t.Error("unexpected non fast forward for another invalid request")
+	}
+}
+
+func TestGetContentsRecursive(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		truncated bool
+		want      string
+	}{
+		{"nested", "content", false, `content/pages/home/en.json={"id":"home"},content/posts/a/en.json={"id":"a"},content/posts/b/en.json={"id":"b"}`},
+		{"folder", "content/posts/b", false, `content/posts/b/en.json={"id":"b"}`},
+		{"truncated", "content/posts", true, `content/posts/a/en.json={"id":"a"},content/posts/b/en.json={"id":"b"}`},
+		{"missing folder", "content/missing", false, ""},
+		{"truncated missing folder", "content/posts/c", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeGit(t)
+			f.truncated["c1"] = tt.truncated
+
+			rcs, resp, err := GetContentsRecursive(context.Background(), "alice", "o", testRepo(t), "main", tt.path)
+			if len(tt.want) == 0 {
+				if !errors.Is(err, ErrNotFound) || resp.StatusCode != http.StatusNotFound {
+					t.Errorf("expected not found, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := make([]string, 0)
+			for _, rc := range rcs {
+				files = append(files, rc.GetPath()+"="+*rc.Content)
+			}
+			if got := strings.Join(files, ","); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	f := newFakeGit(t)
+	f.truncated["c1"] = true
+	repo := testRepo(t)
+
+	rcs, _, err := ListFiles(context.Background(), "alice", "o", repo, "main", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make([]string, 0)
+	for _, rc := range rcs {
+		if rc.Content != nil {
+			t.Errorf("unexpected content of %s", rc.GetPath())
+		}
+		files = append(files, rc.GetPath())
+	}
+	if got, want := strings.Join(files, ","), "content/pages/home/en.json,content/posts/a/en.json,content/posts/b/en.json"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if n := f.count("git/blobs/b-a"); n != 0 {
+		t.Errorf("expected no blob reads, got %d", n)
+	}
+
+	_, _, err = ListFiles(context.Background(), "alice", "o", repo, "main", "README.md")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found for a file, got %v", err)
+	}
+}
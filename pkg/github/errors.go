@@ -0,0 +1,128 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/transport"
+)
+
+// kinds of errors of github calls, test with errors.Is
+var (
+	ErrNotFound     = errors.New("not found on github")
+	ErrUnauthorized = errors.New("github credentials rejected")
+	ErrForbidden    = errors.New("forbidden by github")
+	// ErrConflict is returned when a commit can't be applied because the same files changed concurrently
+	ErrConflict    = errors.New("conflicting changes on ref")
+	ErrRateLimited = errors.New("github rate limit exceeded")
+	ErrUnavailable = errors.New("github unavailable")
+)
+
+// Error is the error of a failed github call, the kind is nil for rejections which
+// are none of the above, like invalid requests, and for failures on this side
+type Error struct {
+	Kind       error
+	StatusCode int
+	// RetryAfter is set for rate limited calls
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// typed turns the error of a call into an *Error, along with a response
+// carrying its status so callers never get a nil response with an error
+func typed(resp **github.Response, err *error) {
+	if *err == nil {
+		return
+	}
+
+	var r *github.Response
+	if resp != nil {
+		r = *resp
+	}
+	e := classify(r, *err)
+	*err = e
+
+	if resp != nil && (r == nil || r.Response == nil) {
+		*resp = cachedResponse(e.StatusCode)
+	}
+}
+
+func classify(resp *github.Response, err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	switch {
+	case errors.Is(err, ErrConflict):
+		return &Error{ErrConflict, http.StatusConflict, 0, err}
+	case errors.As(err, &rateErr):
+		return &Error{ErrRateLimited, http.StatusTooManyRequests, time.Until(rateErr.Rate.Reset.Time), err}
+	case errors.As(err, &abuseErr):
+		return &Error{ErrRateLimited, http.StatusTooManyRequests, abuseErr.GetRetryAfter(), err}
+	case errors.Is(err, transport.ErrCircuitOpen):
+		return &Error{ErrUnavailable, http.StatusServiceUnavailable, 0, err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{ErrUnavailable, http.StatusGatewayTimeout, 0, err}
+	}
+
+	// without a response only failures to reach github say something about its availability
+	if resp == nil || resp.Response == nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return &Error{ErrUnavailable, http.StatusBadGateway, 0, err}
+		}
+		return &Error{nil, http.StatusInternalServerError, 0, err}
+	}
+	return statusError(resp.StatusCode, err)
+}
+
+// statusError types an error by the status github answered with
+func statusError(statusCode int, err error) *Error {
+	switch {
+	case statusCode == http.StatusNotFound:
+		return &Error{ErrNotFound, statusCode, 0, err}
+	case statusCode == http.StatusUnauthorized:
+		return &Error{ErrUnauthorized, statusCode, 0, err}
+	case statusCode == http.StatusForbidden:
+		return &Error{ErrForbidden, statusCode, 0, err}
+	case statusCode == http.StatusConflict:
+		return &Error{ErrConflict, statusCode, 0, err}
+	case statusCode == http.StatusTooManyRequests:
+		return &Error{ErrRateLimited, statusCode, 0, err}
+	case statusCode >= 500:
+		return &Error{ErrUnavailable, http.StatusBadGateway, 0, err}
+	case statusCode < 400:
+		// github answered fine, so the call failed on this side, like decoding the answer
+		return &Error{nil, http.StatusInternalServerError, 0, err}
+	}
+	return &Error{nil, statusCode, 0, err}
+}
+
+func invalid(msg string) (*github.Response, error) {
+	return cachedResponse(http.StatusBadRequest), &Error{nil, http.StatusBadRequest, 0, errors.New(msg)}
+}
+
+func notFound(path string) (*github.Response, error) {
+	return cachedResponse(http.StatusNotFound), &Error{ErrNotFound, http.StatusNotFound, 0, fmt.Errorf("not found: %s", path)}
+}
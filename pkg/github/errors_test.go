@@ -0,0 +1,92 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/moonwalker/moonbase/internal/transport"
+)
+
+func TestClassify(t *testing.T) {
+	reset := github.Timestamp{Time: time.Now().Add(time.Minute)}
+	retryAfter := 30 * time.Second
+	netErr := &url.Error{Op: "Get", URL: "https://api.github.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		kind   error
+		code   int
+	}{
+		{"rate limit", 403, &github.RateLimitError{Rate: github.Rate{Reset: reset}}, ErrRateLimited, http.StatusTooManyRequests},
+		{"abuse rate limit", 403, &github.AbuseRateLimitError{RetryAfter: &retryAfter}, ErrRateLimited, http.StatusTooManyRequests},
+		{"circuit open", 0, transport.ErrCircuitOpen, ErrUnavailable, http.StatusServiceUnavailable},
+		{"deadline", 0, fmt.Errorf("get tree: %w", context.DeadlineExceeded), ErrUnavailable, http.StatusGatewayTimeout},
+		{"conflict", 200, ErrConflict, ErrConflict, http.StatusConflict},
+		{"unreachable", 0, netErr, ErrUnavailable, http.StatusBadGateway},
+		{"local failure", 0, errors.New("path not provided"), nil, http.StatusInternalServerError},
+		{"decoding failure", 200, errors.New("invalid character"), nil, http.StatusInternalServerError},
+		{"redirect", 302, errors.New("unexpected redirect"), nil, http.StatusInternalServerError},
+		{"invalid", 422, errors.New("invalid"), nil, http.StatusUnprocessableEntity},
+		{"unauthorized", 401, errors.New("bad credentials"), ErrUnauthorized, http.StatusUnauthorized},
+		{"forbidden", 403, errors.New("forbidden"), ErrForbidden, http.StatusForbidden},
+		{"not found", 404, errors.New("not found"), ErrNotFound, http.StatusNotFound},
+		{"conflict status", 409, errors.New("conflict"), ErrConflict, http.StatusConflict},
+		{"too many requests", 429, errors.New("slow down"), ErrRateLimited, http.StatusTooManyRequests},
+		{"server error", 500, errors.New("oops"), ErrUnavailable, http.StatusBadGateway},
+		{"bad gateway", 502, errors.New("bad gateway"), ErrUnavailable, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *github.Response
+			if tt.status > 0 {
+				resp = cachedResponse(tt.status)
+			}
+
+			e := classify(resp, tt.err)
+			if e.Kind != tt.kind || e.StatusCode != tt.code {
+				t.Errorf("expected %v %d, got %v %d", tt.kind, tt.code, e.Kind, e.StatusCode)
+			}
+			if !errors.Is(e, tt.err) {
+				t.Error("expected the original error to be wrapped")
+			}
+		})
+	}
+}
+
+func TestClassifyRetryAfter(t *testing.T) {
+	retryAfter := 30 * time.Second
+	e := classify(nil, &github.AbuseRateLimitError{RetryAfter: &retryAfter})
+	if e.RetryAfter != retryAfter {
+		t.Errorf("expected retry after %s, got %s", retryAfter, e.RetryAfter)
+	}
+
+	e = classify(nil, &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(time.Minute)}}})
+	if e.RetryAfter <= 0 || e.RetryAfter > time.Minute {
+		t.Errorf("expected retry after the reset, got %s", e.RetryAfter)
+	}
+}
+
+func TestTyped(t *testing.T) {
+	var resp *github.Response
+	err := error(errors.New("path not provided"))
+	typed(&resp, &err)
+	if resp == nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected a response carrying the status, got %v", resp)
+	}
+
+	resp, err = notFound("content/posts")
+	typed(&resp, &err)
+	if !errors.Is(err, ErrNotFound) || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	SignatureHeader = "X-Hub-Signature-256"
+	EventHeader     = "X-GitHub-Event"
+
+	// PushCommitsLimit is the most commits github lists in a push payload
+	PushCommitsLimit = 20
+)
+
+// VerifySignature checks the sha256 hmac signature github sends with webhook deliveries
+func VerifySignature(secret string, body []byte, signature string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// IsOwnCommit reports whether the commit message carries the trailer of commits made by moonbase
+func IsOwnCommit(message string) bool {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	for _, l := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if strings.TrimSpace(l) == commitTrailer {
+			return true
+		}
+	}
+	return false
+}
+
+// Branch returns the branch name of a pushed ref, tags are not branches
+func (p *PushHookPayload) Branch() (string, bool) {
+	if !strings.HasPrefix(p.Ref, "refs/heads/") {
+		return "", false
+	}
+	return strings.TrimPrefix(p.Ref, "refs/heads/"), true
+}
@@ -0,0 +1,18 @@
+package github
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIsOwnCommit(t *testing.T) {
+	if !IsOwnCommit(withTrailers(context.Background(), "feat(posts): create/update a")) {
+		t.Error("expected a commit made by moonbase")
+	}
+	if IsOwnCommit("feat(posts): edited by hand\n\nSigned-off-by: someone") {
+		t.Error("unexpected commit made by moonbase")
+	}
+	if IsOwnCommit("fix: mention " + commitTrailer + " in the subject") {
+		t.Error("the marker counts only as a trailer")
+	}
+}
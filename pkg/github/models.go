@@ -3,7 +3,8 @@ package github
 import "time"
 
 type Owner struct {
-	Name string `json:"name"`
+	Name  string `json:"name"`
+	Login string `json:"login"`
 }
 
 type Repository struct {
@@ -16,6 +17,7 @@ type Repository struct {
 
 type Commit struct {
 	ID        string    `json:"id"`
+	Message   string    `json:"message"`
 	Added     []string  `json:"added"`
 	Removed   []string  `json:"removed"`
 	Modified  []string  `json:"modified"`
@@ -24,8 +26,12 @@ type Commit struct {
 
 type PushHookPayload struct {
 	Ref        string     `json:"ref"`
+	Before     string     `json:"before"`
+	After      string     `json:"after"`
+	Deleted    bool       `json:"deleted"`
 	Repository Repository `json:"repository"`
 	Commit     Commit     `json:"head_commit"`
+	Commits    []Commit   `json:"commits"`
 	Pusher     Pusher     `json:"pusher"`
 }
 